@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/go-yaml/yaml"
+)
+
+// S3_AUTHORIZATION_POLICY_FILE_NAME is the key read by the Lambdas, every uploaded version is also
+// kept under S3_AUTHORIZATION_POLICY_HISTORY_PREFIX
+const S3_AUTHORIZATION_POLICY_FILE_NAME = "authorization.json"
+const S3_AUTHORIZATION_POLICY_HISTORY_PREFIX = "authorization/"
+const AUTHORIZATION_WILDCARD = "*"
+
+// authorizationFunctionNames maps the function names and aliases which can be used in the authorization
+// policy to the function names checked by the Lambdas
+var authorizationFunctionNames = map[string]string{
+	SNOWFLAKE_FUNCTION_ALIAS_REQUESTMACHINEID:         SNOWFLAKE_FUNCTION_NAME_REQUESTMACHINEID,
+	SNOWFLAKE_FUNCTION_ALIAS_GETMACHINEID:             SNOWFLAKE_FUNCTION_NAME_GETMACHINEID,
+	SNOWFLAKE_FUNCTION_ALIAS_LISTMACHINEIDS:           SNOWFLAKE_FUNCTION_NAME_LISTMACHINEIDS,
+	SNOWFLAKE_FUNCTION_ALIAS_RENEWMACHINEID:           SNOWFLAKE_FUNCTION_NAME_RENEWMACHINEID,
+	SNOWFLAKE_FUNCTION_ALIAS_REVOKEMACHINEID:          SNOWFLAKE_FUNCTION_NAME_REVOKEMACHINEID,
+	SNOWFLAKE_FUNCTION_ALIAS_GETMACHINEIDSTATUS:       SNOWFLAKE_FUNCTION_NAME_GETMACHINEIDSTATUS,
+	SNOWFLAKE_FUNCTION_ALIAS_GETZONEPOLICY:            SNOWFLAKE_FUNCTION_NAME_GETZONEPOLICY,
+	SNOWFLAKE_FUNCTION_ALIAS_VALIDATEMACHINEIDREQUEST: SNOWFLAKE_FUNCTION_NAME_VALIDATEMACHINEIDREQUEST,
+	SNOWFLAKE_FUNCTION_ALIAS_SEARCHMACHINEIDS:         SNOWFLAKE_FUNCTION_NAME_SEARCHMACHINEIDS,
+	SNOWFLAKE_FUNCTION_ALIAS_IMPORTMACHINEID:          SNOWFLAKE_FUNCTION_NAME_IMPORTMACHINEID,
+	SNOWFLAKE_FUNCTION_ALIAS_RETIREMACHINEID:          SNOWFLAKE_FUNCTION_NAME_RETIREMACHINEID,
+	SNOWFLAKE_FUNCTION_ALIAS_RESETMACHINEID:           SNOWFLAKE_FUNCTION_NAME_RESETMACHINEID,
+	SNOWFLAKE_FUNCTION_ALIAS_DELETEMACHINEID:          SNOWFLAKE_FUNCTION_NAME_DELETEMACHINEID,
+	SNOWFLAKE_FUNCTION_ALIAS_LISTPENDINGAPPROVALS:     SNOWFLAKE_FUNCTION_NAME_LISTPENDINGAPPROVALS,
+	SNOWFLAKE_FUNCTION_ALIAS_APPROVEMACHINEID:         SNOWFLAKE_FUNCTION_NAME_APPROVEMACHINEID,
+	SNOWFLAKE_FUNCTION_ALIAS_REJECTMACHINEID:          SNOWFLAKE_FUNCTION_NAME_REJECTMACHINEID,
+	SNOWFLAKE_FUNCTION_ALIAS_GETREQUESTSTATUS:         SNOWFLAKE_FUNCTION_NAME_GETREQUESTSTATUS,
+	SNOWFLAKE_FUNCTION_ALIAS_LISTREQUESTS:             SNOWFLAKE_FUNCTION_NAME_LISTREQUESTS,
+	// MACHINE_ID_INVENTORY calls LIST_MACHINE_IDS
+	SNOWFLAKE_FUNCTION_NAME_MACHINEIDINVENTORY: SNOWFLAKE_FUNCTION_NAME_LISTMACHINEIDS,
+}
+
+// AuthorizationRule allows the Snowflake roles, users and accounts it lists to call the operations
+type AuthorizationRule struct {
+	Name       string   `yaml:"name" json:"Name,omitempty"`
+	Roles      []string `yaml:"roles" json:"Roles,omitempty"`
+	Users      []string `yaml:"users" json:"Users,omitempty"`
+	Accounts   []string `yaml:"accounts" json:"Accounts,omitempty"`
+	Operations []string `yaml:"operations" json:"Operations"`
+	TppURLs    []string `yaml:"tppurls" json:"TppURLs,omitempty"`
+	Zones      []string `yaml:"zones" json:"Zones,omitempty"`
+}
+
+// AuthorizationPolicy is read from a YAML or JSON file and uploaded to the bucket as JSON
+type AuthorizationPolicy struct {
+	Version string              `yaml:"version" json:"Version"`
+	Rules   []AuthorizationRule `yaml:"rules" json:"Rules"`
+}
+
+// authorizationFunctionName returns the function name checked by the Lambdas for a name or alias of the policy
+func authorizationFunctionName(operation string) (string, bool) {
+	operation = strings.ToUpper(strings.TrimSpace(operation))
+	if operation == AUTHORIZATION_WILDCARD {
+		return operation, true
+	}
+	if name, ok := authorizationFunctionNames[operation]; ok {
+		return name, true
+	}
+	for _, name := range authorizationFunctionNames {
+		if name == operation {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (r *AuthorizationRule) normalize() error {
+	if len(r.Roles) == 0 && len(r.Users) == 0 && len(r.Accounts) == 0 {
+		return fmt.Errorf("roles, users or accounts are required")
+	}
+	if len(r.Operations) == 0 {
+		return fmt.Errorf("operations are required")
+	}
+	for i, operation := range r.Operations {
+		name, ok := authorizationFunctionName(operation)
+		if !ok {
+			return fmt.Errorf("unknown operation %s", operation)
+		}
+		r.Operations[i] = name
+	}
+	return nil
+}
+
+// createAuthorizationPolicyFile reads and validates the authorization policy file of the config. Aliases of
+// the functions are replaced with their names. Policies without a version get the upload time as version.
+func createAuthorizationPolicyFile(path string) ([]byte, string, error) {
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read authorization policy: %v", err)
+	}
+	policy := AuthorizationPolicy{}
+	// JSON is valid YAML, so both formats are parsed the same way
+	if err := yaml.Unmarshal(fileBytes, &policy); err != nil {
+		return nil, "", fmt.Errorf("failed to parse authorization policy: %v", err)
+	}
+	if len(policy.Rules) == 0 {
+		return nil, "", fmt.Errorf("the authorization policy has no rules, every call would be denied")
+	}
+	for i := range policy.Rules {
+		if err := policy.Rules[i].normalize(); err != nil {
+			return nil, "", fmt.Errorf("rule %d %s: %v", i+1, policy.Rules[i].Name, err)
+		}
+	}
+	if policy.Version == "" {
+		policy.Version = time.Now().UTC().Format("20060102T150405Z")
+	}
+	data, err := json.MarshalIndent(policy, "", " ")
+	if err != nil {
+		return nil, "", err
+	}
+	return data, policy.Version, nil
+}
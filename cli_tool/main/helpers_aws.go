@@ -31,6 +31,19 @@ const LAMBDA_FUNCTION_NAME_LISTMACHINEIDS = "listmachineids"
 const LAMBDA_FUNCTION_NAME_RENEWMACHINEID = "renewmachineid"
 const LAMBDA_FUNCTION_NAME_REVOKEMACHINEID = "revokemachineid"
 const LAMBDA_FUNCTION_NAME_GETMACHINEIDSTATUS = "getmachineidstatus"
+const LAMBDA_FUNCTION_NAME_GETZONEPOLICY = "getzonepolicy"
+const LAMBDA_FUNCTION_NAME_VALIDATEMACHINEIDREQUEST = "validatemachineidrequest"
+const LAMBDA_FUNCTION_NAME_SEARCHMACHINEIDS = "searchmachineids"
+const LAMBDA_FUNCTION_NAME_IMPORTMACHINEID = "importmachineid"
+const LAMBDA_FUNCTION_NAME_RETIREMACHINEID = "retiremachineid"
+const LAMBDA_FUNCTION_NAME_RESETMACHINEID = "resetmachineid"
+const LAMBDA_FUNCTION_NAME_DELETEMACHINEID = "deletemachineid"
+const LAMBDA_FUNCTION_NAME_LISTPENDINGAPPROVALS = "listpendingapprovals"
+const LAMBDA_FUNCTION_NAME_APPROVEMACHINEID = "approvemachineid"
+const LAMBDA_FUNCTION_NAME_REJECTMACHINEID = "rejectmachineid"
+const LAMBDA_FUNCTION_NAME_GETREQUESTSTATUS = "getrequeststatus"
+const LAMBDA_FUNCTION_NAME_LISTREQUESTS = "listrequests"
+
 const AWS_LAMBDA_ROLE_NAME = "lambda-execute-role"
 const AWS_SNOWFLAKE_ROLE_NAME = "snowflake-role"
 const AWS_POLICY_TO_ACCESS_BUCKET = "venafi-lambda-access-to-s3-bucket"
@@ -79,18 +92,79 @@ func GetBucket(svc *s3.Client, bucketName string) (bucket []types.Object, credsI
 	return result.Contents, false, false, nil
 }
 
-func CreateBucket(svc *s3.Client, bucketName string) error {
+// bucketConfiguration returns the location of a new bucket in the region of the AWS config. Buckets in
+// us-east-1 are created without location constraint, S3 rejects a constraint with that region.
+func bucketConfiguration(region string) *types.CreateBucketConfiguration {
+	if region == "" || region == "us-east-1" {
+		return nil
+	}
+	return &types.CreateBucketConfiguration{LocationConstraint: types.BucketLocationConstraint(region)}
+}
+
+func CreateBucket(svc *s3.Client, bucketName string, region string) error {
 	input := &s3.CreateBucketInput{
-		Bucket: aws.String(bucketName),
-		ACL:    types.BucketCannedACLPrivate,
-		CreateBucketConfiguration: &types.CreateBucketConfiguration{
-			LocationConstraint: types.BucketLocationConstraintEuWest1,
-		},
+		Bucket:                    aws.String(bucketName),
+		ACL:                       types.BucketCannedACLPrivate,
+		CreateBucketConfiguration: bucketConfiguration(region),
 	}
 	_, err := svc.CreateBucket(context.TODO(), input)
 	return err
 }
 
+// PutExpirationRule adds a lifecycle rule which removes the objects under the prefix after the number of days,
+// or replaces the rule of an earlier install. The other rules of the bucket are kept.
+func PutExpirationRule(svc *s3.Client, bucketName string, ruleID string, prefix string, days int32) error {
+	rules := []types.LifecycleRule{}
+	current, err := svc.GetBucketLifecycleConfiguration(context.TODO(), &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil && !strings.Contains(err.Error(), "NoSuchLifecycleConfiguration") {
+		return err
+	}
+	if err == nil {
+		for _, rule := range current.Rules {
+			if aws.ToString(rule.ID) != ruleID {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	rules = append(rules, types.LifecycleRule{
+		ID:         aws.String(ruleID),
+		Status:     types.ExpirationStatusEnabled,
+		Filter:     &types.LifecycleRuleFilterMemberPrefix{Value: prefix},
+		Expiration: &types.LifecycleExpiration{Days: days},
+	})
+	_, err = svc.PutBucketLifecycleConfiguration(context.TODO(), &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucketName),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: rules},
+	})
+	return err
+}
+
+// CreateObjectLockBucket creates a bucket with S3 Object Lock, whose objects can not be changed or deleted
+// during the retention period, not even by the root user
+func CreateObjectLockBucket(svc *s3.Client, bucketName string, region string, retentionDays int32) error {
+	_, err := svc.CreateBucket(context.TODO(), &s3.CreateBucketInput{
+		Bucket:                     aws.String(bucketName),
+		ACL:                        types.BucketCannedACLPrivate,
+		CreateBucketConfiguration:  bucketConfiguration(region),
+		ObjectLockEnabledForBucket: true,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = svc.PutObjectLockConfiguration(context.TODO(), &s3.PutObjectLockConfigurationInput{
+		Bucket: aws.String(bucketName),
+		ObjectLockConfiguration: &types.ObjectLockConfiguration{
+			ObjectLockEnabled: types.ObjectLockEnabledEnabled,
+			Rule: &types.ObjectLockRule{
+				DefaultRetention: &types.DefaultRetention{Mode: types.ObjectLockRetentionModeCompliance, Days: retentionDays},
+			},
+		},
+	})
+	return err
+}
+
 func IsFileUploaded(ctx context.Context, client *s3.Client, bucket string, key string) (bool, error) {
 	_, err := client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
@@ -144,12 +218,33 @@ func DeleteLambdaFunction(svc *lambda.Client, functionName string) error {
 	})
 	return err
 }
-func CreateLambdaFunction(svc *lambda.Client, functionName string, binaryName string, zipContent []byte, restAPIID, zone, accountID, bucket, lambdaRole string) error {
-	sourceARN := fmt.Sprintf("arn:aws:execute-api:%s:%s:%s/*/*", zone, accountID, restAPIID)
+
+// lambdaEnvironmentVariables adds the variables every Lambda needs to the variables of the config file
+func lambdaEnvironmentVariables(zone string, bucket string, lambdaEnvironment map[string]string) map[string]string {
 	envVariables := make(map[string]string)
+	for key, value := range lambdaEnvironment {
+		envVariables[key] = value
+	}
 	envVariables["ZONE"] = zone
 	envVariables["CREDENTIAL_FILE_NAME"] = S3_CRED_FILE_NAME
 	envVariables["S3_BUCKET"] = bucket
+	return envVariables
+}
+
+// UpdateLambdaEnvironment replaces the environment variables of an existing Lambda
+func UpdateLambdaEnvironment(svc *lambda.Client, functionName string, zone string, bucket string, lambdaEnvironment map[string]string) error {
+	return Retry(func() error {
+		_, err := svc.UpdateFunctionConfiguration(context.TODO(), &lambda.UpdateFunctionConfigurationInput{
+			FunctionName: aws.String(functionName),
+			Environment:  &lambdaTypes.Environment{Variables: lambdaEnvironmentVariables(zone, bucket, lambdaEnvironment)},
+		})
+		return err
+	})
+}
+
+func CreateLambdaFunction(svc *lambda.Client, functionName string, binaryName string, zipContent []byte, restAPIID, zone, accountID, bucket, lambdaRole string, lambdaEnvironment map[string]string) error {
+	sourceARN := fmt.Sprintf("arn:aws:execute-api:%s:%s:%s/*/*", zone, accountID, restAPIID)
+	envVariables := lambdaEnvironmentVariables(zone, bucket, lambdaEnvironment)
 
 	err := Retry(func() error {
 		_, er := svc.CreateFunction(context.TODO(), &lambda.CreateFunctionInput{
@@ -232,9 +327,19 @@ func CreateLambdaS3Role(svc *iam.Client, roleName string, bucket string) error {
 						"s3:GetObject"
 					],
 					"Resource": "arn:aws:s3:::%s/*"
+				},
+				{
+					"Effect": "Allow",
+					"Action": [
+						"s3:DeleteObject"
+					],
+					"Resource": [
+						"arn:aws:s3:::%s/%s*",
+						"arn:aws:s3:::%s/%s*"
+					]
 				}
 			]
-		}`, bucket, bucket)),
+		}`, bucket, bucket, bucket, S3_LEDGER_INDEX_PREFIX, bucket, S3_IDEMPOTENCY_PREFIX)),
 	})
 	if err != nil {
 		return err
@@ -276,6 +381,27 @@ func CreateLambdaS3Role(svc *iam.Client, roleName string, bucket string) error {
 	return nil // TODO MORE MEANINGFUL ERRORS
 }
 
+// AllowLambdaRoleToAddObjects lets the Lambda role add objects to the bucket, without reading, changing or deleting them
+func AllowLambdaRoleToAddObjects(svc *iam.Client, roleName string, policyName string, bucket string) error {
+	_, err := svc.PutRolePolicy(context.TODO(), &iam.PutRolePolicyInput{
+		RoleName:   aws.String(roleName),
+		PolicyName: aws.String(policyName),
+		PolicyDocument: aws.String(fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [
+				{
+					"Effect": "Allow",
+					"Action": [
+						"s3:PutObject"
+					],
+					"Resource": "arn:aws:s3:::%s/*"
+				}
+			]
+		}`, bucket)),
+	})
+	return err
+}
+
 func CreateSnowflakeRole(svc *iam.Client, roleName string) error {
 	_, err := svc.CreateRole(context.TODO(), &iam.CreateRoleInput{
 		RoleName:    aws.String(roleName),
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
@@ -9,14 +10,34 @@ import (
 )
 
 type ConfigOptions struct {
-	Aws       AwsOptions         `yaml:"aws"`
-	Snowflake []SnowflakeOptions `yaml:"snowflake"`
-	Venafi    []VenafiOptions    `yaml:"venafi"`
+	Aws        AwsOptions         `yaml:"aws"`
+	Snowflake  []SnowflakeOptions `yaml:"snowflake"`
+	Venafi     []VenafiOptions    `yaml:"venafi"`
+	Proxy      ProxyOptions       `yaml:"proxy"`
+	Guardrails string             `yaml:"guardrails"` // path of the YAML or JSON guardrail policy file
+	// Authorization is the path of the YAML or JSON file with the Snowflake roles, users and accounts allowed to call each operation
+	Authorization string `yaml:"authorization"`
+	// IdempotencyWindow is how many minutes the results of REQUEST_MACHINE_ID are kept for retries, 0 disables it
+	IdempotencyWindow *int `yaml:"idempotencywindow"`
+	// LogLevel of the Lambdas: debug, info, warning or error
+	LogLevel string `yaml:"loglevel"`
+	// AuditAnchorBucket is the bucket with S3 Object Lock where every audit record is anchored, created by the install
+	AuditAnchorBucket  string `yaml:"auditanchorbucket"`
+	AuditRetentionDays int    `yaml:"auditretentiondays"` // Object Lock retention of the anchors, 365 by default
 }
 type AwsOptions struct {
 	Profile string
 	Bucket  string
 }
+
+// ProxyOptions is the default outbound proxy of the Lambdas, set as HTTPS_PROXY and NO_PROXY. The username
+// and password are stored with the TPP servers in the credential file, not in the environment.
+type ProxyOptions struct {
+	Url      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	NoProxy  string `yaml:"noproxy"`
+}
 type SnowflakeOptions struct {
 	Role      string `yaml:"role"`
 	Account   string `yaml:"account"`
@@ -31,6 +52,28 @@ type VenafiOptions struct {
 	AccessTokenExpires string
 	RefreshToken       string
 	Url                string
+	Aliases            string      `json:",omitempty"` // comma separated names which can be used in Snowflake instead of the url
+	DefaultZone        string      `json:",omitempty"`
+	ZoneAliases        ZoneAliases `json:",omitempty"`
+	TrustBundle        string      `json:",omitempty"` // path of a PEM CA bundle, its content is stored in the credential file
+	TrustBundleFile    string      `json:",omitempty"` // name of a PEM CA bundle uploaded to the bucket
+	MinTLSVersion      string      `json:",omitempty"`
+	ProxyUrl           string      `json:",omitempty"` // overrides the default proxy for this TPP server
+	ProxyUsername      string      `json:",omitempty"`
+	ProxyPassword      string      `json:",omitempty"`
+	NoProxy            string      `json:",omitempty"`
+}
+
+// ZoneAliases maps short zone names to policy folders. It is stored as a JSON encoded object in the
+// credential file, because the Lambdas read every value as a string.
+type ZoneAliases map[string]string
+
+func (z ZoneAliases) MarshalJSON() ([]byte, error) {
+	aliases, err := json.Marshal(map[string]string(z))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(aliases))
 }
 
 func GetConfig(configFilePath string) ConfigOptions {
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"time"
+
+	"github.com/go-yaml/yaml"
+)
+
+// S3_GUARDRAIL_POLICY_FILE_NAME is the key read by the Lambdas, every uploaded version is also
+// kept under S3_GUARDRAIL_POLICY_HISTORY_PREFIX
+const S3_GUARDRAIL_POLICY_FILE_NAME = "guardrails.json"
+const S3_GUARDRAIL_POLICY_HISTORY_PREFIX = "guardrails/"
+
+// GuardrailRule is a rule of the guardrail policy checked by the Lambdas before calling TPP
+type GuardrailRule struct {
+	CommonNameRegexes []string `yaml:"commonnameregexes" json:"CommonNameRegexes,omitempty"`
+	SanRegexes        []string `yaml:"sanregexes" json:"SanRegexes,omitempty"`
+	NamingConvention  string   `yaml:"namingconvention" json:"NamingConvention,omitempty"`
+	BanWildcards      bool     `yaml:"banwildcards" json:"BanWildcards,omitempty"`
+	MaxSans           int      `yaml:"maxsans" json:"MaxSans,omitempty"`
+	DisallowRenew     bool     `yaml:"disallowrenew" json:"DisallowRenew,omitempty"`
+	DisallowRevoke    bool     `yaml:"disallowrevoke" json:"DisallowRevoke,omitempty"`
+	DisallowRetire    bool     `yaml:"disallowretire" json:"DisallowRetire,omitempty"`
+	DisallowDelete    bool     `yaml:"disallowdelete" json:"DisallowDelete,omitempty"`
+	DisallowApproval  bool     `yaml:"disallowapproval" json:"DisallowApproval,omitempty"`
+	// AllowUntrackedApproval allows approving requests whose submitter is not in the request ledger
+	AllowUntrackedApproval bool `yaml:"allowuntrackedapproval" json:"AllowUntrackedApproval,omitempty"`
+}
+
+// GuardrailPolicy is read from a YAML or JSON file and uploaded to the bucket as JSON
+type GuardrailPolicy struct {
+	Version string                   `yaml:"version" json:"Version"`
+	Default *GuardrailRule           `yaml:"default" json:"Default,omitempty"`
+	Zones   map[string]GuardrailRule `yaml:"zones" json:"Zones,omitempty"`
+}
+
+func checkGuardrailRegexes(regexes ...string) error {
+	for _, r := range regexes {
+		if _, err := regexp.Compile(r); err != nil {
+			return fmt.Errorf("invalid regular expression '%s': %v", r, err)
+		}
+	}
+	return nil
+}
+
+func (r GuardrailRule) validate() error {
+	if err := checkGuardrailRegexes(r.CommonNameRegexes...); err != nil {
+		return err
+	}
+	if err := checkGuardrailRegexes(r.SanRegexes...); err != nil {
+		return err
+	}
+	if r.NamingConvention != "" {
+		if err := checkGuardrailRegexes(r.NamingConvention); err != nil {
+			return err
+		}
+	}
+	if r.MaxSans < 0 {
+		return fmt.Errorf("maxsans can not be negative")
+	}
+	return nil
+}
+
+// createGuardrailPolicyFile reads and validates the guardrail policy file of the config. Policies
+// without a version get the upload time as version, so every upload is kept in the history.
+func createGuardrailPolicyFile(path string) ([]byte, string, error) {
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read guardrail policy: %v", err)
+	}
+	policy := GuardrailPolicy{}
+	// JSON is valid YAML, so both formats are parsed the same way
+	if err := yaml.Unmarshal(fileBytes, &policy); err != nil {
+		return nil, "", fmt.Errorf("failed to parse guardrail policy: %v", err)
+	}
+	if policy.Default != nil {
+		if err := policy.Default.validate(); err != nil {
+			return nil, "", fmt.Errorf("default rule: %v", err)
+		}
+	}
+	for zone, rule := range policy.Zones {
+		if err := rule.validate(); err != nil {
+			return nil, "", fmt.Errorf("rule of zone %s: %v", zone, err)
+		}
+	}
+	if policy.Version == "" {
+		policy.Version = time.Now().UTC().Format("20060102T150405Z")
+	}
+	data, err := json.MarshalIndent(policy, "", " ")
+	if err != nil {
+		return nil, "", err
+	}
+	return data, policy.Version, nil
+}
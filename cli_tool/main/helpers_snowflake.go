@@ -15,12 +15,38 @@ const SNOWFLAKE_FUNCTION_NAME_LISTMACHINEIDS = "LIST_MACHINE_IDS"
 const SNOWFLAKE_FUNCTION_NAME_RENEWMACHINEID = "RENEW_MACHINE_ID"
 const SNOWFLAKE_FUNCTION_NAME_REVOKEMACHINEID = "REVOKE_MACHINE_ID"
 const SNOWFLAKE_FUNCTION_NAME_GETMACHINEIDSTATUS = "GET_MACHINE_ID_STATUS"
+const SNOWFLAKE_FUNCTION_NAME_GETZONEPOLICY = "GET_ZONE_POLICY"
+const SNOWFLAKE_FUNCTION_NAME_VALIDATEMACHINEIDREQUEST = "VALIDATE_MACHINE_ID_REQUEST"
+const SNOWFLAKE_FUNCTION_NAME_SEARCHMACHINEIDS = "SEARCH_MACHINE_IDS"
+const SNOWFLAKE_FUNCTION_NAME_IMPORTMACHINEID = "IMPORT_MACHINE_ID"
+const SNOWFLAKE_FUNCTION_NAME_RETIREMACHINEID = "RETIRE_MACHINE_ID"
+const SNOWFLAKE_FUNCTION_NAME_RESETMACHINEID = "RESET_MACHINE_ID"
+const SNOWFLAKE_FUNCTION_NAME_DELETEMACHINEID = "DELETE_MACHINE_ID"
+const SNOWFLAKE_FUNCTION_NAME_LISTPENDINGAPPROVALS = "LIST_PENDING_APPROVALS"
+const SNOWFLAKE_FUNCTION_NAME_APPROVEMACHINEID = "APPROVE_MACHINE_ID"
+const SNOWFLAKE_FUNCTION_NAME_REJECTMACHINEID = "REJECT_MACHINE_ID"
+const SNOWFLAKE_FUNCTION_NAME_GETREQUESTSTATUS = "GET_REQUEST_STATUS"
+const SNOWFLAKE_FUNCTION_NAME_LISTREQUESTS = "LIST_REQUESTS"
+const SNOWFLAKE_FUNCTION_NAME_MACHINEIDINVENTORY = "MACHINE_ID_INVENTORY" // SQL table function over LIST_MACHINE_IDS
+const SNOWFLAKE_INVENTORY_PAGE_LIMIT = 5000                               // the largest limit of LIST_MACHINE_IDS
 const SNOWFLAKE_FUNCTION_ALIAS_GETMACHINEID = "GET_MID"
 const SNOWFLAKE_FUNCTION_ALIAS_REQUESTMACHINEID = "REQUEST_MID"
 const SNOWFLAKE_FUNCTION_ALIAS_LISTMACHINEIDS = "LIST_MIDS"
 const SNOWFLAKE_FUNCTION_ALIAS_RENEWMACHINEID = "RENEW_MID"
 const SNOWFLAKE_FUNCTION_ALIAS_REVOKEMACHINEID = "REVOKE_MID"
 const SNOWFLAKE_FUNCTION_ALIAS_GETMACHINEIDSTATUS = "GET_MID_STATUS"
+const SNOWFLAKE_FUNCTION_ALIAS_GETZONEPOLICY = "GET_ZONE_POL"
+const SNOWFLAKE_FUNCTION_ALIAS_VALIDATEMACHINEIDREQUEST = "VALIDATE_MID_REQUEST"
+const SNOWFLAKE_FUNCTION_ALIAS_SEARCHMACHINEIDS = "SEARCH_MIDS"
+const SNOWFLAKE_FUNCTION_ALIAS_IMPORTMACHINEID = "IMPORT_MID"
+const SNOWFLAKE_FUNCTION_ALIAS_RETIREMACHINEID = "RETIRE_MID"
+const SNOWFLAKE_FUNCTION_ALIAS_RESETMACHINEID = "RESET_MID"
+const SNOWFLAKE_FUNCTION_ALIAS_DELETEMACHINEID = "DELETE_MID"
+const SNOWFLAKE_FUNCTION_ALIAS_LISTPENDINGAPPROVALS = "LIST_APPROVALS"
+const SNOWFLAKE_FUNCTION_ALIAS_APPROVEMACHINEID = "APPROVE_MID"
+const SNOWFLAKE_FUNCTION_ALIAS_REJECTMACHINEID = "REJECT_MID"
+const SNOWFLAKE_FUNCTION_ALIAS_GETREQUESTSTATUS = "GET_REQ_STATUS"
+const SNOWFLAKE_FUNCTION_ALIAS_LISTREQUESTS = "LIST_REQS"
 
 func getConnectionStringFromParams(username, password, account, warehouse, database, schema, role string) string {
 	return fmt.Sprintf("%s:%s@%s-%s/%s/%s?my_warehouse=%s&role=%s", username, "7^kJuS!$QLVzPy~_", account, account, database, schema, warehouse, role)
@@ -74,27 +100,89 @@ func CreateSnowflakeApiIntegration(integrationName string, awsRoleARN string, en
 	return final["API_AWS_EXTERNAL_ID"], final["API_AWS_IAM_USER_ARN"], nil //TODO qUERY return values
 }
 
-func CreateSnowflakeFunction(functionName string, alias string, endpoint string, conf SnowflakeOptions, integrationName string) {
-	serializedFuncName := strings.ToLower(strings.ReplaceAll(functionName, "_", ""))
-	path := endpoint + serializedFuncName
+// ConnectorFunction is an operation of the connector: the Lambda which handles it, the Snowflake external
+// function and its alias which call the Lambda and the signatures of the external function. Functions with
+// more than one signature are overloaded in Snowflake, every signature calls the same Lambda.
+type ConnectorFunction struct {
+	Lambda     string
+	Snowflake  string
+	Alias      string
+	Signatures []string
+}
+
+// CONNECTOR_FUNCTIONS are the operations of the connector, the installer deploys and checks them in this order
+var CONNECTOR_FUNCTIONS = []ConnectorFunction{
+	{LAMBDA_FUNCTION_NAME_GETMACHINEID, SNOWFLAKE_FUNCTION_NAME_GETMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_GETMACHINEID, []string{
+		"(type varchar, tpp_url varchar, request_id varchar)",
+		"(type varchar, tpp_url varchar, request_id varchar, options object)",
+	}},
+	{LAMBDA_FUNCTION_NAME_REQUESTMACHINEID, SNOWFLAKE_FUNCTION_NAME_REQUESTMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_REQUESTMACHINEID, []string{
+		"(type varchar, tpp_url varchar, dns array, zone varchar, upn array, common_name varchar)",
+		"(type varchar, tpp_url varchar, dns array, zone varchar, upn array, common_name varchar, options object)",
+	}},
+	{LAMBDA_FUNCTION_NAME_LISTMACHINEIDS, SNOWFLAKE_FUNCTION_NAME_LISTMACHINEIDS, SNOWFLAKE_FUNCTION_ALIAS_LISTMACHINEIDS, []string{
+		"(type varchar, tpp_url varchar, zone varchar)",
+		"(type varchar, tpp_url varchar, zone varchar, options object)",
+	}},
+	{LAMBDA_FUNCTION_NAME_RENEWMACHINEID, SNOWFLAKE_FUNCTION_NAME_RENEWMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_RENEWMACHINEID, []string{
+		"(type varchar, tpp_url varchar, request_id varchar)",
+		"(type varchar, tpp_url varchar, request_id varchar, options object)",
+	}},
+	{LAMBDA_FUNCTION_NAME_REVOKEMACHINEID, SNOWFLAKE_FUNCTION_NAME_REVOKEMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_REVOKEMACHINEID, []string{
+		"(type varchar, tpp_url varchar, request_id varchar, should_disable boolean)",
+		"(type varchar, tpp_url varchar, request_id varchar, should_disable boolean, options object)",
+	}},
+	{LAMBDA_FUNCTION_NAME_GETMACHINEIDSTATUS, SNOWFLAKE_FUNCTION_NAME_GETMACHINEIDSTATUS, SNOWFLAKE_FUNCTION_ALIAS_GETMACHINEIDSTATUS, []string{
+		"(type varchar, tpp_url varchar, zone varchar, common_name varchar)",
+	}},
+	{LAMBDA_FUNCTION_NAME_GETZONEPOLICY, SNOWFLAKE_FUNCTION_NAME_GETZONEPOLICY, SNOWFLAKE_FUNCTION_ALIAS_GETZONEPOLICY, []string{
+		"(type varchar, tpp_url varchar, zone varchar)",
+	}},
+	{LAMBDA_FUNCTION_NAME_VALIDATEMACHINEIDREQUEST, SNOWFLAKE_FUNCTION_NAME_VALIDATEMACHINEIDREQUEST, SNOWFLAKE_FUNCTION_ALIAS_VALIDATEMACHINEIDREQUEST, []string{
+		"(type varchar, tpp_url varchar, dns array, zone varchar, upn array, common_name varchar)",
+	}},
+	{LAMBDA_FUNCTION_NAME_SEARCHMACHINEIDS, SNOWFLAKE_FUNCTION_NAME_SEARCHMACHINEIDS, SNOWFLAKE_FUNCTION_ALIAS_SEARCHMACHINEIDS, []string{
+		"(type varchar, tpp_url varchar, zone varchar, criteria object)",
+	}},
+	{LAMBDA_FUNCTION_NAME_IMPORTMACHINEID, SNOWFLAKE_FUNCTION_NAME_IMPORTMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_IMPORTMACHINEID, []string{
+		"(type varchar, tpp_url varchar, zone varchar, object_name varchar, certificate varchar)",
+		"(type varchar, tpp_url varchar, zone varchar, object_name varchar, certificate varchar, private_key varchar, passphrase varchar)",
+	}},
+	{LAMBDA_FUNCTION_NAME_RETIREMACHINEID, SNOWFLAKE_FUNCTION_NAME_RETIREMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_RETIREMACHINEID, []string{
+		"(type varchar, tpp_url varchar, request_id varchar, confirm boolean)",
+	}},
+	{LAMBDA_FUNCTION_NAME_RESETMACHINEID, SNOWFLAKE_FUNCTION_NAME_RESETMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_RESETMACHINEID, []string{
+		"(type varchar, tpp_url varchar, request_id varchar, restart boolean)",
+	}},
+	{LAMBDA_FUNCTION_NAME_DELETEMACHINEID, SNOWFLAKE_FUNCTION_NAME_DELETEMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_DELETEMACHINEID, []string{
+		"(type varchar, tpp_url varchar, request_id varchar, confirm boolean)",
+	}},
+	{LAMBDA_FUNCTION_NAME_LISTPENDINGAPPROVALS, SNOWFLAKE_FUNCTION_NAME_LISTPENDINGAPPROVALS, SNOWFLAKE_FUNCTION_ALIAS_LISTPENDINGAPPROVALS, []string{
+		"(type varchar, tpp_url varchar, zone varchar)",
+	}},
+	{LAMBDA_FUNCTION_NAME_APPROVEMACHINEID, SNOWFLAKE_FUNCTION_NAME_APPROVEMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_APPROVEMACHINEID, []string{
+		"(type varchar, tpp_url varchar, request_id varchar, explanation varchar)",
+	}},
+	{LAMBDA_FUNCTION_NAME_REJECTMACHINEID, SNOWFLAKE_FUNCTION_NAME_REJECTMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_REJECTMACHINEID, []string{
+		"(type varchar, tpp_url varchar, request_id varchar, explanation varchar)",
+	}},
+	{LAMBDA_FUNCTION_NAME_GETREQUESTSTATUS, SNOWFLAKE_FUNCTION_NAME_GETREQUESTSTATUS, SNOWFLAKE_FUNCTION_ALIAS_GETREQUESTSTATUS, []string{
+		"(type varchar, tpp_url varchar, request_id varchar)",
+	}},
+	{LAMBDA_FUNCTION_NAME_LISTREQUESTS, SNOWFLAKE_FUNCTION_NAME_LISTREQUESTS, SNOWFLAKE_FUNCTION_ALIAS_LISTREQUESTS, []string{
+		"(type varchar, tpp_url varchar, state varchar)",
+	}},
+}
+
+// SNOWFLAKE_CONTEXT_HEADERS are sent with every call, so the connector knows who called the function.
+// CURRENT_STATEMENT is not sent because the SQL text can contain passphrases.
+const SNOWFLAKE_CONTEXT_HEADERS = "current_user, current_role, current_account"
+
+// CreateSnowflakeFunction creates every signature of the external function and its alias, they call the
+// resource of the Lambda in the Rest API
+func CreateSnowflakeFunction(function ConnectorFunction, endpoint string, conf SnowflakeOptions, integrationName string) {
+	path := endpoint + function.Lambda
 	connStr := getConnectionStringFromParams(conf.Username, conf.Password, conf.Account, conf.Warehouse, conf.Database, conf.Schema, conf.Role)
-	var paramStr string
-	switch functionName {
-	case SNOWFLAKE_FUNCTION_NAME_GETMACHINEID:
-		paramStr = "(type varchar, tpp_url varchar, request_id varchar)"
-	case SNOWFLAKE_FUNCTION_NAME_RENEWMACHINEID:
-		paramStr = "(type varchar, tpp_url varchar, request_id varchar)"
-	case SNOWFLAKE_FUNCTION_NAME_REVOKEMACHINEID:
-		paramStr = "(type varchar, tpp_url varchar, request_id varchar, should_disable boolean)"
-	case SNOWFLAKE_FUNCTION_NAME_REQUESTMACHINEID:
-		paramStr = "(type varchar, tpp_url varchar, dns array, zone varchar, upn array, common_name varchar)"
-	case SNOWFLAKE_FUNCTION_NAME_LISTMACHINEIDS:
-		paramStr = "(type varchar, tpp_url varchar, zone varchar)"
-	case SNOWFLAKE_FUNCTION_NAME_GETMACHINEIDSTATUS:
-		paramStr = "(type varchar, tpp_url varchar, zone varchar, common_name varchar)"
-	default:
-		fmt.Printf("invalid function name: %v", functionName)
-	}
 
 	db, err := sql.Open("snowflake", connStr)
 	if err != nil {
@@ -102,25 +190,64 @@ func CreateSnowflakeFunction(functionName string, alias string, endpoint string,
 		return
 	}
 	defer db.Close()
-	sql := fmt.Sprintf(`
+	for _, paramStr := range function.Signatures {
+		sql := fmt.Sprintf(`
 			create or replace external function %s %s
 			returns variant
 			api_integration = %s
+			context_headers = (%s)
 			COMPRESSION = none
-			as '%s'`, functionName, paramStr, integrationName, path)
-	_, err = db.Exec(sql)
-	if err != nil {
-		log.Fatal("Failed to create function: " + err.Error())
-	}
-	sqlForAlias := fmt.Sprintf(`
+			as '%s'`, function.Snowflake, paramStr, integrationName, SNOWFLAKE_CONTEXT_HEADERS, path)
+		_, err = db.Exec(sql)
+		if err != nil {
+			log.Fatal("Failed to create function: " + err.Error())
+		}
+		sqlForAlias := fmt.Sprintf(`
 	create or replace external function %s %s
 	returns variant
 	api_integration = %s
+	context_headers = (%s)
 	COMPRESSION = none
-	as '%s'`, alias, paramStr, integrationName, path)
-	_, err = db.Exec(sqlForAlias) // create aliases
+	as '%s'`, function.Alias, paramStr, integrationName, SNOWFLAKE_CONTEXT_HEADERS, path)
+		_, err = db.Exec(sqlForAlias) // create aliases
+		if err != nil {
+			log.Fatal("Failed to create function: " + err.Error())
+		}
+	}
+}
+
+// CreateSnowflakeInventoryFunction creates the MACHINE_ID_INVENTORY table function which flattens the
+// inventory mode of LIST_MACHINE_IDS into one row per certificate. Each call reads one page with one call of
+// LIST_MACHINE_IDS, so the page fits into a single Lambda response. Larger zones are read with the page_limit
+// and page_offset parameters until a page has fewer rows than page_limit.
+func CreateSnowflakeInventoryFunction(conf SnowflakeOptions) {
+	connStr := getConnectionStringFromParams(conf.Username, conf.Password, conf.Account, conf.Warehouse, conf.Database, conf.Schema, conf.Role)
+	db, err := sql.Open("snowflake", connStr)
 	if err != nil {
 		log.Fatal("Failed to create function: " + err.Error())
+		return
+	}
+	defer db.Close()
+	signatures := map[string]string{
+		"(type varchar, tpp_url varchar, zone varchar)":                                        fmt.Sprintf("object_construct('limit', %d, 'include_expired', true, 'inventory', true)", SNOWFLAKE_INVENTORY_PAGE_LIMIT),
+		"(type varchar, tpp_url varchar, zone varchar, page_limit number, page_offset number)": "object_construct('limit', page_limit, 'offset', page_offset, 'include_expired', true, 'inventory', true)",
+		"(type varchar, tpp_url varchar, zone varchar, options object)":                        "object_insert(options, 'inventory', true, true)",
+	}
+	for paramStr, options := range signatures {
+		sql := fmt.Sprintf(`
+			create or replace function %s %s
+			returns table (dn varchar, cn varchar, serial varchar, thumbprint varchar, valid_from timestamp_tz, valid_to timestamp_tz, sans array, zone varchar, status varchar)
+			as $$
+				select
+					c.value:DN::varchar, c.value:CN::varchar, c.value:Serial::varchar, c.value:Thumbprint::varchar,
+					c.value:ValidFrom::timestamp_tz, c.value:ValidTo::timestamp_tz, c.value:SANs::array,
+					c.value:Zone::varchar, c.value:Status::varchar
+				from table(flatten(input => parse_json(%s(type, tpp_url, zone, %s)):Certificates)) c
+			$$`, SNOWFLAKE_FUNCTION_NAME_MACHINEIDINVENTORY, paramStr, SNOWFLAKE_FUNCTION_NAME_LISTMACHINEIDS, options)
+		_, err = db.Exec(sql)
+		if err != nil {
+			log.Fatal("Failed to create function: " + err.Error())
+		}
 	}
 }
 
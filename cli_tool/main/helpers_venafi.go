@@ -1,23 +1,135 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
 	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
 )
 
-func GetVenafiCredentials(tppUrl string, refreshToken string, username string, password string) (string, string, int, error) {
+func parseMinTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("Invalid minimum TLS version: %v", version)
+	}
+}
+
+// isNoProxyHost reports whether the host matches an entry of the comma separated noProxy list, the same way
+// as the Lambdas match the NoProxy of a TPP entry
+func isNoProxyHost(host string, noProxy string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// getVenafiProxyFunc returns the proxy of the http client: proxyUrl when it is set, otherwise the proxy of the
+// environment. Hosts of noProxy are called without proxy. The environment of the process is not changed.
+func getVenafiProxyFunc(proxyUrl string, noProxy string) (func(*http.Request) (*url.URL, error), error) {
+	proxy := http.ProxyFromEnvironment
+	if proxyUrl != "" {
+		parsedUrl, err := url.Parse(proxyUrl)
+		if err != nil || parsedUrl.Host == "" {
+			return nil, fmt.Errorf("Invalid proxy url: %v", proxyUrl)
+		}
+		proxy = http.ProxyURL(parsedUrl)
+	}
+	if noProxy == "" {
+		return proxy, nil
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if isNoProxyHost(req.URL.Host, noProxy) {
+			return nil, nil
+		}
+		return proxy(req)
+	}, nil
+}
+
+// getVenafiHTTPClient creates an http client which trusts the CA bundle from trustBundlePath, enforces the
+// minimum TLS version and uses the proxy, the same way the Lambdas connect to TPP
+func getVenafiHTTPClient(trustBundlePath string, minTLSVersion string, proxyUrl string, noProxy string) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if trustBundlePath != "" {
+		trustBundle, err := ioutil.ReadFile(trustBundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read trust bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(trustBundle) {
+			return nil, fmt.Errorf("Failed to parse PEM trust bundle: %s", trustBundlePath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	version, err := parseMinTLSVersion(minTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.MinVersion = version
+	proxy, err := getVenafiProxyFunc(proxyUrl, noProxy)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           proxy,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+func GetVenafiCredentials(tppUrl string, refreshToken string, username string, password string, trustBundlePath string, minTLSVersion string, proxyUrl string, noProxy string) (string, string, int, error) {
 	if tppUrl == "" {
 		log.Fatal("\nPlease provide tpp url in -tppurl=<url> format\n")
 	}
 	if username == "" && password == "" && refreshToken == "" {
 		log.Fatal("Please provide username and password or refreshToken to get credentials for Venafi platform")
 	}
-	c, err := tpp.NewConnector(tppUrl, "", false, nil)
+	httpClient, err := getVenafiHTTPClient(trustBundlePath, minTLSVersion, proxyUrl, noProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c, err := tpp.NewConnector(tppUrl, "", false, nil) // trust bundle is applied by the http client
 	if err != nil {
 		log.Fatal(err)
 	}
+	c.SetHTTPClient(httpClient)
 	if username != "" && password != "" {
 		auth := endpoint.Authentication{User: username, Password: password}
 		new_creds, err := c.GetRefreshToken(&auth)
@@ -9,8 +9,10 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -38,25 +40,33 @@ func Install(config ConfigOptions, awsConfig aws.Config, s3Client *s3.Client, la
 		_, _, bucketNotFound, _ := GetBucket(s3Client, config.Aws.Bucket)
 		if bucketNotFound {
 			Log(true, "Creating bucket...", 1)
-			createError := CreateBucket(s3Client, config.Aws.Bucket)
+			createError := CreateBucket(s3Client, config.Aws.Bucket, awsConfig.Region)
 			if createError != nil {
 				log.Fatalf("Failed to create bucket: " + createError.Error())
 			}
 		} else {
 			Log(true, "Bucket already exists", 1)
 		}
-		Log(true, "Constructing credentials file", 1)
-		credFileReader := createCredentialFileReader(config)
-		Log(true, "Uploading credentials file", 1)
-		uploadError := UploadFile(context.TODO(), s3Client, config.Aws.Bucket, S3_CRED_FILE_NAME, &credFileReader)
-		if uploadError != nil {
-			log.Fatalf("Failed to upload credentials file: " + uploadError.Error())
-		}
-		Log(true, "Credentials file uploaded", 1)
-		fmt.Print("Completed\n")
 	} else {
 		Log(true, "Bucket already exists", 1)
 	}
+	// the credentials file is uploaded on every install, so changes of the configuration reach the Lambda functions
+	Log(true, "Constructing credentials file", 1)
+	credFileReader := createCredentialFileReader(config)
+	Log(true, "Uploading credentials file", 1)
+	uploadError := UploadFile(context.TODO(), s3Client, config.Aws.Bucket, S3_CRED_FILE_NAME, &credFileReader)
+	if uploadError != nil {
+		log.Fatalf("Failed to upload credentials file: " + uploadError.Error())
+	}
+	Log(true, "Credentials file uploaded", 1)
+	fmt.Print("Completed\n")
+	addIdempotencyExpirationRule(s3Client, config.Aws.Bucket, config.IdempotencyWindow)
+	if config.Guardrails != "" {
+		uploadGuardrailPolicy(context.TODO(), s3Client, config.Aws.Bucket, config.Guardrails)
+	}
+	if config.Authorization != "" {
+		uploadAuthorizationPolicy(context.TODO(), s3Client, config.Aws.Bucket, config.Authorization)
+	}
 	Log(true, "2. Create Lambda Execution Roles..", 1)
 
 	if status.AwsLambdaS3Role.State != 1 {
@@ -114,6 +124,9 @@ func Install(config ConfigOptions, awsConfig aws.Config, s3Client *s3.Client, la
 	if info.Aws_snowflake_role_name != "" {
 		snowflakeRole = info.Aws_snowflake_role_name
 	}
+	if config.AuditAnchorBucket != "" {
+		setupAuditAnchors(s3Client, iamClient, config.AuditAnchorBucket, awsConfig.Region, config.AuditRetentionDays, lambdaRole)
+	}
 
 	if status.AwsGateway.State != 1 {
 		parentResourceID, restApiID, endpointUrl, err = CreateRestAPI(gatewayClient, snowflakeRole, accountId, awsConfig.Region)
@@ -133,46 +146,17 @@ func Install(config ConfigOptions, awsConfig aws.Config, s3Client *s3.Client, la
 		Log(true, "3. Deploying AWS Lambdas and API Gateway ... ", 1)
 
 		zipContent := createAwsLambdaZip()
-		manageAwsLambda(LAMBDA_FUNCTION_NAME_GETMACHINEID, status.AwsLambas_Details.GetMachineId, lambdaClient, zipContent, restApiID, awsConfig.Region, accountId, config.Aws.Bucket, lambdaRole)
-		manageAwsLambda(LAMBDA_FUNCTION_NAME_REQUESTMACHINEID, status.AwsLambas_Details.RequestMachineId, lambdaClient, zipContent, restApiID, awsConfig.Region, accountId, config.Aws.Bucket, lambdaRole)
-		manageAwsLambda(LAMBDA_FUNCTION_NAME_LISTMACHINEIDS, status.AwsLambas_Details.ListMachineIds, lambdaClient, zipContent, restApiID, awsConfig.Region, accountId, config.Aws.Bucket, lambdaRole)
-		manageAwsLambda(LAMBDA_FUNCTION_NAME_RENEWMACHINEID, status.AwsLambas_Details.RenewMachineId, lambdaClient, zipContent, restApiID, awsConfig.Region, accountId, config.Aws.Bucket, lambdaRole)
-		manageAwsLambda(LAMBDA_FUNCTION_NAME_REVOKEMACHINEID, status.AwsLambas_Details.RevokeMachineId, lambdaClient, zipContent, restApiID, awsConfig.Region, accountId, config.Aws.Bucket, lambdaRole)
-		manageAwsLambda(LAMBDA_FUNCTION_NAME_GETMACHINEIDSTATUS, status.AwsLambas_Details.GetMachineIdStatus, lambdaClient, zipContent, restApiID, awsConfig.Region, accountId, config.Aws.Bucket, lambdaRole)
-
-		err = IntegrateLambdaWithRestApi(gatewayClient, restApiID, parentResourceID, LAMBDA_FUNCTION_NAME_GETMACHINEID, accountId, awsConfig.Region)
-		if err != nil {
-			log.Fatalf("Failed to integrate Lambda: %s : " + LAMBDA_FUNCTION_NAME_GETMACHINEID + "Error: " + err.Error())
-		}
-
-		err = IntegrateLambdaWithRestApi(gatewayClient, restApiID, parentResourceID, LAMBDA_FUNCTION_NAME_REQUESTMACHINEID, accountId, awsConfig.Region)
-		if err != nil {
-			log.Fatalf("Failed to integrate Lambda: " + LAMBDA_FUNCTION_NAME_REQUESTMACHINEID + "Error: " + err.Error())
-		}
-
-		err = IntegrateLambdaWithRestApi(gatewayClient, restApiID, parentResourceID, LAMBDA_FUNCTION_NAME_LISTMACHINEIDS, accountId, awsConfig.Region)
-		if err != nil {
-			log.Fatalf("Failed to integrate Lambda: " + LAMBDA_FUNCTION_NAME_LISTMACHINEIDS + "Error: " + err.Error())
-		}
-
-		err = IntegrateLambdaWithRestApi(gatewayClient, restApiID, parentResourceID, LAMBDA_FUNCTION_NAME_RENEWMACHINEID, accountId, awsConfig.Region)
-		if err != nil {
-			log.Fatalf("Failed to integrate Lambda: " + LAMBDA_FUNCTION_NAME_RENEWMACHINEID + "Error: " + err.Error())
+		lambdaEnvironment := getLambdaEnvironment(config)
+		for _, function := range CONNECTOR_FUNCTIONS {
+			manageAwsLambda(function.Lambda, status.AwsLambas_Details[function.Lambda], lambdaClient, zipContent, restApiID, awsConfig.Region, accountId, config.Aws.Bucket, lambdaRole, lambdaEnvironment)
 		}
-
-		err = IntegrateLambdaWithRestApi(gatewayClient, restApiID, parentResourceID, LAMBDA_FUNCTION_NAME_REVOKEMACHINEID, accountId, awsConfig.Region)
-		if err != nil {
-			log.Fatalf("Failed to integrate Lambda: " + LAMBDA_FUNCTION_NAME_RENEWMACHINEID + "Error: " + err.Error())
-		}
-
-		err = IntegrateLambdaWithRestApi(gatewayClient, restApiID, parentResourceID, LAMBDA_FUNCTION_NAME_GETMACHINEIDSTATUS, accountId, awsConfig.Region)
-		if err != nil {
-			log.Fatalf("Failed to integrate Lambda: " + LAMBDA_FUNCTION_NAME_GETMACHINEIDSTATUS + "Error: " + err.Error())
-			// }
-			Log(true, "Created all lambda functions\n", 1)
-		} else {
-			Log(true, "All Lambdas exists already\n", 1)
+		for _, function := range CONNECTOR_FUNCTIONS {
+			err = IntegrateLambdaWithRestApi(gatewayClient, restApiID, parentResourceID, function.Lambda, accountId, awsConfig.Region)
+			if err != nil {
+				log.Fatalf("Failed to integrate Lambda: %s Error: %v", function.Lambda, err)
+			}
 		}
+		Log(true, "Created all lambda functions\n", 1)
 
 		err = DeployRestAPI(gatewayClient, restApiID)
 		if err != nil {
@@ -180,7 +164,14 @@ func Install(config ConfigOptions, awsConfig aws.Config, s3Client *s3.Client, la
 		}
 
 	} else {
-		Log(true, "3. AWS Lambdas are ready ", 1)
+		Log(true, "3. AWS Lambdas are ready, updating their environment", 1)
+		lambdaEnvironment := getLambdaEnvironment(config)
+		for _, function := range CONNECTOR_FUNCTIONS {
+			name := GetLambdaFunctionName(function.Lambda)
+			if err := UpdateLambdaEnvironment(lambdaClient, name, awsConfig.Region, config.Aws.Bucket, lambdaEnvironment); err != nil {
+				log.Fatalf("Failed to update environment of function '%v': %v", name, err)
+			}
+		}
 	}
 	if status.SnowflakeHealth.State != 1 || status.AwsGateway.State != 1 { // we have to redo snowflake functions if api gateway is created with a new id
 		Log(true, "Deploying Snowflake API Integration and External Functions ... ", 0)
@@ -205,13 +196,10 @@ func Install(config ConfigOptions, awsConfig aws.Config, s3Client *s3.Client, la
 
 			Log(true, "2. Create Snowflake External Functions ... ", 1)
 
-			CreateSnowflakeFunction(SNOWFLAKE_FUNCTION_NAME_GETMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_GETMACHINEID, endpointUrl, snowflake, VENAFI_SNOWFLAKE_INTEGRATION_NAME)
-			CreateSnowflakeFunction(SNOWFLAKE_FUNCTION_NAME_REQUESTMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_REQUESTMACHINEID, endpointUrl, snowflake, VENAFI_SNOWFLAKE_INTEGRATION_NAME)
-			CreateSnowflakeFunction(SNOWFLAKE_FUNCTION_NAME_LISTMACHINEIDS, SNOWFLAKE_FUNCTION_ALIAS_LISTMACHINEIDS, endpointUrl, snowflake, VENAFI_SNOWFLAKE_INTEGRATION_NAME)
-			CreateSnowflakeFunction(SNOWFLAKE_FUNCTION_NAME_RENEWMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_RENEWMACHINEID, endpointUrl, snowflake, VENAFI_SNOWFLAKE_INTEGRATION_NAME)
-			CreateSnowflakeFunction(SNOWFLAKE_FUNCTION_NAME_RENEWMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_RENEWMACHINEID, endpointUrl, snowflake, VENAFI_SNOWFLAKE_INTEGRATION_NAME)
-			CreateSnowflakeFunction(SNOWFLAKE_FUNCTION_NAME_REVOKEMACHINEID, SNOWFLAKE_FUNCTION_ALIAS_REVOKEMACHINEID, endpointUrl, snowflake, VENAFI_SNOWFLAKE_INTEGRATION_NAME)
-			CreateSnowflakeFunction(SNOWFLAKE_FUNCTION_NAME_GETMACHINEIDSTATUS, SNOWFLAKE_FUNCTION_ALIAS_GETMACHINEIDSTATUS, endpointUrl, snowflake, VENAFI_SNOWFLAKE_INTEGRATION_NAME)
+			for _, function := range CONNECTOR_FUNCTIONS {
+				CreateSnowflakeFunction(function, endpointUrl, snowflake, VENAFI_SNOWFLAKE_INTEGRATION_NAME)
+			}
+			CreateSnowflakeInventoryFunction(snowflake)
 		}
 
 		Log(true, "Created all Snowflake External Functions\n", 1)
@@ -222,12 +210,159 @@ func Install(config ConfigOptions, awsConfig aws.Config, s3Client *s3.Client, la
 }
 
 func createCredentialFileReader(config ConfigOptions) io.Reader {
-	requestByte, _ := json.Marshal(config.Venafi)
+	credentials := make([]VenafiOptions, len(config.Venafi))
+	for i, venafi := range config.Venafi {
+		if venafi.TrustBundle != "" {
+			trustBundle, err := ioutil.ReadFile(venafi.TrustBundle)
+			if err != nil {
+				log.Fatalf("Failed to read trust bundle for %s: %v", venafi.Url, err)
+			}
+			venafi.TrustBundle = string(trustBundle)
+		}
+		// the Lambdas add the credentials of the default proxy to the proxy of their environment
+		if venafi.ProxyUrl == "" && config.Proxy.Username != "" {
+			venafi.ProxyUsername, venafi.ProxyPassword = config.Proxy.Username, config.Proxy.Password
+		}
+		credentials[i] = venafi
+	}
+	requestByte, _ := json.Marshal(credentials)
 
 	requestReader := bytes.NewReader(requestByte)
 	return requestReader
 }
 
+// addIdempotencyExpirationRule makes S3 remove the idempotency records, which contain encrypted private keys,
+// once the window is over. Lifecycle rules count in days, the records are kept for the window rounded up.
+func addIdempotencyExpirationRule(s3Client *s3.Client, bucket string, window *int) {
+	minutes := DEFAULT_IDEMPOTENCY_WINDOW_MINUTES
+	if window != nil {
+		minutes = *window
+	}
+	days := int32((minutes + 24*60 - 1) / (24 * 60))
+	if days < 1 {
+		days = 1
+	}
+	Log(true, "Adding lifecycle rule to remove idempotency records after %d days", 1, days)
+	if err := PutExpirationRule(s3Client, bucket, S3_IDEMPOTENCY_RULE_ID, S3_IDEMPOTENCY_PREFIX, days); err != nil {
+		log.Fatalf("Failed to add lifecycle rule for idempotency records: %v", err)
+	}
+}
+
+// setupAuditAnchors creates the Object Lock bucket of the audit anchors and lets the Lambdas add anchors to it
+func setupAuditAnchors(s3Client *s3.Client, iamClient *iam.Client, bucket string, region string, retentionDays int, lambdaRole string) {
+	if retentionDays == 0 {
+		retentionDays = DEFAULT_AUDIT_RETENTION_DAYS
+	}
+	if retentionDays < 0 {
+		log.Fatalf("Invalid auditretentiondays: %d, it must be 1 or more days", retentionDays)
+	}
+	_, _, bucketNotFound, err := GetBucket(s3Client, bucket)
+	if bucketNotFound {
+		Log(true, "Creating audit anchor bucket with Object Lock, retention %d days", 1, retentionDays)
+		if err := CreateObjectLockBucket(s3Client, bucket, region, int32(retentionDays)); err != nil {
+			log.Fatalf("Failed to create audit anchor bucket: %v", err)
+		}
+	} else if err != nil {
+		log.Fatalf("Failed to read audit anchor bucket: %v", err)
+	} else {
+		Log(true, "Audit anchor bucket already exists", 1)
+	}
+	if err := AllowLambdaRoleToAddObjects(iamClient, lambdaRole, AWS_POLICY_TO_ADD_AUDIT_ANCHORS, bucket); err != nil {
+		log.Fatalf("Failed to allow the Lambdas to write audit anchors: %v", err)
+	}
+}
+
+// uploadGuardrailPolicy uploads the guardrail policy read by the Lambdas and keeps a copy of every version
+func uploadGuardrailPolicy(ctx context.Context, s3Client *s3.Client, bucket string, path string) {
+	Log(true, "Uploading guardrail policy", 1)
+	data, version, err := createGuardrailPolicyFile(path)
+	if err != nil {
+		log.Fatalf("Invalid guardrail policy: " + err.Error())
+	}
+	var historyReader io.Reader = bytes.NewReader(data)
+	err = UploadFile(ctx, s3Client, bucket, S3_GUARDRAIL_POLICY_HISTORY_PREFIX+version+".json", &historyReader)
+	if err != nil {
+		log.Fatalf("Failed to upload guardrail policy: " + err.Error())
+	}
+	var policyReader io.Reader = bytes.NewReader(data)
+	err = UploadFile(ctx, s3Client, bucket, S3_GUARDRAIL_POLICY_FILE_NAME, &policyReader)
+	if err != nil {
+		log.Fatalf("Failed to upload guardrail policy: " + err.Error())
+	}
+	Log(true, "Guardrail policy version "+version+" uploaded", 1)
+}
+
+// uploadAuthorizationPolicy uploads the authorization policy read by the Lambdas and keeps a copy of every version
+func uploadAuthorizationPolicy(ctx context.Context, s3Client *s3.Client, bucket string, path string) {
+	Log(true, "Uploading authorization policy", 1)
+	data, version, err := createAuthorizationPolicyFile(path)
+	if err != nil {
+		log.Fatalf("Invalid authorization policy: " + err.Error())
+	}
+	var historyReader io.Reader = bytes.NewReader(data)
+	err = UploadFile(ctx, s3Client, bucket, S3_AUTHORIZATION_POLICY_HISTORY_PREFIX+version+".json", &historyReader)
+	if err != nil {
+		log.Fatalf("Failed to upload authorization policy: " + err.Error())
+	}
+	var policyReader io.Reader = bytes.NewReader(data)
+	err = UploadFile(ctx, s3Client, bucket, S3_AUTHORIZATION_POLICY_FILE_NAME, &policyReader)
+	if err != nil {
+		log.Fatalf("Failed to upload authorization policy: " + err.Error())
+	}
+	Log(true, "Authorization policy version "+version+" uploaded", 1)
+}
+
+const DEFAULT_LAMBDA_LOG_LEVEL = "info"
+
+// Idempotency records of REQUEST_MACHINE_ID, see idempotency.go of the connector
+const S3_IDEMPOTENCY_PREFIX = "idempotency/"
+const S3_IDEMPOTENCY_RULE_ID = "venafi-idempotency-records"
+const DEFAULT_IDEMPOTENCY_WINDOW_MINUTES = 60
+
+// Index entries of the request ledger, see ledger.go of the connector. The Lambdas move them when the
+// state of a request changes, so they can delete objects under this prefix and S3_IDEMPOTENCY_PREFIX only.
+const S3_LEDGER_INDEX_PREFIX = "ledger/index/"
+
+const DEFAULT_AUDIT_RETENTION_DAYS = 365
+const AWS_POLICY_TO_ADD_AUDIT_ANCHORS = "venafi-lambda-add-audit-anchors"
+
+// getLambdaEnvironment returns the environment variables from the config file which are set on every Lambda
+func getLambdaEnvironment(config ConfigOptions) map[string]string {
+	env := make(map[string]string)
+	if config.Proxy.Url != "" {
+		proxyURL, err := url.Parse(config.Proxy.Url)
+		if err != nil {
+			log.Fatalf("Invalid proxy url: %v", err)
+		}
+		// the username and password are kept in the credential file, see createCredentialFileReader
+		proxyURL.User = nil
+		env["HTTPS_PROXY"] = proxyURL.String()
+		env["HTTP_PROXY"] = proxyURL.String()
+	}
+	if config.Proxy.NoProxy != "" {
+		env["NO_PROXY"] = config.Proxy.NoProxy
+	}
+	if config.IdempotencyWindow != nil {
+		if *config.IdempotencyWindow < 0 {
+			log.Fatalf("Invalid idempotencywindow: %d, it must be 0 or more minutes", *config.IdempotencyWindow)
+		}
+		env["IDEMPOTENCY_WINDOW_MINUTES"] = strconv.Itoa(*config.IdempotencyWindow)
+	}
+	if config.AuditAnchorBucket != "" {
+		env["AUDIT_ANCHOR_BUCKET"] = config.AuditAnchorBucket
+	}
+	// always set, the install replaces the environment of existing Lambdas, so a level of an earlier install is reset
+	switch logLevel := strings.ToLower(config.LogLevel); logLevel {
+	case "":
+		env["LOG_LEVEL"] = DEFAULT_LAMBDA_LOG_LEVEL
+	case "debug", "info", "warning", "error":
+		env["LOG_LEVEL"] = logLevel
+	default:
+		log.Fatalf("Invalid loglevel: %s, it must be debug, info, warning or error", config.LogLevel)
+	}
+	return env
+}
+
 // TODO: Change this to work from packaged executable, remove dependency on working dir
 func createAwsLambdaZip() []byte {
 	ex, err := os.Getwd()
@@ -264,11 +399,18 @@ func createAwsLambdaZip() []byte {
 
 }
 
-func manageAwsLambda(functionName string, status StatusResult, lambdaClient *lambda.Client, zipContent []byte, restApiID string, zone string, accountId string, bucket string, lambdaRole string) {
+func manageAwsLambda(functionName string, status StatusResult, lambdaClient *lambda.Client, zipContent []byte, restApiID string, zone string, accountId string, bucket string, lambdaRole string, lambdaEnvironment map[string]string) {
+	name := GetLambdaFunctionName(functionName)
+	// existing functions get the environment of the current config file
+	if status.State == 1 {
+		if err := UpdateLambdaEnvironment(lambdaClient, name, zone, bucket, lambdaEnvironment); err != nil {
+			log.Fatalf("Failed to update environment of function '%v': %v", name, err)
+		}
+		return
+	}
 	if status.State < 2 {
 		return
 	}
-	name := GetLambdaFunctionName(functionName)
 	// if its an error, then remove it first
 	if status.State == 2 {
 		err := DeleteLambdaFunction(lambdaClient, name)
@@ -278,7 +420,7 @@ func manageAwsLambda(functionName string, status StatusResult, lambdaClient *lam
 	}
 
 	if status.State == 3 || status.State == 2 {
-		err := CreateLambdaFunction(lambdaClient, name, strings.Replace(functionName, "-", "", 0), zipContent, restApiID, zone, accountId, bucket, lambdaRole)
+		err := CreateLambdaFunction(lambdaClient, name, strings.Replace(functionName, "-", "", 0), zipContent, restApiID, zone, accountId, bucket, lambdaRole, lambdaEnvironment)
 		if err != nil {
 			log.Fatalf("Failed to create function '%v': " + err.Error())
 		}
@@ -35,14 +35,9 @@ type StatusResult struct {
 	State int
 	Error error
 }
-type AwsLambdaStatuses struct {
-	GetMachineId       StatusResult
-	RequestMachineId   StatusResult
-	ListMachineIds     StatusResult
-	RenewMachineId     StatusResult
-	RevokeMachineId    StatusResult
-	GetMachineIdStatus StatusResult
-}
+
+// AwsLambdaStatuses are the statuses of the Lambdas of CONNECTOR_FUNCTIONS by their name
+type AwsLambdaStatuses map[string]StatusResult
 
 type SnowflakeFunctionStatuses struct {
 	SnowflakeAccount    string
@@ -52,12 +47,7 @@ type SnowflakeFunctionStatuses struct {
 	SnowflakeUser       string
 	SnowflakeRole       string
 	SnowflakeConnection StatusResult
-	GetMachineId        StatusResult
-	RequestMachineId    StatusResult
-	ListMachineIds      StatusResult
-	RenewMachineId      StatusResult
-	RevokeMachineId     StatusResult
-	GetMachineIdStatus  StatusResult
+	Functions           map[string]StatusResult // by the name of the Snowflake function
 }
 
 type FunctionCheckState struct {
@@ -149,12 +139,10 @@ func GetStatus(tabIndex int, c ConfigOptions, s3Client *s3.Client, lambdaClient
 
 	// Check AWS lambas
 	lambda_state := FunctionCheckState{}
-	ret.AwsLambas_Details.GetMachineId = getLambdaFunctionStatus(lambdaClient, LAMBDA_FUNCTION_NAME_GETMACHINEID, &lambda_state)
-	ret.AwsLambas_Details.RequestMachineId = getLambdaFunctionStatus(lambdaClient, LAMBDA_FUNCTION_NAME_REQUESTMACHINEID, &lambda_state)
-	ret.AwsLambas_Details.ListMachineIds = getLambdaFunctionStatus(lambdaClient, LAMBDA_FUNCTION_NAME_LISTMACHINEIDS, &lambda_state)
-	ret.AwsLambas_Details.RenewMachineId = getLambdaFunctionStatus(lambdaClient, LAMBDA_FUNCTION_NAME_RENEWMACHINEID, &lambda_state)
-	ret.AwsLambas_Details.RevokeMachineId = getLambdaFunctionStatus(lambdaClient, LAMBDA_FUNCTION_NAME_REVOKEMACHINEID, &lambda_state)
-	ret.AwsLambas_Details.GetMachineIdStatus = getLambdaFunctionStatus(lambdaClient, LAMBDA_FUNCTION_NAME_GETMACHINEIDSTATUS, &lambda_state)
+	ret.AwsLambas_Details = AwsLambdaStatuses{}
+	for _, function := range CONNECTOR_FUNCTIONS {
+		ret.AwsLambas_Details[function.Lambda] = getLambdaFunctionStatus(lambdaClient, function.Lambda, &lambda_state)
+	}
 
 	if lambda_state.AnyError {
 		ret.AwsLambdas.State = 2
@@ -197,12 +185,11 @@ func GetStatus(tabIndex int, c ConfigOptions, s3Client *s3.Client, lambdaClient
 		}
 
 		// Check Snowflake External Functions
-		sfd.GetMachineId = getSnowflakeFunctionStatus(snowflake, SNOWFLAKE_FUNCTION_NAME_GETMACHINEID, &snowflake_state)
-		sfd.RequestMachineId = getSnowflakeFunctionStatus(snowflake, SNOWFLAKE_FUNCTION_NAME_REQUESTMACHINEID, &snowflake_state)
-		sfd.ListMachineIds = getSnowflakeFunctionStatus(snowflake, SNOWFLAKE_FUNCTION_NAME_LISTMACHINEIDS, &snowflake_state)
-		sfd.RenewMachineId = getSnowflakeFunctionStatus(snowflake, SNOWFLAKE_FUNCTION_NAME_RENEWMACHINEID, &snowflake_state)
-		sfd.RevokeMachineId = getSnowflakeFunctionStatus(snowflake, SNOWFLAKE_FUNCTION_NAME_REVOKEMACHINEID, &snowflake_state)
-		sfd.GetMachineIdStatus = getSnowflakeFunctionStatus(snowflake, SNOWFLAKE_FUNCTION_NAME_GETMACHINEIDSTATUS, &snowflake_state)
+		sfd.Functions = map[string]StatusResult{}
+		for _, function := range CONNECTOR_FUNCTIONS {
+			sfd.Functions[function.Snowflake] = getSnowflakeFunctionStatus(snowflake, function.Snowflake, &snowflake_state)
+		}
+		sfd.Functions[SNOWFLAKE_FUNCTION_NAME_MACHINEIDINVENTORY] = getSnowflakeFunctionStatus(snowflake, SNOWFLAKE_FUNCTION_NAME_MACHINEIDINVENTORY, &snowflake_state)
 
 		ret.SnowflakeFunctions_Details = append(ret.SnowflakeFunctions_Details, sfd)
 	}
@@ -233,24 +220,19 @@ func PrintStatus(status ServiceStatus) {
 	fmt.Printf("")
 	printStatusResult("AWS API Gateway", status.AwsGateway, "Aws API Gateway exists", "AWS API Gateway not found", "AWS APi Gateway not found")
 	printStatusResult("AWS Lambdas", status.AwsLambdas, "All lambdas are online", "One or more lambdas are in error state or missing", "One or more lambdas are missing")
-	printAwsLambdaResult("GetMachineId", status.AwsLambas_Details.GetMachineId, 1)
-	printAwsLambdaResult("RequestMachineId", status.AwsLambas_Details.RequestMachineId, 1)
-	printAwsLambdaResult("ListMachineIds", status.AwsLambas_Details.ListMachineIds, 1)
-	printAwsLambdaResult("RenewMachineId", status.AwsLambas_Details.RenewMachineId, 1)
-	printAwsLambdaResult("RevokeMachineId", status.AwsLambas_Details.RevokeMachineId, 1)
-	printAwsLambdaResult("GetMachineIdStatus", status.AwsLambas_Details.GetMachineIdStatus, 1)
+	for _, function := range CONNECTOR_FUNCTIONS {
+		printAwsLambdaResult(function.Lambda, status.AwsLambas_Details[function.Lambda], 1)
+	}
 	fmt.Printf("")
 	printStatusResult("Snowflake health", status.SnowflakeHealth, "Success", "Error", "")
 	fmt.Printf("")
 	fmt.Printf("Detailed snowflake results: %v", len(status.SnowflakeFunctions_Details))
 	for _, status := range status.SnowflakeFunctions_Details {
 		fmt.Printf("\n\n\tSnowflake Account: '%v', Warehouse: '%v', Schema: '%v':\n", status.SnowflakeAccount, status.SnowflakeWarehouse, status.SnowflakeSchema)
-		printAwsLambdaResult("GetMachineId", status.GetMachineId, 2)
-		printAwsLambdaResult("RequestMachineId", status.RequestMachineId, 2)
-		printAwsLambdaResult("ListMachineIds", status.ListMachineIds, 2)
-		printAwsLambdaResult("RenewMachineId", status.RenewMachineId, 2)
-		printAwsLambdaResult("RevokeMachineId", status.RevokeMachineId, 2)
-		printAwsLambdaResult("GetMachineIdStatus", status.GetMachineIdStatus, 2)
+		for _, function := range CONNECTOR_FUNCTIONS {
+			printAwsLambdaResult(function.Snowflake, status.Functions[function.Snowflake], 2)
+		}
+		printAwsLambdaResult(SNOWFLAKE_FUNCTION_NAME_MACHINEIDINVENTORY, status.Functions[SNOWFLAKE_FUNCTION_NAME_MACHINEIDINVENTORY], 2)
 	}
 
 }
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Layout of the audit trail written by the Lambdas, see audit.go of the connector
+const S3_AUDIT_RECORDS_PREFIX = "audit/records/"
+const S3_AUDIT_HEADS_PREFIX = "audit/heads/"
+const S3_AUDIT_ANCHORS_PREFIX = "audit/anchors/"
+
+// auditHashSuffix is the Hash field, the last field of every record
+var auditHashSuffix = regexp.MustCompile(`,"Hash":"([0-9a-f]{64})"}$`)
+
+// auditChainLink is the part of an audit record which links it into the chain of its stream
+type auditChainLink struct {
+	Stream       string `json:"Stream"`
+	Sequence     int64  `json:"Sequence"`
+	PreviousHash string `json:"PreviousHash"`
+	Hash         string `json:"Hash"`
+	key          string
+}
+
+type auditHead struct {
+	Stream   string `json:"Stream"`
+	Sequence int64  `json:"Sequence"`
+	Hash     string `json:"Hash"`
+}
+
+// parseAuditRecord checks the hash of a record, which covers the record without its Hash field
+func parseAuditRecord(key string, data []byte) (auditChainLink, error) {
+	line := bytes.TrimSuffix(data, []byte("\n"))
+	link := auditChainLink{key: key}
+	if bytes.Contains(line, []byte("\n")) {
+		return link, fmt.Errorf("%s: more than one record", key)
+	}
+	match := auditHashSuffix.FindSubmatchIndex(line)
+	if match == nil {
+		return link, fmt.Errorf("%s: the record has no hash", key)
+	}
+	if err := json.Unmarshal(line, &link); err != nil {
+		return link, fmt.Errorf("%s: invalid record: %v", key, err)
+	}
+	sum := sha256.Sum256(append(append([]byte{}, line[:match[0]]...), '}'))
+	if hex.EncodeToString(sum[:]) != link.Hash {
+		return link, fmt.Errorf("%s: the record was modified, its hash does not match", key)
+	}
+	return link, nil
+}
+
+func missingAuditRecords(stream string, first int64, last int64) string {
+	if first == last {
+		return fmt.Sprintf("stream %s: record %d is missing", stream, first)
+	}
+	return fmt.Sprintf("stream %s: records %d to %d are missing", stream, first, last)
+}
+
+// verifyAuditChain returns every problem of the audit trail: modified records, gaps in the sequence of a
+// stream, broken links between records, streams which do not end at their head and records which do not
+// match their anchor in the Object Lock bucket, which also finds streams removed with their head
+func verifyAuditChain(records map[string][]byte, heads map[string][]byte, anchors map[string][]byte) ([]string, int) {
+	problems, verified := verifyAuditStreams(records, heads)
+	problems = append(problems, verifyAuditAnchors(records, anchors)...)
+	unique := map[string]bool{}
+	for _, problem := range problems {
+		unique[problem] = true
+	}
+	problems = problems[:0]
+	for problem := range unique {
+		problems = append(problems, problem)
+	}
+	sort.Strings(problems)
+	return problems, verified
+}
+
+// verifyAuditAnchors checks that the record of every anchor is in the trail with the hash of the anchor
+func verifyAuditAnchors(records map[string][]byte, anchors map[string][]byte) (problems []string) {
+	hashes := map[string]map[int64]string{}
+	for key, data := range records {
+		link, err := parseAuditRecord(key, data)
+		if err != nil {
+			continue // reported by verifyAuditStreams
+		}
+		if hashes[link.Stream] == nil {
+			hashes[link.Stream] = map[int64]string{}
+		}
+		hashes[link.Stream][link.Sequence] = link.Hash
+	}
+	missing := map[string][]int64{}
+	for key, data := range anchors {
+		anchor := auditHead{}
+		if err := json.Unmarshal(data, &anchor); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid anchor: %v", key, err))
+			continue
+		}
+		hash, ok := hashes[anchor.Stream][anchor.Sequence]
+		switch {
+		case !ok:
+			missing[anchor.Stream] = append(missing[anchor.Stream], anchor.Sequence)
+		case hash != anchor.Hash:
+			problems = append(problems, fmt.Sprintf("stream %s: record %d does not match its anchor", anchor.Stream, anchor.Sequence))
+		}
+	}
+	for stream, sequences := range missing {
+		if len(hashes[stream]) == 0 {
+			problems = append(problems, fmt.Sprintf("stream %s: the stream was removed, its %d anchored records are missing", stream, len(sequences)))
+			continue
+		}
+		sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+		first := sequences[0]
+		for i := 1; i <= len(sequences); i++ {
+			if i == len(sequences) || sequences[i] != sequences[i-1]+1 {
+				problems = append(problems, missingAuditRecords(stream, first, sequences[i-1]))
+				if i < len(sequences) {
+					first = sequences[i]
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// verifyAuditStreams checks the chain of every stream against its head
+func verifyAuditStreams(records map[string][]byte, heads map[string][]byte) (problems []string, verified int) {
+	streams := map[string][]auditChainLink{}
+	for key, data := range records {
+		link, err := parseAuditRecord(key, data)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		streams[link.Stream] = append(streams[link.Stream], link)
+	}
+	lastOfStream := map[string]auditChainLink{}
+	for stream, links := range streams {
+		sort.Slice(links, func(i, j int) bool { return links[i].Sequence < links[j].Sequence })
+		previous := auditChainLink{}
+		for _, link := range links {
+			switch {
+			case link.Sequence == previous.Sequence:
+				problems = append(problems, fmt.Sprintf("stream %s: record %d is duplicated in %s", stream, link.Sequence, link.key))
+				continue
+			case link.Sequence != previous.Sequence+1:
+				problems = append(problems, missingAuditRecords(stream, previous.Sequence+1, link.Sequence-1))
+			case link.PreviousHash != previous.Hash:
+				problems = append(problems, fmt.Sprintf("stream %s: record %d does not follow record %d", stream, link.Sequence, previous.Sequence))
+			default:
+				verified++
+			}
+			previous = link
+		}
+		lastOfStream[stream] = previous
+	}
+	for key, data := range heads {
+		head := auditHead{}
+		if err := json.Unmarshal(data, &head); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid head: %v", key, err))
+			continue
+		}
+		last, ok := lastOfStream[head.Stream]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("stream %s: all %d records are missing", head.Stream, head.Sequence))
+		case last.Sequence < head.Sequence:
+			problems = append(problems, missingAuditRecords(head.Stream, last.Sequence+1, head.Sequence))
+		case last.Sequence == head.Sequence && last.Hash != head.Hash:
+			problems = append(problems, fmt.Sprintf("stream %s: record %d does not match the head of the stream", head.Stream, last.Sequence))
+		}
+		delete(lastOfStream, head.Stream)
+	}
+	for stream := range lastOfStream {
+		problems = append(problems, fmt.Sprintf("stream %s: the head of the stream is missing", stream))
+	}
+	return problems, verified
+}
+
+func readBucketFolder(s3Client *s3.Client, bucket string, prefix string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			data, err := ReadFile(s3Client, bucket, aws.ToString(object.Key))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", aws.ToString(object.Key), err)
+			}
+			files[aws.ToString(object.Key)] = data
+		}
+	}
+	return files, nil
+}
+
+// readAuditAnchors reads every anchor of the Object Lock bucket. Object Lock keeps the first version of an
+// overwritten or deleted anchor, it is the one returned, and every overwrite or delete is a problem.
+func readAuditAnchors(s3Client *s3.Client, bucket string) (map[string][]byte, []string, error) {
+	anchors := map[string][]byte{}
+	problems := []string{}
+	input := &s3.ListObjectVersionsInput{Bucket: aws.String(bucket), Prefix: aws.String(S3_AUDIT_ANCHORS_PREFIX)}
+	first := map[string]types.ObjectVersion{}
+	for {
+		page, err := s3Client.ListObjectVersions(context.TODO(), input)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, version := range page.Versions {
+			key := aws.ToString(version.Key)
+			if previous, ok := first[key]; ok {
+				problems = append(problems, fmt.Sprintf("%s: the anchor was overwritten", key))
+				if !version.LastModified.Before(*previous.LastModified) {
+					continue
+				}
+			}
+			first[key] = version
+		}
+		for _, marker := range page.DeleteMarkers {
+			problems = append(problems, fmt.Sprintf("%s: the anchor was deleted", aws.ToString(marker.Key)))
+		}
+		if !page.IsTruncated {
+			break
+		}
+		input.KeyMarker, input.VersionIdMarker = page.NextKeyMarker, page.NextVersionIdMarker
+	}
+	for key, version := range first {
+		object, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), VersionId: version.VersionId})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %v", key, err)
+		}
+		data, err := ioutil.ReadAll(object.Body)
+		object.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %v", key, err)
+		}
+		anchors[key] = data
+	}
+	return anchors, problems, nil
+}
+
+// VerifyAuditTrail reads the audit trail of the bucket and fails when a record was modified or removed.
+// Without the bucket of the anchors the removal of a stream together with its head is not detected.
+func VerifyAuditTrail(s3Client *s3.Client, bucket string, anchorBucket string) {
+	Log(true, "Reading audit trail", 0)
+	records, err := readBucketFolder(s3Client, bucket, S3_AUDIT_RECORDS_PREFIX)
+	if err != nil {
+		log.Fatalf("Failed to read audit records: %v", err)
+	}
+	heads, err := readBucketFolder(s3Client, bucket, S3_AUDIT_HEADS_PREFIX)
+	if err != nil {
+		log.Fatalf("Failed to read audit heads: %v", err)
+	}
+	anchors := map[string][]byte{}
+	anchorProblems := []string{}
+	if anchorBucket == "" {
+		Log(false, "No auditanchorbucket in the config file, removed streams can not be detected", 1)
+	} else {
+		anchors, anchorProblems, err = readAuditAnchors(s3Client, anchorBucket)
+		if err != nil {
+			log.Fatalf("Failed to read audit anchors: %v", err)
+		}
+	}
+	problems, verified := verifyAuditChain(records, heads, anchors)
+	problems = append(anchorProblems, problems...)
+	for _, problem := range problems {
+		Log(false, "%s", 1, problem)
+	}
+	if len(problems) > 0 {
+		log.Fatalf("The audit trail is not intact: %d problems in %d records", len(problems), len(records))
+	}
+	Log(false, "The audit trail is intact: %d records in %d streams verified", 0, verified, len(heads))
+}
@@ -7,7 +7,9 @@ require (
 	github.com/aws/aws-lambda-go v1.24.0
 	github.com/aws/aws-sdk-go v1.38.63
 	github.com/palette-software/go-log-targets v0.0.0-20200609204140-16fbfda0867a
+	github.com/pavlo-v-chernykh/keystore-go/v4 v4.5.0
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli v1.21.0 // indirect
 	github.com/zfjagann/golang-ring v0.0.0-20210116075443-7c86fdb43134 // indirect
+	software.sslmate.com/src/go-pkcs12 v0.4.0
 )
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"os"
 
 	"github.com/starschema/snowflake-venafi-connector/lambda/utils"
 
@@ -13,19 +12,16 @@ import (
 
 func GetMachineID(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
-	log.AddTarget(os.Stdout, log.LevelDebug)
+	utils.InitLogging(ctx, utils.GET_MID_TYPE)
 
 	configParams, requestParams := utils.ParseSnowflakeParameters(request, utils.GET_MID_TYPE)
 	client, err := utils.NewVenafiConnector(configParams)
 	if err != nil {
 		log.Errorf("Failed to create venafi client from snowflake parameters: %v", err)
-		return events.APIGatewayProxyResponse{ // Error HTTP response
-			Body:       err.Error(),
-			StatusCode: 500,
-		}, err
+		return utils.ConnectorFailureResponse(utils.GET_MID_TYPE, configParams, err)
 	}
 
-	snowflakeResponse, err := client.GetMachineID(ctx, requestParams.RequestID)
+	snowflakeResponse, err := client.GetMachineID(ctx, requestParams.RequestID, requestParams.Options)
 	if err != nil {
 		return events.APIGatewayProxyResponse{
 			Body:       snowflakeResponse,
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"os"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -12,16 +11,13 @@ import (
 
 func GetMachineIDStatus(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
-	log.AddTarget(os.Stdout, log.LevelDebug)
+	utils.InitLogging(ctx, utils.GET_STATUS_MID_TYPE)
 
 	configParams, requestParams := utils.ParseSnowflakeParameters(request, utils.GET_STATUS_MID_TYPE)
 	client, err := utils.NewVenafiConnector(configParams)
 	if err != nil {
 		log.Errorf("Failed to create venafi client from snowflake parameters: %v", err)
-		return events.APIGatewayProxyResponse{ // Error HTTP response
-			Body:       err.Error(),
-			StatusCode: 500,
-		}, err
+		return utils.ConnectorFailureResponse(utils.GET_STATUS_MID_TYPE, configParams, err)
 	}
 	snowflakeResponse, err := client.GetMachineIDStatus(ctx, requestParams.CommonName)
 	if err != nil {
@@ -30,7 +26,7 @@ func GetMachineIDStatus(ctx context.Context, request events.APIGatewayProxyReque
 			StatusCode: 500,
 		}, nil // here we shall return the actual error to snowflake
 	}
-	log.Infof("Successfully got status of certificate: %s", requestParams.RequestID)
+	log.Infof("Successfully got status of certificate: %s", requestParams.CommonName)
 	return events.APIGatewayProxyResponse{ // Success HTTP response
 		Body:       snowflakeResponse,
 		StatusCode: 200,
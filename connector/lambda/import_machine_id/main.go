@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	log "github.com/palette-software/go-log-targets"
+	"github.com/starschema/snowflake-venafi-connector/lambda/utils"
+)
+
+func ImportMachineID(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	utils.InitLogging(ctx, utils.IMPORT_MID_TYPE)
+
+	configParams, requestParams := utils.ParseSnowflakeParameters(request, utils.IMPORT_MID_TYPE)
+	client, err := utils.NewVenafiConnector(configParams)
+	if err != nil {
+		log.Errorf("Failed to create venafi client from snowflake parameters: %v", err)
+		return utils.ConnectorFailureResponse(utils.IMPORT_MID_TYPE, configParams, err)
+	}
+	snowflakeResponse, err := client.ImportMachineID(ctx, requestParams.ObjectName, requestParams.Certificate, requestParams.PrivateKey, requestParams.Passphrase)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       snowflakeResponse,
+			StatusCode: 500,
+		}, err
+	}
+	log.Info("Successfully imported certificate")
+	return events.APIGatewayProxyResponse{ // Success HTTP response
+		Body:       snowflakeResponse,
+		StatusCode: 200,
+	}, nil
+}
+
+func main() {
+	lambda.Start(ImportMachineID)
+}
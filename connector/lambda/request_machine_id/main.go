@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"os"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -12,25 +11,22 @@ import (
 
 func RequestMachineID(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
-	log.AddTarget(os.Stdout, log.LevelDebug)
+	utils.InitLogging(ctx, utils.REQUEST_MID_TYPE)
 
 	configParams, requestParams := utils.ParseSnowflakeParameters(request, utils.REQUEST_MID_TYPE)
 	client, err := utils.NewVenafiConnector(configParams)
 	if err != nil {
 		log.Errorf("Failed to create venafi client from snowflake parameters: %v", err)
-		return events.APIGatewayProxyResponse{ // Error HTTP response
-			Body:       err.Error(),
-			StatusCode: 500,
-		}, err
+		return utils.ConnectorFailureResponse(utils.REQUEST_MID_TYPE, configParams, err)
 	}
-	snowflakeResponse, err := client.RequestMachineID(ctx, requestParams.CommonName, requestParams.UPN, requestParams.DNSName)
+	snowflakeResponse, err := client.RequestMachineID(ctx, requestParams.CommonName, requestParams.UPN, requestParams.DNSName, requestParams.Options)
 	if err != nil {
 		return events.APIGatewayProxyResponse{
 			Body:       snowflakeResponse,
 			StatusCode: 500,
 		}, err
 	}
-	log.Infof("Successfully requested certificate: %s", requestParams.RequestID)
+	log.Infof("Successfully requested certificate: %s", requestParams.CommonName)
 	return events.APIGatewayProxyResponse{ // Success HTTP response
 		Body:       snowflakeResponse,
 		StatusCode: 200,
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"os"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -12,19 +11,16 @@ import (
 
 func RevokeMachineID(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
-	log.AddTarget(os.Stdout, log.LevelDebug)
+	utils.InitLogging(ctx, utils.REVOKE_MID_TYPE)
 
 	configParams, requestParams := utils.ParseSnowflakeParameters(request, utils.REVOKE_MID_TYPE)
 
 	client, err := utils.NewVenafiConnector(configParams)
 	if err != nil {
 		log.Errorf("Failed to create venafi client from snowflake parameters: %v", err)
-		return events.APIGatewayProxyResponse{ // Error HTTP response
-			Body:       err.Error(),
-			StatusCode: 500,
-		}, err
+		return utils.ConnectorFailureResponse(utils.REVOKE_MID_TYPE, configParams, err)
 	}
-	snowflakeResponse, err := client.RevokeMachineID(ctx, requestParams.RequestID, requestParams.Disable)
+	snowflakeResponse, err := client.RevokeMachineID(ctx, requestParams.RequestID, requestParams.Disable, requestParams.Options)
 	if err != nil {
 		return events.APIGatewayProxyResponse{
 			Body:       snowflakeResponse,
@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	log "github.com/palette-software/go-log-targets"
+)
+
+// Status of a certificate waiting for a workflow ticket to be approved in TPP
+const APPROVAL_STATUS_AWAITING = "awaiting_approval"
+
+// Status of the resolved tickets in the response of APPROVE_MACHINE_ID and REJECT_MACHINE_ID
+const APPROVAL_STATUS_APPROVED = "approved"
+const APPROVAL_STATUS_REJECTED = "rejected"
+
+// approvalCheckInterval is how long a certificate is retrieved before its workflow tickets are checked again
+const approvalCheckInterval = 10 * time.Second
+
+// Workflow ticket values of the WebSDK
+const tppTicketResultSuccess = 1
+const tppTicketStatusPending = "Pending"
+const tppTicketStatusApproved = "Approved"
+const tppTicketStatusRejected = "Rejected"
+
+type tppTicketEnumerateResponse struct {
+	GUIDs  []string `json:"GUIDs"`
+	Result int      `json:"Result"`
+}
+
+type tppTicketDetailsResponse struct {
+	ApprovalExplanation string   `json:"ApprovalExplanation"`
+	Approvers           []string `json:"Approvers"`
+	Created             string   `json:"Created"`
+	IssuedDueTo         string   `json:"IssuedDueTo"`
+	ObjectDN            string   `json:"ObjectDN"`
+	Result              int      `json:"Result"`
+	Status              string   `json:"Status"`
+	Updated             string   `json:"Updated"`
+}
+
+type tppTicketUpdateResponse struct {
+	Result int `json:"Result"`
+}
+
+// ApprovalTicket is a workflow ticket of TPP which has to be approved before the certificate is issued
+type ApprovalTicket struct {
+	Ticket      string   `json:"Ticket"`
+	DN          string   `json:"DN"`
+	Status      string   `json:"Status"`
+	Approvers   []string `json:"Approvers"`
+	Explanation string   `json:"Explanation,omitempty"` // why the approval is needed, as configured in the workflow
+	IssuedDueTo string   `json:"IssuedDueTo,omitempty"`
+	Created     string   `json:"Created,omitempty"`
+	Updated     string   `json:"Updated,omitempty"`
+}
+
+// AwaitingApprovalResponse is returned instead of the certificate while a workflow ticket is pending.
+// The private key of a new request is only returned here, it can not be retrieved once the ticket is approved.
+type AwaitingApprovalResponse struct {
+	RequestID  string           `json:"RequestID"`
+	Status     string           `json:"Status"`
+	Approvals  []ApprovalTicket `json:"Approvals"`
+	PrivateKey string           `json:"PrivateKey,omitempty"`
+	Passphrase string           `json:"Passphrase,omitempty"`
+}
+
+type PendingApprovalsResponse struct {
+	Approvals []ApprovalTicket `json:"Approvals"`
+}
+
+// ResolveApprovalResponse is the response of APPROVE_MACHINE_ID and REJECT_MACHINE_ID
+type ResolveApprovalResponse struct {
+	DN      string   `json:"DN"`
+	Status  string   `json:"Status"`
+	Tickets []string `json:"Tickets"`
+}
+
+// approvalTickets returns the pending workflow tickets of the certificate, or the tickets the
+// service account can approve when the DN is empty
+func (c *venafiConnector) approvalTickets(certificateDN string) ([]ApprovalTicket, error) {
+	body := map[string]string{}
+	if certificateDN != "" {
+		body["ObjectDN"] = certificateDN
+	}
+	enumerateResponse := &tppTicketEnumerateResponse{}
+	if err := c.api.post("Workflow/Ticket/Enumerate", body, enumerateResponse); err != nil {
+		return nil, err
+	}
+	tickets := []ApprovalTicket{}
+	for _, guid := range enumerateResponse.GUIDs {
+		details := &tppTicketDetailsResponse{}
+		if err := c.api.post("Workflow/Ticket/Details", map[string]string{"GUID": guid}, details); err != nil {
+			return nil, err
+		}
+		if details.Result != tppTicketResultSuccess {
+			return nil, fmt.Errorf("failed to read workflow ticket %s, result code: %d", guid, details.Result)
+		}
+		if details.Status != tppTicketStatusPending {
+			continue
+		}
+		tickets = append(tickets, ApprovalTicket{
+			Ticket:      guid,
+			DN:          details.ObjectDN,
+			Status:      details.Status,
+			Approvers:   details.Approvers,
+			Explanation: details.ApprovalExplanation,
+			IssuedDueTo: details.IssuedDueTo,
+			Created:     details.Created,
+			Updated:     details.Updated,
+		})
+	}
+	return tickets, nil
+}
+
+// pendingApprovals returns the pending workflow tickets of the certificate. Failures are only logged,
+// the service account may not be allowed to read tickets and the retrieval continues as before.
+func (c *venafiConnector) pendingApprovals(certificateDN string) []ApprovalTicket {
+	if c.api == nil {
+		return nil
+	}
+	tickets, err := c.approvalTickets(certificateDN)
+	if err != nil {
+		log.Errorf("Failed to read workflow tickets of %s: %v", certificateDN, err)
+		return nil
+	}
+	return tickets
+}
+
+func isRetrievePending(err error) bool {
+	switch err.(type) {
+	case endpoint.ErrCertificatePending, endpoint.ErrRetrieveCertificateTimeout:
+		return true
+	}
+	return false
+}
+
+// retrieveCertificate retrieves the certificate for at most timeout. The workflow tickets are checked
+// while the certificate is not issued, so a certificate awaiting approval is reported without waiting
+// for the whole timeout.
+func (c *venafiConnector) retrieveCertificate(req *certificate.Request, timeout time.Duration) (*certificate.PEMCollection, []ApprovalTicket, error) {
+	deadline := time.Now().Add(timeout)
+	req.Timeout = 0
+	for {
+		pcc, err := c.client.RetrieveCertificate(req)
+		if err == nil || !isRetrievePending(err) {
+			return pcc, nil, err
+		}
+		if approvals := c.pendingApprovals(unescapeDN(req.PickupID)); len(approvals) > 0 {
+			log.Infof("Certificate %s is awaiting approval", req.PickupID)
+			return nil, approvals, nil
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, nil, err
+		}
+		req.Timeout = approvalCheckInterval
+		if left < req.Timeout {
+			req.Timeout = left
+		}
+	}
+}
+
+func createAwaitingApprovalResponse(response AwaitingApprovalResponse) (string, error) {
+	response.Status = APPROVAL_STATUS_AWAITING
+	data, err := json.Marshal(response)
+	if err != nil {
+		return createSnowflakeResponse(err.Error()), err
+	}
+	return createSnowflakeResponseWithEscape(data), nil
+}
+
+// ListPendingApprovals returns the pending workflow tickets the service account can approve, in the zone when it is set
+func (c *venafiConnector) ListPendingApprovals(ctx context.Context) (string, error) {
+	defer c.audited(LIST_APPROVALS_MID_TYPE, "")()
+	if err := c.authorize(LIST_APPROVALS_MID_TYPE, getPolicyDN(c.zone)); err != nil {
+		return c.fail(err), nil
+	}
+	tickets, err := c.approvalTickets("")
+	if err != nil {
+		log.Errorf("Failed to list workflow tickets: %v", err)
+		return c.fail(err), nil
+	}
+	response := PendingApprovalsResponse{Approvals: []ApprovalTicket{}}
+	policyDN := strings.ToLower(getPolicyDN(c.zone))
+	for _, ticket := range tickets {
+		if policyDN == "" || strings.HasPrefix(strings.ToLower(ticket.DN), policyDN+"\\") {
+			response.Approvals = append(response.Approvals, ticket)
+		}
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return c.fail(err), err
+	}
+	log.Infof("Found %d pending approvals", len(response.Approvals))
+	return createSnowflakeResponseWithEscape(data), nil
+}
+
+// ApproveMachineID approves the pending workflow tickets of the certificate
+func (c *venafiConnector) ApproveMachineID(ctx context.Context, requestID string, explanation string) (string, error) {
+	return c.resolveApproval(APPROVE_MID_TYPE, requestID, explanation)
+}
+
+// RejectMachineID rejects the pending workflow tickets of the certificate, TPP requires an explanation
+func (c *venafiConnector) RejectMachineID(ctx context.Context, requestID string, explanation string) (string, error) {
+	return c.resolveApproval(REJECT_MID_TYPE, requestID, explanation)
+}
+
+// checkNotRequester denies the approval of a request to the Snowflake user who submitted it, according to the ledger.
+// Requests without submitter in the ledger can only be approved when the guardrail policy allows it.
+func (c *venafiConnector) checkNotRequester(requestID string) error {
+	entry, err := readLedgerEntry(requestID)
+	if err != nil {
+		log.Errorf("Failed to read the ledger entry of %s: %v", unescapeDN(requestID), err)
+		return fmt.Errorf("failed to check who submitted %s: %v", unescapeDN(requestID), err)
+	}
+	if entry == nil || entry.Caller == "" {
+		allowed, err := allowsUntrackedApproval(unescapeDN(requestID))
+		if err != nil {
+			return err
+		}
+		if allowed {
+			return nil
+		}
+		err = newConnectorError(ERR_NOT_AUTHORIZED, "the submitter of %s is not in the request ledger, the guardrail policy does not allow approving it", unescapeDN(requestID))
+		log.Warningf("Authorization denied in query %s: %v", c.requestContext.QueryID, err)
+		return err
+	}
+	if strings.EqualFold(entry.Caller, c.requestContext.User) {
+		err := newConnectorError(ERR_NOT_AUTHORIZED, "%s submitted %s and cannot approve it", c.requestContext.Caller(), entry.RequestID)
+		log.Warningf("Authorization denied in query %s: %v", c.requestContext.QueryID, err)
+		return err
+	}
+	return nil
+}
+
+func (c *venafiConnector) resolveApproval(operation string, requestID string, explanation string) (string, error) {
+	defer c.audited(operation, requestID)()
+	explanation = strings.TrimSpace(explanation)
+	if operation == REJECT_MID_TYPE && explanation == "" {
+		err := newConnectorError(ERR_INVALID_APPROVAL, "an explanation is required to reject a request")
+		return c.fail(err), nil
+	}
+	certificateDN := unescapeDN(requestID)
+	if err := c.authorize(operation, certificateDN); err != nil {
+		return c.fail(err), nil
+	}
+	if err := enforceOperationGuardrails(operation, certificateDN); err != nil {
+		log.Errorf("Approval rejected by guardrail policy: %v", err)
+		return c.fail(err), nil
+	}
+	if operation == APPROVE_MID_TYPE {
+		if err := c.checkNotRequester(requestID); err != nil {
+			return c.fail(err), nil
+		}
+	}
+	tickets, err := c.approvalTickets(certificateDN)
+	if err != nil {
+		log.Errorf("Failed to read workflow tickets: %v", err)
+		return c.fail(err), nil
+	}
+	if len(tickets) == 0 {
+		err := newConnectorError(ERR_INVALID_APPROVAL, "certificate %s has no pending approval", certificateDN)
+		return c.fail(err), nil
+	}
+	response := ResolveApprovalResponse{DN: certificateDN, Status: APPROVAL_STATUS_APPROVED, Tickets: []string{}}
+	status := tppTicketStatusApproved
+	if operation == REJECT_MID_TYPE {
+		response.Status, status = APPROVAL_STATUS_REJECTED, tppTicketStatusRejected
+	}
+	for _, ticket := range tickets {
+		updateResponse := &tppTicketUpdateResponse{}
+		body := map[string]string{"GUID": ticket.Ticket, "Status": status, "Explanation": explanation}
+		if err := c.api.post("Workflow/Ticket/UpdateStatus", body, updateResponse); err != nil {
+			log.Errorf("Failed to update workflow ticket %s: %v", ticket.Ticket, err)
+			return c.fail(err), nil
+		}
+		if updateResponse.Result != tppTicketResultSuccess {
+			// TPP refuses tickets the service account is not an approver of
+			err := fmt.Errorf("failed to update workflow ticket %s of %s, result code: %d", ticket.Ticket, certificateDN, updateResponse.Result)
+			log.Errorf("%v", err)
+			return c.fail(err), nil
+		}
+		response.Tickets = append(response.Tickets, ticket.Ticket)
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return c.fail(err), err
+	}
+	log.Infof("Workflow tickets of %s are %s", certificateDN, response.Status)
+	return createSnowflakeResponseWithEscape(data), nil
+}
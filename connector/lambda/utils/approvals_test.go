@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/stretchr/testify/assert"
+)
+
+// retrieveConnector overrides RetrieveCertificate of vcert's connector
+type retrieveConnector struct {
+	endpoint.Connector
+	err error
+}
+
+func (c *retrieveConnector) RetrieveCertificate(req *certificate.Request) (*certificate.PEMCollection, error) {
+	return nil, c.err
+}
+
+func newApprovalTestServer(t *testing.T, updates *[]map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case "/vedsdk/Workflow/Ticket/Enumerate":
+			if body["ObjectDN"] == "" {
+				w.Write([]byte(`{"GUIDs": ["{web}", "{db}", "{done}"], "Result": 1}`))
+			} else {
+				assert.Equal(t, "\\VED\\Policy\\Web\\app", body["ObjectDN"])
+				w.Write([]byte(`{"GUIDs": ["{web}"], "Result": 1}`))
+			}
+		case "/vedsdk/Workflow/Ticket/Details":
+			dn, status := map[string]string{"{web}": "\\VED\\Policy\\Web\\app", "{db}": "\\VED\\Policy\\DB\\db"}[body["GUID"]], "Pending"
+			if body["GUID"] == "{done}" {
+				dn, status = "\\VED\\Policy\\Web\\old", "Approved"
+			}
+			fmt.Fprintf(w, `{"ObjectDN": %q, "Status": %q, "Approvers": ["local:{approver}"], "ApprovalExplanation": "Production certificate", "Result": 1}`, dn, status)
+		case "/vedsdk/Workflow/Ticket/UpdateStatus":
+			*updates = append(*updates, body)
+			w.Write([]byte(`{"Result": 1}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestRetrieveCertificateAwaitingApproval(t *testing.T) {
+	useMemoryLedger(t)
+	server := newApprovalTestServer(t, &[]map[string]string{})
+	defer server.Close()
+	c := &venafiConnector{
+		client: &retrieveConnector{err: endpoint.ErrCertificatePending{CertificateID: "\\\\VED\\\\Policy\\\\Web\\\\app"}},
+		api:    newTPPAPIClient(server.URL, "test-token", server.Client()),
+	}
+
+	pcc, approvals, err := c.retrieveCertificate(&certificate.Request{PickupID: "\\\\VED\\\\Policy\\\\Web\\\\app"}, 180*time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, pcc)
+	assert.Len(t, approvals, 1)
+	assert.Equal(t, "{web}", approvals[0].Ticket)
+	assert.Equal(t, []string{"local:{approver}"}, approvals[0].Approvers)
+
+	response, err := c.GetMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", nil)
+	assert.Nil(t, err)
+	assert.Contains(t, response, `"Status":"awaiting_approval"`)
+	assert.Contains(t, response, `"Explanation":"Production certificate"`)
+}
+
+func TestRetrieveCertificateFailure(t *testing.T) {
+	c := &venafiConnector{client: &retrieveConnector{err: fmt.Errorf("certificate does not exist")}}
+	_, approvals, err := c.retrieveCertificate(&certificate.Request{PickupID: "\\VED\\Policy\\Web\\app"}, 180*time.Second)
+	assert.EqualError(t, err, "certificate does not exist")
+	assert.Nil(t, approvals)
+}
+
+func TestListPendingApprovals(t *testing.T) {
+	server := newApprovalTestServer(t, &[]map[string]string{})
+	defer server.Close()
+	c := &venafiConnector{api: newTPPAPIClient(server.URL, "test-token", server.Client()), zone: "Web"}
+
+	response, err := c.ListPendingApprovals(context.Background())
+	assert.Nil(t, err)
+	assert.Contains(t, response, `"Ticket":"{web}"`)
+	assert.NotContains(t, response, `"Ticket":"{db}"`)
+	assert.NotContains(t, response, `"Ticket":"{done}"`)
+}
+
+func TestApproveAndRejectMachineID(t *testing.T) {
+	updates := []map[string]string{}
+	server := newApprovalTestServer(t, &updates)
+	defer server.Close()
+	c := &venafiConnector{api: newTPPAPIClient(server.URL, "test-token", server.Client())}
+	// no guardrail policy in the bucket
+	guardrailCache.policy, guardrailCache.loadedAt = nil, time.Now()
+	defer func() { guardrailCache.loadedAt = time.Time{} }()
+	useMemoryLedger(t)
+
+	// approvals are denied without an authorization policy
+	response, err := c.ApproveMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", "CHG-42")
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_NOT_AUTHORIZED)
+	useAuthorizationPolicy(t, testAuthorizationPolicy)
+	c.requestContext = RequestContext{User: "EVE", Role: "SECURITY_ADMIN", Account: "XY12345"}
+
+	// the user who submitted the request cannot approve it
+	c.recordRequest(REQUEST_MID_TYPE, "\\\\VED\\\\Policy\\\\Web\\\\app", "app")
+	response, err = c.ApproveMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", "CHG-42")
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_NOT_AUTHORIZED)
+	assert.Empty(t, updates)
+	c.requestContext.User = "MALLORY"
+
+	response, err = c.RejectMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", " ")
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_INVALID_APPROVAL)
+	assert.Empty(t, updates)
+
+	response, err = c.ApproveMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", "CHG-42")
+	assert.Nil(t, err)
+	assert.Contains(t, response, `"Status":"approved"`)
+	assert.Equal(t, []map[string]string{{"GUID": "{web}", "Status": "Approved", "Explanation": "CHG-42"}}, updates)
+
+	_, err = c.RejectMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", "not planned")
+	assert.Nil(t, err)
+	assert.Equal(t, "Rejected", updates[1]["Status"])
+}
+
+func TestApproveUntrackedRequest(t *testing.T) {
+	updates := []map[string]string{}
+	server := newApprovalTestServer(t, &updates)
+	defer server.Close()
+	c := &venafiConnector{api: newTPPAPIClient(server.URL, "test-token", server.Client())}
+	c.requestContext = RequestContext{User: "MALLORY", Role: "SECURITY_ADMIN", Account: "XY12345"}
+	useAuthorizationPolicy(t, testAuthorizationPolicy)
+	useMemoryLedger(t)
+	guardrailCache.policy, guardrailCache.loadedAt = nil, time.Now()
+	defer func() { guardrailCache.policy, guardrailCache.loadedAt = nil, time.Time{} }()
+
+	// the request was not submitted through the connector, so its submitter is unknown
+	response, err := c.ApproveMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", "CHG-42")
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_NOT_AUTHORIZED)
+	assert.Empty(t, updates)
+
+	// rejecting does not need the submitter
+	_, err = c.RejectMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", "not planned")
+	assert.Nil(t, err)
+	assert.Len(t, updates, 1)
+
+	guardrailCache.policy = &GuardrailPolicy{Zones: map[string]GuardrailRule{"\\VED\\Policy\\Web": {AllowUntrackedApproval: true}}}
+	response, err = c.ApproveMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", "CHG-42")
+	assert.Nil(t, err)
+	assert.Contains(t, response, `"Status":"approved"`)
+	assert.Len(t, updates, 2)
+}
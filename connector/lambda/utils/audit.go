@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/palette-software/go-log-targets"
+)
+
+// The audit trail has one NDJSON object per operation under audit/records/dt=<date>/hour=<hour>/, the layout
+// Snowpipe loads from. Every Lambda container, which runs one call at a time, writes its own hash chain: a record
+// has the stream of its container, a sequence number and the hash of the previous record of the stream. The last
+// record of each stream is kept under audit/heads/. The Lambdas can rewrite both, so every record is also anchored
+// in the bucket of AUDIT_ANCHOR_BUCKET, which has S3 Object Lock: the anchors can not be changed or removed, and
+// the verifyaudit command of the installer detects removed, edited and truncated records and removed streams.
+const AUDIT_RECORDS_PREFIX = "audit/records/"
+const AUDIT_HEADS_PREFIX = "audit/heads/"
+const AUDIT_ANCHORS_PREFIX = "audit/anchors/"
+
+// Outcome of an operation in the audit trail
+const AUDIT_OUTCOME_SUCCESS = "success"
+const AUDIT_OUTCOME_FAILED = "failed"
+const AUDIT_OUTCOME_DENIED = "denied" // rejected by the authorization policy
+
+var auditStore bucketStore = deploymentBucket{}
+
+// auditAnchorStore returns the write-once bucket of the anchors, nil when AUDIT_ANCHOR_BUCKET is not set
+var auditAnchorStore = func() bucketWriter {
+	if bucket := os.Getenv("AUDIT_ANCHOR_BUCKET"); bucket != "" {
+		return lockedBucket{name: bucket}
+	}
+	return nil
+}
+
+// AuditRecord is the record of an operation. It never contains key material, passphrases or tokens.
+type AuditRecord struct {
+	Stream       string    `json:"Stream"`
+	Sequence     int64     `json:"Sequence"`
+	Time         time.Time `json:"Time"`
+	Operation    string    `json:"Operation"` // Snowflake function name
+	TppURL       string    `json:"TppURL"`
+	Zone         string    `json:"Zone,omitempty"` // zone of the operations which are not called on a certificate
+	CommonName   string    `json:"CommonName,omitempty"`
+	DN           string    `json:"DN,omitempty"`
+	QueryID      string    `json:"QueryID,omitempty"`
+	User         string    `json:"User,omitempty"`
+	Role         string    `json:"Role,omitempty"`
+	Account      string    `json:"Account,omitempty"`
+	Outcome      string    `json:"Outcome"`
+	ErrorCode    string    `json:"ErrorCode,omitempty"` // code of connector errors, empty for errors of TPP
+	PreviousHash string    `json:"PreviousHash"`        // empty for the first record of the stream
+	Hash         string    `json:"Hash,omitempty"`      // must stay the last field, see sealAuditRecord
+}
+
+// AuditHead is the last record written to a stream. The anchor of each record has the same fields.
+type AuditHead struct {
+	Stream   string    `json:"Stream"`
+	Sequence int64     `json:"Sequence"`
+	Hash     string    `json:"Hash"`
+	Updated  time.Time `json:"Updated"`
+}
+
+var auditChain struct {
+	sync.Mutex
+	stream   string
+	sequence int64
+	hash     string
+}
+
+func newAuditStream() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// sealAuditRecord returns the NDJSON line of the record. The hash covers the JSON of the record without
+// the Hash field, and the hash is appended as the last field, so it can be checked on the raw line.
+func sealAuditRecord(record *AuditRecord) ([]byte, error) {
+	record.Hash = ""
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	record.Hash = hex.EncodeToString(sum[:])
+	line := append(data[:len(data)-1:len(data)-1], []byte(fmt.Sprintf(`,"Hash":"%s"}`, record.Hash))...)
+	return append(line, '\n'), nil
+}
+
+func auditAnchorKey(record *AuditRecord) string {
+	return fmt.Sprintf("%s%s/%010d.json", AUDIT_ANCHORS_PREFIX, record.Stream, record.Sequence)
+}
+
+func auditRecordKey(record *AuditRecord) string {
+	t := record.Time.UTC()
+	return fmt.Sprintf("%sdt=%s/hour=%s/%s-%010d.ndjson", AUDIT_RECORDS_PREFIX, t.Format("2006-01-02"), t.Format("15"), record.Stream, record.Sequence)
+}
+
+// writeAuditRecord appends the record to the chain of the container. A record which could not be written
+// does not take a sequence number, a failure is logged and does not fail the operation.
+func writeAuditRecord(record *AuditRecord) {
+	auditChain.Lock()
+	defer auditChain.Unlock()
+	if auditChain.stream == "" {
+		auditChain.stream = newAuditStream()
+	}
+	record.Stream, record.Sequence, record.PreviousHash = auditChain.stream, auditChain.sequence+1, auditChain.hash
+	line, err := sealAuditRecord(record)
+	if err != nil {
+		log.Errorf("Failed to serialize audit record: %v", err)
+		return
+	}
+	if err := auditStore.write(auditRecordKey(record), line); err != nil {
+		log.Errorf("Failed to write audit record of %s: %v", record.Operation, err)
+		return
+	}
+	auditChain.sequence, auditChain.hash = record.Sequence, record.Hash
+	head, _ := json.Marshal(AuditHead{Stream: record.Stream, Sequence: record.Sequence, Hash: record.Hash, Updated: time.Now().UTC()})
+	if err := auditStore.write(AUDIT_HEADS_PREFIX+record.Stream+".json", head); err != nil {
+		log.Errorf("Failed to write audit head of stream %s: %v", record.Stream, err)
+	}
+	if anchors := auditAnchorStore(); anchors != nil {
+		if err := anchors.write(auditAnchorKey(record), head); err != nil {
+			log.Errorf("Failed to write audit anchor of record %d of stream %s: %v", record.Sequence, record.Stream, err)
+		}
+	}
+}
+
+// audited starts the audit record of an operation called on the certificate, or on the zone when dn is empty.
+// The returned function writes it, operations run it deferred.
+func (c *venafiConnector) audited(operation string, dn string) func() {
+	record := &AuditRecord{
+		Time:      time.Now().UTC(),
+		Operation: operationFunctionNames[operation],
+		TppURL:    c.tppURL,
+		QueryID:   c.requestContext.QueryID,
+		User:      c.requestContext.User,
+		Role:      c.requestContext.Role,
+		Account:   c.requestContext.Account,
+		Outcome:   AUDIT_OUTCOME_SUCCESS,
+	}
+	if dn == "" {
+		record.Zone = getPolicyDN(c.zone)
+	} else {
+		record.DN = unescapeDN(dn)
+	}
+	c.audit = record
+	return func() {
+		if record.ErrorCode != "" {
+			log.Infof("%s finished: %s (%s)", record.Operation, record.Outcome, record.ErrorCode)
+		} else {
+			log.Infof("%s finished: %s", record.Operation, record.Outcome)
+		}
+		writeAuditRecord(record)
+		c.audit = nil
+	}
+}
+
+// auditCertificate adds the certificate of the operation to its audit record once it is known
+func (c *venafiConnector) auditCertificate(commonName string, dn string) {
+	if c.audit == nil {
+		return
+	}
+	if commonName != "" {
+		c.audit.CommonName = commonName
+	}
+	if dn != "" {
+		c.audit.DN = unescapeDN(dn)
+	}
+}
+
+// fail returns the error to Snowflake and records it as the outcome of the operation
+func (c *venafiConnector) fail(err error) string {
+	if c.audit != nil {
+		c.audit.Outcome, c.audit.ErrorCode = AUDIT_OUTCOME_FAILED, ""
+		if connectorErr, ok := err.(*ConnectorError); ok {
+			c.audit.ErrorCode = connectorErr.Code
+			if connectorErr.Code == ERR_NOT_AUTHORIZED || connectorErr.Code == ERR_AUTHORIZATION_POLICY_INVALID {
+				c.audit.Outcome = AUDIT_OUTCOME_DENIED
+			}
+		}
+	}
+	return createSnowflakeResponse(err.Error())
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useMemoryAuditTrail starts a new audit stream in an empty bucket
+func useMemoryAuditTrail(t *testing.T) memoryBucket {
+	storage := memoryBucket{}
+	auditStore = storage
+	auditChain.stream, auditChain.sequence, auditChain.hash = "", 0, ""
+	auditAnchorStore = func() bucketWriter { return storage }
+	t.Cleanup(func() {
+		auditStore = memoryBucket{}
+		auditAnchorStore = func() bucketWriter { return nil }
+	})
+	return storage
+}
+
+// auditLines returns the records of the bucket in the order of the chain
+func auditLines(t *testing.T, storage memoryBucket) []AuditRecord {
+	keys, _ := storage.list(AUDIT_RECORDS_PREFIX)
+	records := []AuditRecord{}
+	for _, key := range keys {
+		line := bytes.TrimSuffix(storage[key], []byte("\n"))
+		record := AuditRecord{}
+		assert.Nil(t, json.Unmarshal(line, &record))
+		// the hash covers the line without its Hash field
+		suffix := []byte(`,"Hash":"` + record.Hash + `"}`)
+		assert.True(t, bytes.HasSuffix(line, suffix))
+		sum := sha256.Sum256(append(bytes.TrimSuffix(line, suffix), '}'))
+		assert.Equal(t, hex.EncodeToString(sum[:]), record.Hash)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditTrail(t *testing.T) {
+	storage := useMemoryAuditTrail(t)
+	useMemoryLedger(t)
+	useAuthorizationPolicy(t, testAuthorizationPolicy)
+	c := &venafiConnector{
+		tppURL:         "https://tpp.example.com",
+		zone:           "Web",
+		requestContext: RequestContext{QueryID: "01a2-query", User: "ALICE", Role: "ANALYST", Account: "XY12345"},
+	}
+
+	c.ListRequests(context.Background(), "")
+	c.RevokeMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", false, nil)
+	c.ListRequests(context.Background(), "lost")
+
+	records := auditLines(t, storage)
+	assert.Len(t, records, 3)
+	assert.Equal(t, "LIST_REQUESTS", records[0].Operation)
+	assert.Equal(t, AUDIT_OUTCOME_SUCCESS, records[0].Outcome)
+	assert.Equal(t, "\\VED\\Policy\\Web", records[0].Zone)
+	assert.Equal(t, "ALICE", records[0].User)
+	assert.Equal(t, "01a2-query", records[0].QueryID)
+
+	assert.Equal(t, "REVOKE_MACHINE_ID", records[1].Operation)
+	assert.Equal(t, "\\VED\\Policy\\Web\\app", records[1].DN)
+	assert.Equal(t, AUDIT_OUTCOME_DENIED, records[1].Outcome)
+	assert.Equal(t, ERR_NOT_AUTHORIZED, records[1].ErrorCode)
+
+	assert.Equal(t, AUDIT_OUTCOME_FAILED, records[2].Outcome)
+	assert.Equal(t, ERR_INVALID_REQUEST_STATE, records[2].ErrorCode)
+
+	previousHash := ""
+	for i, record := range records {
+		assert.Equal(t, int64(i+1), record.Sequence)
+		assert.Equal(t, previousHash, record.PreviousHash)
+		assert.Equal(t, records[0].Stream, record.Stream)
+		previousHash = record.Hash
+	}
+	head := AuditHead{}
+	assert.Nil(t, json.Unmarshal(storage[AUDIT_HEADS_PREFIX+records[0].Stream+".json"], &head))
+	assert.Equal(t, int64(3), head.Sequence)
+	assert.Equal(t, previousHash, head.Hash)
+	// every record is anchored
+	for _, record := range records {
+		anchor := AuditHead{}
+		assert.Nil(t, json.Unmarshal(storage[fmt.Sprintf("%s%s/%010d.json", AUDIT_ANCHORS_PREFIX, record.Stream, record.Sequence)], &anchor))
+		assert.Equal(t, record.Hash, anchor.Hash)
+	}
+}
+
+func TestAuditRecordKey(t *testing.T) {
+	record := &AuditRecord{Stream: "20261019T130405Z-1a2b3c4d", Sequence: 42, Time: time.Date(2026, 10, 19, 13, 4, 5, 0, time.UTC)}
+	assert.Equal(t, "audit/records/dt=2026-10-19/hour=13/20261019T130405Z-1a2b3c4d-0000000042.ndjson", auditRecordKey(record))
+}
+
+func TestConnectorFailureResponseIsAudited(t *testing.T) {
+	storage := useMemoryAuditTrail(t)
+	configParams := ConfigParameters{TppURL: "https://evil.example.com", Zone: "Web", Context: RequestContext{QueryID: "01a2-query", User: "ALICE"}}
+
+	ConnectorFailureResponse(REQUEST_MID_TYPE, configParams, newConnectorError(ERR_TPP_URL_NOT_ALLOWED, "not allowed"))
+	ConnectorFailureResponse(REQUEST_MID_TYPE, configParams, fmt.Errorf("Failed to get access token"))
+
+	records := auditLines(t, storage)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "REQUEST_MACHINE_ID", records[0].Operation)
+	assert.Equal(t, "https://evil.example.com", records[0].TppURL)
+	assert.Equal(t, "ALICE", records[0].User)
+	assert.Equal(t, AUDIT_OUTCOME_FAILED, records[0].Outcome)
+	assert.Equal(t, ERR_TPP_URL_NOT_ALLOWED, records[0].ErrorCode)
+	assert.Equal(t, "", records[1].ErrorCode)
+}
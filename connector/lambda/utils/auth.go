@@ -50,78 +50,154 @@ func uploadRefreshedTokenToS3(credentials []credentialJSON, filename, bucket, zo
 	return nil
 }
 
-func shouldRequestNewToken(credArr []credentialJSON, tppUrl string) (credentialJSON, bool, error) {
-	invalidTppUrlError := fmt.Errorf("None of the TPP urls matching for the requested TPP url: %v", tppUrl)
+// Optional keys of a TPP entry in the credential file to route Snowflake calls
+const ALIASES_KEY = "Aliases"          // comma separated friendly names which can be used instead of the url
+const DEFAULT_ZONE_KEY = "DefaultZone" // zone used when the caller does not provide one
+const ZONE_ALIASES_KEY = "ZoneAliases" // JSON object of aliases and zones, e.g. {"web-prod":"\\VED\\Policy\\Web\\Prod"}
+
+// normalizeTPPUrl makes urls comparable regardless of scheme, case, trailing slashes and the /vedsdk suffix
+func normalizeTPPUrl(tppUrl string) string {
+	normalized := strings.ToLower(strings.TrimSpace(tppUrl))
+	if normalized == "" {
+		return ""
+	}
+	if !strings.Contains(normalized, "://") {
+		normalized = "https://" + normalized
+	}
+	normalized = strings.TrimRight(normalized, "/")
+	normalized = strings.TrimSuffix(normalized, "/vedsdk")
+	return strings.TrimRight(normalized, "/")
+}
+
+// findTPPCredential returns the entry of the allowed TPP servers matching the url or alias used by the caller
+func findTPPCredential(credArr []credentialJSON, tppUrl string) (credentialJSON, error) {
+	requested := strings.ToLower(strings.TrimSpace(tppUrl))
+	normalized := normalizeTPPUrl(tppUrl)
 	for _, singleTPPCred := range credArr {
-		if singleTPPCred["Url"] == tppUrl {
-			expirationTime, foundExp := singleTPPCred["AccessTokenExpires"]
-			if !foundExp {
-				log.Errorf("Failed to get token expiration time: %v", nil)
-				return singleTPPCred, true, fmt.Errorf("No expiration time")
-			}
-			_, foundToken := singleTPPCred["AccessToken"]
-			// layout := "2006-01-02T15:04:05.000Z"
-			t, err := time.Parse("2006-01-02T15:04:05Z07:00", expirationTime)
-			if err != nil {
-				log.Errorf("Failed to parse token expiration time: %v", err)
-				return singleTPPCred, true, err
+		if normalized != "" && normalizeTPPUrl(singleTPPCred["Url"]) == normalized {
+			return singleTPPCred, nil
+		}
+		for _, alias := range strings.Split(singleTPPCred[ALIASES_KEY], ",") {
+			alias = strings.ToLower(strings.TrimSpace(alias))
+			if alias != "" && alias == requested {
+				return singleTPPCred, nil
 			}
-			if !foundExp || !foundToken || !CheckIfAccessTokenIsValid(t) {
-				return singleTPPCred, true, nil
-			} else {
-				return singleTPPCred, false, nil
+		}
+	}
+	return credentialJSON{}, newConnectorError(ERR_TPP_URL_NOT_ALLOWED, "TPP url or alias '%v' is not in the list of allowed TPP servers", tppUrl)
+}
 
-			}
+// parseZoneAliases decodes the JSON object of zone aliases stored as a string in the credential file,
+// so zones can have any character, including commas and equal signs.
+func parseZoneAliases(zoneAliases string) map[string]string {
+	aliases := make(map[string]string)
+	if strings.TrimSpace(zoneAliases) == "" {
+		return aliases
+	}
+	var decoded map[string]string
+	if err := json.Unmarshal([]byte(zoneAliases), &decoded); err != nil {
+		log.Errorf("Failed to parse zone aliases of the credential file: %v", err)
+		return aliases
+	}
+	for alias, zone := range decoded {
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		zone = strings.TrimSpace(zone)
+		if alias != "" && zone != "" {
+			aliases[alias] = zone
 		}
 	}
-	log.Errorf("No matching TPP url when check token")
-	return credentialJSON{}, false, invalidTppUrlError
+	return aliases
+}
+
+// resolveZone returns the policy folder of the zone requested by the caller. An empty zone means the
+// default zone of the TPP entry, otherwise zone aliases of the entry are replaced with their policy folder.
+func resolveZone(credential credentialJSON, zone string) string {
+	zone = strings.TrimSpace(zone)
+	if zone == "" {
+		return credential[DEFAULT_ZONE_KEY]
+	}
+	if policyFolder, found := parseZoneAliases(credential[ZONE_ALIASES_KEY])[strings.ToLower(zone)]; found {
+		return policyFolder
+	}
+	return zone
+}
+
+func shouldRequestNewToken(credArr []credentialJSON, tppUrl string) (credentialJSON, bool, error) {
+	singleTPPCred, err := findTPPCredential(credArr, tppUrl)
+	if err != nil {
+		log.Errorf("No matching TPP url when check token")
+		return credentialJSON{}, false, err
+	}
+	expirationTime, foundExp := singleTPPCred["AccessTokenExpires"]
+	if !foundExp {
+		log.Errorf("Failed to get token expiration time: %v", nil)
+		return singleTPPCred, true, fmt.Errorf("No expiration time")
+	}
+	_, foundToken := singleTPPCred["AccessToken"]
+	// layout := "2006-01-02T15:04:05.000Z"
+	t, err := time.Parse("2006-01-02T15:04:05Z07:00", expirationTime)
+	if err != nil {
+		log.Errorf("Failed to parse token expiration time: %v", err)
+		return singleTPPCred, true, err
+	}
+	if !foundExp || !foundToken || !CheckIfAccessTokenIsValid(t) {
+		return singleTPPCred, true, nil
+	} else {
+		return singleTPPCred, false, nil
+
+	}
 }
 
 func GetAccessToken(tppUrl string) (string, error) {
+	credential, err := GetTPPCredential(tppUrl)
+	if err != nil {
+		return "", err
+	}
+	return credential["AccessToken"], nil
+}
+
+// GetTPPCredential returns the entry of the credential file for the TPP server with a valid access token
+func GetTPPCredential(tppUrl string) (credentialJSON, error) {
 	CREDENTIAL_FILE_NAME := os.Getenv("CREDENTIAL_FILE_NAME")
 	S3_BUCKET := os.Getenv("S3_BUCKET")
 	ZONE := os.Getenv("ZONE")
 	credentials, err := getCredentials(CREDENTIAL_FILE_NAME, S3_BUCKET, ZONE)
 	if err != nil {
 		log.Error("Failed to get credentials from S3")
-		return "", fmt.Errorf("Failed to get access token: %v", err.Error())
+		return nil, fmt.Errorf("Failed to get access token: %v", err.Error())
 	}
 
 	credentialArray, err := parseCredentialData(credentials)
 	if err != nil {
-		return "", fmt.Errorf("Failed to parse token %v", err.Error())
+		return nil, fmt.Errorf("Failed to parse token %v", err.Error())
 	}
-	var accessToken string
 	credsForSingleTpp, shouldRequest, err := shouldRequestNewToken(credentialArray, tppUrl)
 	if err != nil && strings.Contains(err.Error(), "TPP") {
 		log.Errorf("Could not find valid tpp url in credential file.")
-		return "", err
+		return nil, err
 	}
 	if err != nil {
 		log.Errorf("Failed to check token validation")
-		return "", err
+		return nil, err
 	}
 	if shouldRequest {
 		newCredentials := GetNewAccessToken(credsForSingleTpp)
 		if newCredentials != nil {
-			accessToken = (*newCredentials)["AccessToken"]
 			credsForSingleTpp = *newCredentials
 			err := uploadRefreshedTokenToS3(credentialArray, CREDENTIAL_FILE_NAME, S3_BUCKET, ZONE)
 			if err != nil {
 				log.Errorf("Failed to upload new creds to AWS. Next run will generate a new token.")
-				return accessToken, err
+				return credsForSingleTpp, err
 			}
-			return accessToken, nil
+			return credsForSingleTpp, nil
 		} else {
 			log.Errorf("Failed to get new credentials")
-			return "", fmt.Errorf("Failed to refresh and get new credentials from S3")
+			return nil, fmt.Errorf("Failed to refresh and get new credentials from S3")
 
 		}
 	} else {
-		accessToken, _ = credsForSingleTpp["AccessToken"]
 		log.Infof("Found token is valid, no need to return new token.")
-		return accessToken, nil
+		return credsForSingleTpp, nil
 	}
 }
 
@@ -131,11 +207,22 @@ func CheckIfAccessTokenIsValid(acces_token_expiration time.Time) bool {
 
 func GetNewAccessToken(single_credential_for_tpp map[string]string) *map[string]string {
 
-	c, err := tpp.NewConnector(single_credential_for_tpp["Url"], "", false, nil)
+	trustBundle, err := getTrustBundle(single_credential_for_tpp)
+	if err != nil {
+		log.Errorf("Failed to get trust bundle: %v", err.Error())
+		return nil
+	}
+	httpClient, err := newTPPHTTPClient(single_credential_for_tpp, trustBundle)
+	if err != nil {
+		log.Errorf("Failed to create http client: %v", err.Error())
+		return nil
+	}
+	c, err := tpp.NewConnector(single_credential_for_tpp["Url"], "", false, nil) // trust bundle is applied by the http client
 	if err != nil {
 		log.Errorf("Failed to create TPP Connector: %v", err.Error())
 		return nil
 	}
+	c.SetHTTPClient(httpClient)
 
 	auth := endpoint.Authentication{RefreshToken: single_credential_for_tpp["RefreshToken"]}
 
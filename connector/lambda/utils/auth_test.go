@@ -51,3 +51,42 @@ func Test_shouldRequestNewToken(t *testing.T) {
 	assert.False(t, shouldRequestNewtoken)
 	assert.True(t, strings.Contains(err.Error(), "TPP"))
 }
+
+func Test_findTPPCredential(t *testing.T) {
+	credentials := []credentialJSON{
+		map[string]string{
+			"Url":            "https://tpp.venafidemo.com/vedsdk/",
+			ALIASES_KEY:      "prod-tpp, tpp",
+			DEFAULT_ZONE_KEY: "\\VED\\Policy\\Certificates",
+		},
+	}
+
+	cred, err := findTPPCredential(credentials, "https://TPP.venafidemo.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://tpp.venafidemo.com/vedsdk/", cred["Url"])
+
+	cred, err = findTPPCredential(credentials, "tpp.venafidemo.com/vedsdk")
+	assert.Nil(t, err)
+	assert.Equal(t, "\\VED\\Policy\\Certificates", cred[DEFAULT_ZONE_KEY])
+
+	cred, err = findTPPCredential(credentials, "prod-tpp")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://tpp.venafidemo.com/vedsdk/", cred["Url"])
+
+	_, err = findTPPCredential(credentials, "https://unknown-tpp.com")
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), ERR_TPP_URL_NOT_ALLOWED))
+}
+
+func Test_resolveZone(t *testing.T) {
+	cred := credentialJSON{
+		"Url":            "https://tpp.venafidemo.com",
+		DEFAULT_ZONE_KEY: "\\VED\\Policy\\Certificates",
+		ZONE_ALIASES_KEY: `{"web-prod": "\\VED\\Policy\\Web\\Prod", " db ": "\\VED\\Policy\\Databases", "eu": "\\VED\\Policy\\EU,UK=Web"}`,
+	}
+	assert.Equal(t, "\\VED\\Policy\\Certificates", resolveZone(cred, ""))
+	assert.Equal(t, "\\VED\\Policy\\Web\\Prod", resolveZone(cred, "WEB-PROD"))
+	assert.Equal(t, "\\VED\\Policy\\Databases", resolveZone(cred, "db"))
+	assert.Equal(t, "\\VED\\Policy\\EU,UK=Web", resolveZone(cred, "eu"))
+	assert.Equal(t, "Other\\Zone", resolveZone(cred, "Other\\Zone"))
+}
@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/palette-software/go-log-targets"
+)
+
+// AUTHORIZATION_POLICY_FILE_NAME is the key of the authorization policy in the bucket, uploaded by the installer.
+// Without this file every Snowflake caller who can use the functions can call every operation.
+const AUTHORIZATION_POLICY_FILE_NAME = "authorization.json"
+const authorizationPolicyCacheTTL = 5 * time.Minute
+
+// AUTHORIZATION_WILDCARD matches every operation, or every caller in the Roles, Users and Accounts of a rule
+const AUTHORIZATION_WILDCARD = "*"
+
+// operationFunctionNames maps the operations to the Snowflake functions named in the authorization policy
+var operationFunctionNames = map[string]string{
+	REQUEST_MID_TYPE:        "REQUEST_MACHINE_ID",
+	GET_MID_TYPE:            "GET_MACHINE_ID",
+	LIST_MID_TYPE:           "LIST_MACHINE_IDS",
+	RENEW_MID_TYPE:          "RENEW_MACHINE_ID",
+	REVOKE_MID_TYPE:         "REVOKE_MACHINE_ID",
+	GET_STATUS_MID_TYPE:     "GET_MACHINE_ID_STATUS",
+	ZONE_POLICY_TYPE:        "GET_ZONE_POLICY",
+	VALIDATE_MID_TYPE:       "VALIDATE_MACHINE_ID_REQUEST",
+	SEARCH_MID_TYPE:         "SEARCH_MACHINE_IDS",
+	IMPORT_MID_TYPE:         "IMPORT_MACHINE_ID",
+	RETIRE_MID_TYPE:         "RETIRE_MACHINE_ID",
+	RESET_MID_TYPE:          "RESET_MACHINE_ID",
+	DELETE_MID_TYPE:         "DELETE_MACHINE_ID",
+	LIST_APPROVALS_MID_TYPE: "LIST_PENDING_APPROVALS",
+	APPROVE_MID_TYPE:        "APPROVE_MACHINE_ID",
+	REJECT_MID_TYPE:         "REJECT_MACHINE_ID",
+	GET_REQUEST_STATUS_TYPE: "GET_REQUEST_STATUS",
+	LIST_REQUESTS_TYPE:      "LIST_REQUESTS",
+}
+
+// AuthorizationRule allows the Snowflake callers it matches to call the operations on the TPP servers and zones.
+// A caller matches when every non-empty list of Roles, Users and Accounts contains it. Empty TppURLs and Zones
+// allow every server and zone.
+type AuthorizationRule struct {
+	Name       string   `json:"Name,omitempty"` // logged with the decision
+	Roles      []string `json:"Roles,omitempty"`
+	Users      []string `json:"Users,omitempty"`
+	Accounts   []string `json:"Accounts,omitempty"`
+	Operations []string `json:"Operations"` // Snowflake function names like REVOKE_MACHINE_ID, or *
+	TppURLs    []string `json:"TppURLs,omitempty"`
+	Zones      []string `json:"Zones,omitempty"` // policy folders, certificates stored under them are included
+}
+
+// AuthorizationPolicy is the content of the authorization policy file. A call is allowed when one of the
+// rules allows it, everything else is denied.
+type AuthorizationPolicy struct {
+	Version string              `json:"Version"`
+	Rules   []AuthorizationRule `json:"Rules"`
+}
+
+var authorizationCache struct {
+	sync.Mutex
+	policy   *AuthorizationPolicy
+	loadedAt time.Time
+}
+
+func isKnownFunctionName(name string) bool {
+	for _, functionName := range operationFunctionNames {
+		if strings.EqualFold(functionName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *AuthorizationRule) validate() error {
+	if len(r.Roles) == 0 && len(r.Users) == 0 && len(r.Accounts) == 0 {
+		return newConnectorError(ERR_AUTHORIZATION_POLICY_INVALID, "rule %s must list roles, users or accounts", r.Name)
+	}
+	if len(r.Operations) == 0 {
+		return newConnectorError(ERR_AUTHORIZATION_POLICY_INVALID, "rule %s must list operations", r.Name)
+	}
+	for _, operation := range r.Operations {
+		if operation != AUTHORIZATION_WILDCARD && !isKnownFunctionName(operation) {
+			return newConnectorError(ERR_AUTHORIZATION_POLICY_INVALID, "rule %s: unknown operation %s", r.Name, operation)
+		}
+	}
+	return nil
+}
+
+func parseAuthorizationPolicy(data []byte) (*AuthorizationPolicy, error) {
+	policy := &AuthorizationPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, newConnectorError(ERR_AUTHORIZATION_POLICY_INVALID, "failed to parse authorization policy: %v", err)
+	}
+	for i := range policy.Rules {
+		if err := policy.Rules[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// loadAuthorizationPolicy returns the authorization policy of the bucket, or nil if there is none.
+// The policy is cached for the lifetime of the Lambda container, and read again after the TTL.
+func loadAuthorizationPolicy() (*AuthorizationPolicy, error) {
+	authorizationCache.Lock()
+	defer authorizationCache.Unlock()
+	if !authorizationCache.loadedAt.IsZero() && time.Since(authorizationCache.loadedAt) < authorizationPolicyCacheTTL {
+		return authorizationCache.policy, nil
+	}
+	data, err := readBucketFile(AUTHORIZATION_POLICY_FILE_NAME)
+	if err == errBucketFileNotFound {
+		log.Debug("No authorization policy in the bucket")
+		authorizationCache.policy, authorizationCache.loadedAt = nil, time.Now()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newConnectorError(ERR_AUTHORIZATION_POLICY_INVALID, "failed to read authorization policy: %v", err)
+	}
+	policy, err := parseAuthorizationPolicy(data)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Loaded authorization policy version %s", policy.Version)
+	authorizationCache.policy, authorizationCache.loadedAt = policy, time.Now()
+	return policy, nil
+}
+
+// matchesIdentity matches Snowflake identifiers, which are case insensitive unless quoted
+func matchesIdentity(value string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == AUTHORIZATION_WILDCARD || (value != "" && strings.EqualFold(a, value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *AuthorizationRule) matchesCaller(caller RequestContext) bool {
+	return matchesIdentity(caller.Role, r.Roles) && matchesIdentity(caller.User, r.Users) && matchesIdentity(caller.Account, r.Accounts)
+}
+
+func (r *AuthorizationRule) allowsOperation(operation string) bool {
+	for _, o := range r.Operations {
+		if o == AUTHORIZATION_WILDCARD || strings.EqualFold(o, operationFunctionNames[operation]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *AuthorizationRule) allowsTppURL(tppURL string) bool {
+	if len(r.TppURLs) == 0 {
+		return true
+	}
+	for _, u := range r.TppURLs {
+		if normalizeTPPUrl(u) == normalizeTPPUrl(tppURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsTarget checks the zone or the certificate DN of the call. Calls without a zone, like LIST_REQUESTS,
+// are only allowed by rules without zones.
+func (r *AuthorizationRule) allowsTarget(target string) bool {
+	if len(r.Zones) == 0 {
+		return true
+	}
+	normalized := normalizeZone(strings.ReplaceAll(target, "\\\\", "\\"))
+	if normalized == "" {
+		return false
+	}
+	for _, zone := range r.Zones {
+		prefix := normalizeZone(getPolicyDN(zone))
+		if prefix != "" && (normalized == prefix || strings.HasPrefix(normalized, prefix+"\\")) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAuthorization returns the rule which allows the caller to call the operation, or a NOT_AUTHORIZED error
+func checkAuthorization(policy *AuthorizationPolicy, caller RequestContext, operation string, tppURL string, target string) (*AuthorizationRule, error) {
+	if caller.User == "" && caller.Role == "" && caller.Account == "" {
+		return nil, newConnectorError(ERR_NOT_AUTHORIZED, "the caller of %s is unknown, the function must be created with context headers", operationFunctionNames[operation])
+	}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.matchesCaller(caller) && rule.allowsOperation(operation) && rule.allowsTppURL(tppURL) && rule.allowsTarget(target) {
+			return rule, nil
+		}
+	}
+	if target == "" {
+		return nil, newConnectorError(ERR_NOT_AUTHORIZED, "%s is not allowed to call %s on %s", caller.Caller(), operationFunctionNames[operation], tppURL)
+	}
+	return nil, newConnectorError(ERR_NOT_AUTHORIZED, "%s is not allowed to call %s on %s for %s", caller.Caller(), operationFunctionNames[operation], tppURL, target)
+}
+
+// Operations which are only allowed by an authorization policy. Approvals are made with the service account
+// which submitted the request, without a policy everyone who can request a certificate could approve it.
+var policyRequiredOperations = map[string]bool{
+	APPROVE_MID_TYPE: true,
+	REJECT_MID_TYPE:  true,
+	RETIRE_MID_TYPE:  true,
+	DELETE_MID_TYPE:  true,
+}
+
+// authorize checks the call against the authorization policy. target is the zone or the DN of the certificate
+// of the operation. Every denial is logged with the caller.
+func (c *venafiConnector) authorize(operation string, target string) error {
+	policy, err := loadAuthorizationPolicy()
+	if err != nil {
+		log.Errorf("Denied %s to %s: %v", operationFunctionNames[operation], c.requestContext.Caller(), err)
+		return err
+	}
+	if policy == nil {
+		if policyRequiredOperations[operation] {
+			err := newConnectorError(ERR_NOT_AUTHORIZED, "%s can only be called when an authorization policy allows it", operationFunctionNames[operation])
+			log.Warningf("Authorization denied in query %s: %v", c.requestContext.QueryID, err)
+			return err
+		}
+		return nil
+	}
+	rule, err := checkAuthorization(policy, c.requestContext, operation, c.tppURL, target)
+	if err != nil {
+		log.Warningf("Authorization denied in query %s: %v", c.requestContext.QueryID, err)
+		return err
+	}
+	log.Infof("%s allowed to %s by rule %s", operationFunctionNames[operation], c.requestContext.Caller(), rule.Name)
+	return nil
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAuthorizationPolicy = `{
+	"Version": "2",
+	"Rules": [
+		{"Name": "analysts", "Roles": ["ANALYST"], "Operations": ["LIST_MACHINE_IDS", "SEARCH_MACHINE_IDS", "GET_MACHINE_ID", "LIST_REQUESTS"]},
+		{"Name": "platform", "Roles": ["PLATFORM_ENGINEER"], "Operations": ["REQUEST_MACHINE_ID", "RENEW_MACHINE_ID", "GET_MACHINE_ID"],
+		 "TppURLs": ["https://tpp.example.com/vedsdk"], "Zones": ["Web"]},
+		{"Name": "security", "Roles": ["SECURITY_ADMIN"], "Accounts": ["XY12345"], "Operations": ["*"]}
+	]
+}`
+
+func useAuthorizationPolicy(t *testing.T, data string) {
+	policy, err := parseAuthorizationPolicy([]byte(data))
+	assert.Nil(t, err)
+	authorizationCache.policy = policy
+	t.Cleanup(func() { authorizationCache.policy = nil })
+}
+
+func TestParseAuthorizationPolicy(t *testing.T) {
+	policy, err := parseAuthorizationPolicy([]byte(testAuthorizationPolicy))
+	assert.Nil(t, err)
+	assert.Equal(t, "2", policy.Version)
+	assert.Len(t, policy.Rules, 3)
+
+	_, err = parseAuthorizationPolicy([]byte(`{"Rules": [{"Name": "all", "Operations": ["*"]}]}`))
+	assert.Equal(t, ERR_AUTHORIZATION_POLICY_INVALID, err.(*ConnectorError).Code)
+	_, err = parseAuthorizationPolicy([]byte(`{"Rules": [{"Roles": ["ANALYST"], "Operations": ["REVOKE_MID"]}]}`))
+	assert.Equal(t, ERR_AUTHORIZATION_POLICY_INVALID, err.(*ConnectorError).Code)
+}
+
+func TestCheckAuthorization(t *testing.T) {
+	policy, err := parseAuthorizationPolicy([]byte(testAuthorizationPolicy))
+	assert.Nil(t, err)
+	tppURL := "https://tpp.example.com"
+	analyst := RequestContext{User: "ALICE", Role: "analyst", Account: "XY12345"}
+	engineer := RequestContext{User: "BOB", Role: "PLATFORM_ENGINEER", Account: "XY12345"}
+	security := RequestContext{User: "EVE", Role: "SECURITY_ADMIN", Account: "XY12345"}
+
+	rule, err := checkAuthorization(policy, analyst, LIST_MID_TYPE, tppURL, "\\VED\\Policy\\DB")
+	assert.Nil(t, err)
+	assert.Equal(t, "analysts", rule.Name)
+	_, err = checkAuthorization(policy, analyst, REVOKE_MID_TYPE, tppURL, "\\\\VED\\\\Policy\\\\Web\\\\app")
+	assert.Equal(t, ERR_NOT_AUTHORIZED, err.(*ConnectorError).Code)
+	assert.Contains(t, err.Error(), "ALICE (role analyst, account XY12345) is not allowed to call REVOKE_MACHINE_ID")
+
+	// requests are limited to the zones and TPP servers of the rule
+	_, err = checkAuthorization(policy, engineer, REQUEST_MID_TYPE, tppURL+"/", "\\VED\\Policy\\Web")
+	assert.Nil(t, err)
+	_, err = checkAuthorization(policy, engineer, RENEW_MID_TYPE, tppURL, "\\\\VED\\\\Policy\\\\Web\\\\app")
+	assert.Nil(t, err)
+	_, err = checkAuthorization(policy, engineer, REQUEST_MID_TYPE, tppURL, "\\VED\\Policy\\WebShop")
+	assert.NotNil(t, err)
+	_, err = checkAuthorization(policy, engineer, REQUEST_MID_TYPE, "https://other.example.com", "\\VED\\Policy\\Web")
+	assert.NotNil(t, err)
+	_, err = checkAuthorization(policy, engineer, REVOKE_MID_TYPE, tppURL, "\\VED\\Policy\\Web\\app")
+	assert.NotNil(t, err)
+
+	_, err = checkAuthorization(policy, security, REVOKE_MID_TYPE, tppURL, "\\VED\\Policy\\Web\\app")
+	assert.Nil(t, err)
+	security.Account = "AB98765"
+	_, err = checkAuthorization(policy, security, REVOKE_MID_TYPE, tppURL, "\\VED\\Policy\\Web\\app")
+	assert.NotNil(t, err)
+
+	// functions created without context headers do not identify the caller
+	_, err = checkAuthorization(policy, RequestContext{QueryID: "q"}, LIST_MID_TYPE, tppURL, "\\VED\\Policy\\Web")
+	assert.Contains(t, err.Error(), "context headers")
+}
+
+func TestAuthorizeDeniesRevocation(t *testing.T) {
+	useAuthorizationPolicy(t, testAuthorizationPolicy)
+	// the connector has no TPP client, a call which is not denied would panic
+	c := &venafiConnector{tppURL: "https://tpp.example.com", requestContext: RequestContext{User: "ALICE", Role: "ANALYST"}}
+
+	response, err := c.RevokeMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", false, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_NOT_AUTHORIZED)
+	response, err = c.DeleteMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", true)
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_NOT_AUTHORIZED)
+
+	useMemoryLedger(t)
+	response, err = c.ListRequests(context.Background(), "")
+	assert.Nil(t, err)
+	assert.NotContains(t, response, ERR_NOT_AUTHORIZED)
+}
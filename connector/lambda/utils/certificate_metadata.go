@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+var oidUPN = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "DigitalSignature"},
+	{x509.KeyUsageContentCommitment, "ContentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "KeyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "DataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "KeyAgreement"},
+	{x509.KeyUsageCertSign, "CertSign"},
+	{x509.KeyUsageCRLSign, "CRLSign"},
+	{x509.KeyUsageEncipherOnly, "EncipherOnly"},
+	{x509.KeyUsageDecipherOnly, "DecipherOnly"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "Any",
+	x509.ExtKeyUsageServerAuth:      "ServerAuth",
+	x509.ExtKeyUsageClientAuth:      "ClientAuth",
+	x509.ExtKeyUsageCodeSigning:     "CodeSigning",
+	x509.ExtKeyUsageEmailProtection: "EmailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:  "IPSECEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:     "IPSECTunnel",
+	x509.ExtKeyUsageIPSECUser:       "IPSECUser",
+	x509.ExtKeyUsageTimeStamping:    "TimeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+type CertificateSANs struct {
+	DNS   []string `json:"DNS"`
+	Email []string `json:"Email"`
+	IP    []string `json:"IP"`
+	URI   []string `json:"URI"`
+	UPN   []string `json:"UPN"`
+}
+
+// ChainCertificateMetadata describes a CA certificate of the chain
+type ChainCertificateMetadata struct {
+	Subject           string    `json:"Subject"`
+	Issuer            string    `json:"Issuer"`
+	Serial            string    `json:"Serial"`
+	NotBefore         time.Time `json:"NotBefore"`
+	NotAfter          time.Time `json:"NotAfter"`
+	SHA1Fingerprint   string    `json:"SHA1Fingerprint"`
+	SHA256Fingerprint string    `json:"SHA256Fingerprint"`
+}
+
+// CertificateMetadata is the parsed content of the leaf certificate and its chain
+type CertificateMetadata struct {
+	Subject            string                     `json:"Subject"`
+	Issuer             string                     `json:"Issuer"`
+	Serial             string                     `json:"Serial"`
+	NotBefore          time.Time                  `json:"NotBefore"`
+	NotAfter           time.Time                  `json:"NotAfter"`
+	DaysRemaining      int                        `json:"DaysRemaining"`
+	SANs               CertificateSANs            `json:"SANs"`
+	KeyAlgorithm       string                     `json:"KeyAlgorithm"`
+	KeySize            int                        `json:"KeySize"`
+	SignatureAlgorithm string                     `json:"SignatureAlgorithm"`
+	SHA1Fingerprint    string                     `json:"SHA1Fingerprint"`
+	SHA256Fingerprint  string                     `json:"SHA256Fingerprint"`
+	KeyUsages          []string                   `json:"KeyUsages"`
+	ExtendedKeyUsages  []string                   `json:"ExtendedKeyUsages"`
+	ChainDepth         int                        `json:"ChainDepth"`
+	Chain              []ChainCertificateMetadata `json:"Chain"`
+}
+
+// GetMachineIDResponse keeps the PEM fields of vcert's PEMCollection and adds the parsed metadata
+type GetMachineIDResponse struct {
+	*certificate.PEMCollection
+	Metadata *CertificateMetadata  `json:"Metadata,omitempty"`
+	Output   *FormattedCertificate `json:"Output,omitempty"` // the certificate in the format of the format option
+}
+
+func parsePEMCertificate(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// fingerprint returns the hash of the DER certificate in the format of TPP thumbprints
+func fingerprint(sum []byte) string {
+	return strings.ToUpper(fmt.Sprintf("%x", sum))
+}
+
+func publicKeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return cert.PublicKeyAlgorithm.String(), 0
+	}
+}
+
+// parseUPNs reads the UPN other names of the SAN extension, which crypto/x509 does not parse
+func parseUPNs(cert *x509.Certificate) []string {
+	upns := []string{}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return upns
+		}
+		for _, name := range names {
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 { // otherName
+				continue
+			}
+			var otherName struct {
+				TypeID asn1.ObjectIdentifier
+				Value  string `asn1:"explicit,tag:0,utf8"`
+			}
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &otherName, "tag:0"); err == nil && otherName.TypeID.Equal(oidUPN) {
+				upns = append(upns, otherName.Value)
+			}
+		}
+	}
+	return upns
+}
+
+func certificateSANs(cert *x509.Certificate) CertificateSANs {
+	sans := CertificateSANs{
+		DNS:   append([]string{}, cert.DNSNames...),
+		Email: append([]string{}, cert.EmailAddresses...),
+		IP:    []string{},
+		URI:   []string{},
+		UPN:   parseUPNs(cert),
+	}
+	for _, ip := range cert.IPAddresses {
+		sans.IP = append(sans.IP, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans.URI = append(sans.URI, uri.String())
+	}
+	return sans
+}
+
+func keyUsages(cert *x509.Certificate) ([]string, []string) {
+	usages := []string{}
+	for _, keyUsage := range keyUsageNames {
+		if cert.KeyUsage&keyUsage.usage != 0 {
+			usages = append(usages, keyUsage.name)
+		}
+	}
+	extUsages := []string{}
+	for _, extUsage := range cert.ExtKeyUsage {
+		if name, ok := extKeyUsageNames[extUsage]; ok {
+			extUsages = append(extUsages, name)
+		} else {
+			extUsages = append(extUsages, fmt.Sprintf("%d", extUsage))
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		extUsages = append(extUsages, oid.String())
+	}
+	return usages, extUsages
+}
+
+func chainCertificateMetadata(cert *x509.Certificate) ChainCertificateMetadata {
+	sha1Sum := sha1.Sum(cert.Raw)
+	sha256Sum := sha256.Sum256(cert.Raw)
+	return ChainCertificateMetadata{
+		Subject:           cert.Subject.String(),
+		Issuer:            cert.Issuer.String(),
+		Serial:            fmt.Sprintf("%X", cert.SerialNumber),
+		NotBefore:         cert.NotBefore,
+		NotAfter:          cert.NotAfter,
+		SHA1Fingerprint:   fingerprint(sha1Sum[:]),
+		SHA256Fingerprint: fingerprint(sha256Sum[:]),
+	}
+}
+
+// parseCertificateMetadata parses the leaf certificate and the chain of a PEM collection
+func parseCertificateMetadata(pcc *certificate.PEMCollection, now time.Time) (*CertificateMetadata, error) {
+	leaf, err := parsePEMCertificate(pcc.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	summary := chainCertificateMetadata(leaf)
+	keyAlgorithm, keySize := publicKeyInfo(leaf)
+	usages, extUsages := keyUsages(leaf)
+	metadata := &CertificateMetadata{
+		Subject:            summary.Subject,
+		Issuer:             summary.Issuer,
+		Serial:             summary.Serial,
+		NotBefore:          leaf.NotBefore,
+		NotAfter:           leaf.NotAfter,
+		DaysRemaining:      int(leaf.NotAfter.Sub(now).Hours() / 24),
+		SANs:               certificateSANs(leaf),
+		KeyAlgorithm:       keyAlgorithm,
+		KeySize:            keySize,
+		SignatureAlgorithm: leaf.SignatureAlgorithm.String(),
+		SHA1Fingerprint:    summary.SHA1Fingerprint,
+		SHA256Fingerprint:  summary.SHA256Fingerprint,
+		KeyUsages:          usages,
+		ExtendedKeyUsages:  extUsages,
+		ChainDepth:         len(pcc.Chain),
+		Chain:              []ChainCertificateMetadata{},
+	}
+	for _, chainPEM := range pcc.Chain {
+		chainCert, err := parsePEMCertificate(chainPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse chain certificate: %v", err)
+		}
+		metadata.Chain = append(metadata.Chain, chainCertificateMetadata(chainCert))
+	}
+	return metadata, nil
+}
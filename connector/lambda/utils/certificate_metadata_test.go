@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestSANExtension(t *testing.T) pkix.Extension {
+	upn, err := asn1.MarshalWithParams(struct {
+		TypeID asn1.ObjectIdentifier
+		Value  string `asn1:"explicit,tag:0,utf8"`
+	}{oidUPN, "svc@example.com"}, "tag:0")
+	assert.Nil(t, err)
+	value, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("app.example.com")},
+		{FullBytes: upn},
+	})
+	assert.Nil(t, err)
+	return pkix.Extension{Id: oidSubjectAltName, Value: value}
+}
+
+func createTestPEMCollection(t *testing.T, now time.Time) *certificate.PEMCollection {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Issuing CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.Nil(t, err)
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	leafTemplate := &x509.Certificate{
+		SerialNumber:    big.NewInt(0x1A2B),
+		Subject:         pkix.Name{CommonName: "app.example.com"},
+		NotBefore:       now.Add(-time.Hour),
+		NotAfter:        now.AddDate(0, 0, 90),
+		KeyUsage:        x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: []pkix.Extension{createTestSANExtension(t)},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	assert.Nil(t, err)
+	return &certificate.PEMCollection{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})),
+		Chain:       []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}))},
+	}
+}
+
+func TestParseCertificateMetadata(t *testing.T) {
+	now := time.Now().Truncate(time.Second).Add(500 * time.Millisecond) // certificates store whole seconds
+	metadata, err := parseCertificateMetadata(createTestPEMCollection(t, now), now)
+	assert.Nil(t, err)
+	assert.Equal(t, "CN=app.example.com", metadata.Subject)
+	assert.Equal(t, "CN=Test Issuing CA", metadata.Issuer)
+	assert.Equal(t, "1A2B", metadata.Serial)
+	assert.Equal(t, 89, metadata.DaysRemaining)
+	assert.Equal(t, []string{"app.example.com"}, metadata.SANs.DNS)
+	assert.Equal(t, []string{"svc@example.com"}, metadata.SANs.UPN)
+	assert.Equal(t, "RSA", metadata.KeyAlgorithm)
+	assert.Equal(t, 2048, metadata.KeySize)
+	assert.Equal(t, []string{"DigitalSignature", "KeyEncipherment"}, metadata.KeyUsages)
+	assert.Equal(t, []string{"ServerAuth"}, metadata.ExtendedKeyUsages)
+	assert.Len(t, metadata.SHA256Fingerprint, 64)
+	assert.Equal(t, 1, metadata.ChainDepth)
+	assert.Equal(t, "CN=Test Issuing CA", metadata.Chain[0].Subject)
+
+	_, err = parseCertificateMetadata(&certificate.PEMCollection{Certificate: "not a certificate"}, now)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	log "github.com/palette-software/go-log-targets"
+)
+
+// Values of the chain_option key of the certificate options
+const CHAIN_OPTION_ROOT_FIRST = "root-first"
+const CHAIN_OPTION_ROOT_LAST = "root-last"
+const CHAIN_OPTION_IGNORE = "ignore"
+
+// Values of the format key of the certificate options
+const CERTIFICATE_FORMAT_PEM = "pem"
+const CERTIFICATE_FORMAT_PEM_BUNDLE = "pem-bundle"
+const CERTIFICATE_FORMAT_DER = "der"
+const CERTIFICATE_FORMAT_PKCS12 = "pkcs12"
+const CERTIFICATE_FORMAT_JKS = "jks"
+
+const MIN_KEYSTORE_PASSPHRASE_LENGTH = 6 // keytool refuses shorter keystore passwords
+
+// CertificateOptions are the keys of the options object of GET_MACHINE_ID and REQUEST_MACHINE_ID
+type CertificateOptions struct {
+	ChainOption certificate.ChainOption // the zero value is vcert's default order
+	ExcludeRoot bool                    // include_root false removes the self-signed root from the chain
+	Format      string
+	Passphrase  string // password of the pkcs12 and jks keystores
+	Alias       string // friendly name of the certificate in the pkcs12 and jks keystores
+
+	IdempotencyKey string // a repeated REQUEST_MACHINE_ID with the same key returns the first result
+}
+
+func isKeystoreFormat(format string) bool {
+	return format == CERTIFICATE_FORMAT_PKCS12 || format == CERTIFICATE_FORMAT_JKS
+}
+
+// parseCertificateOptions reads chain_option, include_root, format, passphrase, alias and idempotency_key from the options object.
+// Without options the chain is returned in PEM the way vcert retrieves it from TPP: in vcert's default order,
+// with the root when TPP returns it.
+func parseCertificateOptions(options map[string]interface{}) (CertificateOptions, error) {
+	result := CertificateOptions{Format: CERTIFICATE_FORMAT_PEM}
+	for key, value := range options {
+		if value == nil {
+			continue
+		}
+		switch key {
+		case "chain_option":
+			switch strings.ToLower(criteriaValueToStr(value)) {
+			case CHAIN_OPTION_ROOT_FIRST:
+				result.ChainOption = certificate.ChainOptionRootFirst
+			case CHAIN_OPTION_ROOT_LAST:
+				result.ChainOption = certificate.ChainOptionRootLast
+			case CHAIN_OPTION_IGNORE:
+				result.ChainOption = certificate.ChainOptionIgnore
+			default:
+				return result, newConnectorError(ERR_INVALID_CERTIFICATE_OPTIONS, "chain_option must be %s, %s or %s", CHAIN_OPTION_ROOT_FIRST, CHAIN_OPTION_ROOT_LAST, CHAIN_OPTION_IGNORE)
+			}
+		case "include_root":
+			includeRoot, err := optionToBool(options, key)
+			if err != nil {
+				return result, newConnectorError(ERR_INVALID_CERTIFICATE_OPTIONS, "include_root must be true or false")
+			}
+			result.ExcludeRoot = !includeRoot
+		case "format":
+			switch format := strings.ToLower(criteriaValueToStr(value)); format {
+			case CERTIFICATE_FORMAT_PEM, CERTIFICATE_FORMAT_PEM_BUNDLE, CERTIFICATE_FORMAT_DER, CERTIFICATE_FORMAT_PKCS12, CERTIFICATE_FORMAT_JKS:
+				result.Format = format
+			default:
+				return result, newConnectorError(ERR_INVALID_CERTIFICATE_OPTIONS, "format must be %s, %s, %s, %s or %s", CERTIFICATE_FORMAT_PEM, CERTIFICATE_FORMAT_PEM_BUNDLE,
+					CERTIFICATE_FORMAT_DER, CERTIFICATE_FORMAT_PKCS12, CERTIFICATE_FORMAT_JKS)
+			}
+		case "passphrase":
+			result.Passphrase = fmt.Sprintf("%v", value)
+		case "alias":
+			result.Alias = criteriaValueToStr(value)
+		case "idempotency_key":
+			result.IdempotencyKey = criteriaValueToStr(value)
+			if len(result.IdempotencyKey) > MAX_IDEMPOTENCY_KEY_LENGTH {
+				return result, newConnectorError(ERR_INVALID_CERTIFICATE_OPTIONS, "idempotency_key can not be longer than %d characters", MAX_IDEMPOTENCY_KEY_LENGTH)
+			}
+		default:
+			return result, newConnectorError(ERR_INVALID_CERTIFICATE_OPTIONS, "unknown certificate option: %s", key)
+		}
+	}
+	if !isKeystoreFormat(result.Format) {
+		if result.Passphrase != "" || result.Alias != "" {
+			return result, newConnectorError(ERR_INVALID_CERTIFICATE_OPTIONS, "passphrase and alias are only used by the %s and %s formats", CERTIFICATE_FORMAT_PKCS12, CERTIFICATE_FORMAT_JKS)
+		}
+	} else if len([]rune(result.Passphrase)) < MIN_KEYSTORE_PASSPHRASE_LENGTH {
+		return result, newConnectorError(ERR_INVALID_CERTIFICATE_OPTIONS, "the %s format needs a passphrase of at least %d characters", result.Format, MIN_KEYSTORE_PASSPHRASE_LENGTH)
+	} else if result.Format == CERTIFICATE_FORMAT_JKS && !(isKeystoreString(result.Passphrase) && isKeystoreString(result.Alias)) {
+		return result, newConnectorError(ERR_INVALID_CERTIFICATE_OPTIONS, "the passphrase and alias of the %s format can only have printable ASCII characters", CERTIFICATE_FORMAT_JKS)
+	}
+	return result, nil
+}
+
+// isRootCertificate reports whether a PEM certificate of the chain is self-signed
+func isRootCertificate(pemCertificate string) bool {
+	cert, err := parsePEMCertificate(pemCertificate)
+	if err != nil {
+		log.Errorf("Failed to parse chain certificate: %v", err)
+		return false
+	}
+	if !bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+		return false
+	}
+	return cert.CheckSignatureFrom(cert) == nil
+}
+
+// applyCertificateOptions removes the root from the chain retrieved by vcert when it is not requested
+func applyCertificateOptions(pcc *certificate.PEMCollection, options CertificateOptions) {
+	if !options.ExcludeRoot {
+		return
+	}
+	chain := []string{}
+	for _, chainCertificate := range pcc.Chain {
+		if !isRootCertificate(chainCertificate) {
+			chain = append(chain, chainCertificate)
+		}
+	}
+	pcc.Chain = chain
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCertificateOptions(t *testing.T) {
+	options, err := parseCertificateOptions(nil)
+	assert.Nil(t, err)
+	// vcert's default order and the chain TPP returns
+	assert.Equal(t, certificate.Request{}.ChainOption, options.ChainOption)
+	assert.False(t, options.ExcludeRoot)
+
+	options, err = parseCertificateOptions(map[string]interface{}{"idempotency_key": "order-42"})
+	assert.Nil(t, err)
+	assert.Equal(t, "order-42", options.IdempotencyKey)
+
+	options, err = parseCertificateOptions(map[string]interface{}{"chain_option": "Root-First", "include_root": false})
+	assert.Nil(t, err)
+	assert.Equal(t, certificate.ChainOptionRootFirst, options.ChainOption)
+	assert.True(t, options.ExcludeRoot)
+
+	_, err = parseCertificateOptions(map[string]interface{}{"chain_option": "leaf-first"})
+	assert.Equal(t, ERR_INVALID_CERTIFICATE_OPTIONS, err.(*ConnectorError).Code)
+	_, err = parseCertificateOptions(map[string]interface{}{"chain": "ignore"})
+	assert.Equal(t, ERR_INVALID_CERTIFICATE_OPTIONS, err.(*ConnectorError).Code)
+}
+
+func TestApplyCertificateOptionsRemovesRoot(t *testing.T) {
+	root := createTestCAPEM(t)
+	pcc := &certificate.PEMCollection{Chain: []string{root}}
+	applyCertificateOptions(pcc, CertificateOptions{})
+	assert.Len(t, pcc.Chain, 1)
+
+	applyCertificateOptions(pcc, CertificateOptions{ExcludeRoot: true})
+	assert.Len(t, pcc.Chain, 0)
+}
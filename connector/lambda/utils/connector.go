@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -11,26 +12,46 @@ import (
 	"github.com/Venafi/vcert/v4"
 	"github.com/Venafi/vcert/v4/pkg/certificate"
 	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/aws/aws-lambda-go/events"
 	log "github.com/palette-software/go-log-targets"
 )
 
 type venafiConnector struct {
 	client endpoint.Connector
+	api    *tppAPIClient // WebSDK calls which are not available in vcert
+	zone   string
+	tppURL string // canonical url of the TPP server, recorded in the request ledger
+
+	requestContext RequestContext
+	audit          *AuditRecord // record of the running operation
 }
 type RequestMachineIDResponse struct {
-	Certificate string `json:"Certificate"`
-	PrivateKey  string `json:"PrivateKey"`
-	Passphrase  string `json:"Passphrase"`
-	RequestID   string `json:"RequestID"`
+	Certificate string                `json:"Certificate"`
+	PrivateKey  string                `json:"PrivateKey"`
+	Passphrase  string                `json:"Passphrase"`
+	RequestID   string                `json:"RequestID"`
+	Output      *FormattedCertificate `json:"Output,omitempty"` // the certificate in the format of the format option
 }
 
 type VenafiConnector interface {
-	RequestMachineID(ctx context.Context, commonName, upn, dns string) (string, error)
-	GetMachineID(ctx context.Context, requestID string) (string, error)
-	ListMachineIDs(ctx context.Context) (string, error)
-	RevokeMachineIDs(ctx context.Context, requestID string, disable bool) (string, error)
-	RenewMachineIDs(ctx context.Context, requestID string) (string, error)
+	RequestMachineID(ctx context.Context, commonName string, upn []string, dns []string, options map[string]interface{}) (string, error)
+	GetMachineID(ctx context.Context, requestID string, options map[string]interface{}) (string, error)
+	ListMachineIDs(ctx context.Context, options map[string]interface{}) (string, error)
+	RevokeMachineIDs(ctx context.Context, requestID string, disable bool, options map[string]interface{}) (string, error)
+	RenewMachineIDs(ctx context.Context, requestID string, options map[string]interface{}) (string, error)
 	GetMachineIDStatus(ctx context.Context, commonName string) (string, error)
+	GetZonePolicy(ctx context.Context) (string, error)
+	ValidateMachineIDRequest(ctx context.Context, commonName string, upn []string, dns []string) (string, error)
+	SearchMachineIDs(ctx context.Context, criteria map[string]interface{}) (string, error)
+	ImportMachineID(ctx context.Context, objectName string, certificateData string, privateKeyData string, passphrase string) (string, error)
+	RetireMachineID(ctx context.Context, requestID string, confirm bool) (string, error)
+	ResetMachineID(ctx context.Context, requestID string, restart bool) (string, error)
+	DeleteMachineID(ctx context.Context, requestID string, confirm bool) (string, error)
+	ListPendingApprovals(ctx context.Context) (string, error)
+	ApproveMachineID(ctx context.Context, requestID string, explanation string) (string, error)
+	RejectMachineID(ctx context.Context, requestID string, explanation string) (string, error)
+	GetRequestStatus(ctx context.Context, requestID string) (string, error)
+	ListRequests(ctx context.Context, state string) (string, error)
 }
 
 func createSnowflakeResponse(data string) string {
@@ -43,28 +64,62 @@ func createSnowflakeResponseWithEscape(data []byte) string {
 	return c
 }
 
+// ConnectorFailureResponse returns the error of NewVenafiConnector to Snowflake and records it in the audit trail.
+// Connector errors, like a TPP url which is not allowed, are the result of the row, Snowflake does not retry them.
+// Other errors, like an unreadable credential file, fail with a 500 status, so Snowflake retries the batch.
+func ConnectorFailureResponse(operation string, configParams ConfigParameters, err error) (events.APIGatewayProxyResponse, error) {
+	c := &venafiConnector{zone: configParams.Zone, tppURL: configParams.TppURL, requestContext: configParams.Context}
+	defer c.audited(operation, "")()
+	body := c.fail(err)
+	var connectorErr *ConnectorError
+	if errors.As(err, &connectorErr) {
+		return events.APIGatewayProxyResponse{Body: body, StatusCode: 200}, nil
+	}
+	return events.APIGatewayProxyResponse{Body: err.Error(), StatusCode: 500}, err
+}
+
 func NewVenafiConnector(configParams ConfigParameters) (*venafiConnector, error) {
+	setLogRequestContext(configParams.Context, configParams.TppURL)
+	log.Infof("Called by %s in query %s", configParams.Context.Caller(), configParams.Context.QueryID)
 
-	accessToken, err := GetAccessToken(configParams.TppURL)
+	credential, err := GetTPPCredential(configParams.TppURL)
 	if err != nil {
 		log.Errorf("Failed to get accesss token: %s", err)
 		return nil, err
 	}
+	trustBundle, err := getTrustBundle(credential)
+	if err != nil {
+		log.Errorf("Failed to get trust bundle: %s", err)
+		return nil, err
+	}
+	httpClient, err := newTPPHTTPClient(credential, trustBundle)
+	if err != nil {
+		log.Errorf("Failed to create http client for TPP: %s", err)
+		return nil, err
+	}
+	zone := resolveZone(credential, configParams.Zone)
 	config := &vcert.Config{
-		ConnectorType: endpoint.ConnectorTypeTPP,
-		BaseUrl:       configParams.TppURL,
-		Zone:          configParams.Zone,
+		ConnectorType:   endpoint.ConnectorTypeTPP,
+		BaseUrl:         credential["Url"], // canonical url of the allowed TPP server
+		Zone:            zone,
+		ConnectionTrust: trustBundle,
+		Client:          httpClient,
 		Credentials: &endpoint.Authentication{
-			AccessToken: accessToken},
+			AccessToken: credential["AccessToken"]},
 	}
 
 	client, err := vcert.NewClient(config)
 	if err != nil {
-		log.Errorf("Failed to create venafi connector")
-		return &venafiConnector{}, nil
+		log.Errorf("Failed to create venafi connector: %s", err)
+		return nil, err
 	}
 	return &venafiConnector{
 		client: client,
+		api:    newTPPAPIClient(credential["Url"], credential["AccessToken"], httpClient),
+		zone:   zone,
+		tppURL: credential["Url"],
+
+		requestContext: configParams.Context,
 	}, nil
 }
 
@@ -83,100 +138,209 @@ func NewVenafiConnector(configParams ConfigParameters) (*venafiConnector, error)
 // 	return str
 // }
 
-func (c *venafiConnector) RequestMachineID(ctx context.Context, cn string, upn []string, dns []string) (string, error) {
-	enrollReq := &certificate.Request{
-		Subject: pkix.Name{
-			CommonName: cn,
-		},
-		UPNs:        upn,
-		DNSNames:    dns,
-		CsrOrigin:   certificate.LocalGeneratedCSR,
-		KeyType:     certificate.KeyTypeRSA,
-		KeyLength:   2048,
-		KeyPassword: "",
+func (c *venafiConnector) RequestMachineID(ctx context.Context, cn string, upn []string, dns []string, options map[string]interface{}) (string, error) {
+	defer c.audited(REQUEST_MID_TYPE, "")()
+	c.auditCertificate(cn, "")
+	if err := c.authorize(REQUEST_MID_TYPE, getPolicyDN(c.zone)); err != nil {
+		return c.fail(err), nil
 	}
-	err := c.client.GenerateRequest(nil, enrollReq)
+	certificateOptions, err := parseCertificateOptions(options)
+	if err != nil {
+		log.Errorf("Invalid certificate options: %v", err)
+		return c.fail(err), nil
+	}
+	parameters := []interface{}{cn, upn, dns, options}
+	return c.idempotent(ctx, REQUEST_MID_TYPE, certificateOptions.IdempotencyKey, parameters, func() (string, bool, error) {
+		return c.requestMachineID(cn, upn, dns, certificateOptions)
+	})
+}
+
+// requestMachineID enrolls a certificate, enrolled is false when the request was not submitted to TPP
+func (c *venafiConnector) requestMachineID(cn string, upn []string, dns []string, certificateOptions CertificateOptions) (response string, enrolled bool, err error) {
+	if err := enforceRequestGuardrails(c.zone, cn, upn, dns); err != nil {
+		log.Errorf("Request rejected by guardrail policy: %v", err)
+		return c.fail(err), false, nil
+	}
+	enrollReq := newEnrollRequest(cn, upn, dns)
+	enrollReq.ChainOption = certificateOptions.ChainOption
+	err = c.client.GenerateRequest(nil, enrollReq)
 	if err != nil {
 		log.Errorf("Failed to generate request: %v ", err)
-		return createSnowflakeResponse(err.Error()), nil // return nil here, because we would like to see the error in Snowflake
+		return c.fail(err), false, nil // return nil here, because we would like to see the error in Snowflake
 	}
 
 	requestID, err := c.client.RequestCertificate(enrollReq)
 	if err != nil {
 		log.Errorf("Failed to request certificate:: %v ", err)
-		return createSnowflakeResponse(err.Error()), nil // return nil here, because we would like to see the error in Snowflake
+		return c.fail(err), true, nil // return nil here, because we would like to see the error in Snowflake
 	}
 
+	c.recordRequest(REQUEST_MID_TYPE, requestID, cn)
+	c.auditCertificate("", requestID)
+
 	enrollReq.PickupID = requestID
-	enrollReq.Timeout = 180 * time.Second
-	pcc, err := c.client.RetrieveCertificate(enrollReq)
+	pcc, approvals, err := c.retrieveCertificate(enrollReq, 180*time.Second)
+	c.recordRetrieval(requestID, approvals, err)
 	if err != nil {
-		return createSnowflakeResponse(err.Error()), nil // return nil here, because we would like to see the error in Snowflake
+		return c.fail(err), true, nil // return nil here, because we would like to see the error in Snowflake
+	}
+	if approvals != nil {
+		// the key is returned now, the certificate is retrieved with GET_MACHINE_ID once it is approved
+		keyPEM := &certificate.PEMCollection{}
+		if err := keyPEM.AddPrivateKey(enrollReq.PrivateKey, []byte(enrollReq.KeyPassword)); err != nil {
+			log.Errorf("Failed to encode private key: %v", err)
+			return c.fail(err), true, nil
+		}
+		response, err := createAwaitingApprovalResponse(AwaitingApprovalResponse{
+			RequestID:  strings.Replace(requestID, "\\", "\\\\", -1),
+			Approvals:  approvals,
+			PrivateKey: keyPEM.PrivateKey,
+			Passphrase: enrollReq.KeyPassword,
+		})
+		return response, true, err
 	}
 
+	applyCertificateOptions(pcc, certificateOptions)
 	pcc.AddPrivateKey(enrollReq.PrivateKey, []byte(enrollReq.KeyPassword))
+	output, err := formatCertificate(pcc, certificateOptions, time.Now())
+	if err != nil {
+		log.Errorf("Failed to format certificate: %v", err)
+		return c.fail(err), true, nil
+	}
 	escaped_requestID := strings.Replace(fmt.Sprintf("%v", requestID), "\\", "\\\\", -1)
-	responseObject := RequestMachineIDResponse{Certificate: pcc.Certificate, PrivateKey: pcc.PrivateKey, Passphrase: enrollReq.KeyPassword, RequestID: escaped_requestID}
+	responseObject := RequestMachineIDResponse{Certificate: pcc.Certificate, PrivateKey: pcc.PrivateKey, Passphrase: enrollReq.KeyPassword, RequestID: escaped_requestID, Output: output}
 	data, err := json.Marshal(responseObject)
 	if err != nil {
-		return createSnowflakeResponse(err.Error()), err
+		return c.fail(err), true, err
 	}
 
 	// Transform data to a form which is readable by Snowflake
-	return createSnowflakeResponseWithEscape(data), nil
+	return createSnowflakeResponseWithEscape(data), true, nil
 }
 
-func (c *venafiConnector) GetMachineID(ctx context.Context, requestID string) (string, error) {
+func (c *venafiConnector) GetMachineID(ctx context.Context, requestID string, options map[string]interface{}) (string, error) {
+	defer c.audited(GET_MID_TYPE, requestID)()
+	if err := c.authorize(GET_MID_TYPE, requestID); err != nil {
+		return c.fail(err), nil
+	}
+	certificateOptions, err := parseCertificateOptions(options)
+	if err != nil {
+		log.Errorf("Invalid certificate options: %v", err)
+		return c.fail(err), nil
+	}
 	pickupReq := &certificate.Request{
-		PickupID: requestID,
-		Timeout:  180 * time.Second,
+		PickupID:    requestID,
+		ChainOption: certificateOptions.ChainOption,
 	}
 
-	pcc, err := c.client.RetrieveCertificate(pickupReq)
+	pcc, approvals, err := c.retrieveCertificate(pickupReq, 180*time.Second)
+	c.recordRetrieval(requestID, approvals, err)
 	if err != nil {
 		log.Errorf("Could not get certificate: %s", err)
-		return createSnowflakeResponse(err.Error()), nil
+		return c.fail(err), nil
+	}
+	if approvals != nil {
+		return createAwaitingApprovalResponse(AwaitingApprovalResponse{RequestID: requestID, Approvals: approvals})
 	}
+	applyCertificateOptions(pcc, certificateOptions)
 
-	bytes, err := json.Marshal(pcc)
+	metadata, err := parseCertificateMetadata(pcc, time.Now())
+	if err != nil {
+		log.Errorf("Failed to parse certificate metadata: %v", err) // the PEM is still returned
+	}
+	output, err := formatCertificate(pcc, certificateOptions, time.Now())
+	if err != nil {
+		log.Errorf("Failed to format certificate: %v", err)
+		return c.fail(err), nil
+	}
+	bytes, err := json.Marshal(GetMachineIDResponse{PEMCollection: pcc, Metadata: metadata, Output: output})
 	if err != nil {
 		log.Errorf("Failed to serialize certificate: %v", err)
-		return createSnowflakeResponse(err.Error()), err
+		return c.fail(err), err
 	}
 	return createSnowflakeResponseWithEscape(bytes), nil
 }
 
-func (c *venafiConnector) ListMachineIDs(ctx context.Context) (string, error) {
+// ListMachineIDs returns every certificate of the zone, or a page of them when options are provided
+func (c *venafiConnector) ListMachineIDs(ctx context.Context, options map[string]interface{}) (string, error) {
+	defer c.audited(LIST_MID_TYPE, "")()
+	if err := c.authorize(LIST_MID_TYPE, getPolicyDN(c.zone)); err != nil {
+		return c.fail(err), nil
+	}
+	if options != nil {
+		return c.listMachineIDsPage(options)
+	}
 	certList, err := c.client.ListCertificates(endpoint.Filter{})
 	if err != nil {
 		log.Errorf("Failed to list certificates: %s", err)
-		return createSnowflakeResponse(err.Error()), nil
+		return c.fail(err), nil
 	}
 	bytes, err := json.Marshal(certList)
 	if err != nil {
 		log.Errorf("Failed to serialize certificate: %v", err)
-		return createSnowflakeResponse(err.Error()), err
+		return c.fail(err), err
 	}
 	log.Info("Sucessfully called List Certificates")
 	// Transform data to a form which is readable by Snowflake
 	return createSnowflakeResponseWithEscape(bytes), nil
 }
 
-func (c *venafiConnector) RevokeMachineID(ctx context.Context, requestID string, disable bool) (string, error) {
+func (c *venafiConnector) RevokeMachineID(ctx context.Context, requestID string, disable bool, options map[string]interface{}) (string, error) {
+	defer c.audited(REVOKE_MID_TYPE, requestID)()
+	revocationOptions, err := parseRevocationOptions(options)
+	if err != nil {
+		log.Errorf("Invalid revocation options: %v", err)
+		return c.fail(err), nil
+	}
+	certificateDN := requestID
+	if revocationOptions.Thumbprint != "" {
+		if requestID != "" {
+			err := newConnectorError(ERR_INVALID_REVOCATION_OPTIONS, "request_id must be empty when revoking by thumbprint")
+			return c.fail(err), nil
+		}
+		certificateDN, err = c.findCertificateDNByThumbprint(revocationOptions.Thumbprint)
+		if err != nil {
+			log.Errorf("Failed to find certificate by thumbprint: %v", err)
+			return c.fail(err), nil
+		}
+		c.auditCertificate("", certificateDN)
+	}
+	if err := c.authorize(REVOKE_MID_TYPE, certificateDN); err != nil {
+		return c.fail(err), nil
+	}
+	if err := enforceOperationGuardrails(REVOKE_MID_TYPE, certificateDN); err != nil {
+		log.Errorf("Revocation rejected by guardrail policy: %v", err)
+		return c.fail(err), nil
+	}
+	// revoked by the DN which was checked, not by the thumbprint
 	revokeReq := &certificate.RevocationRequest{
-		CertificateDN: requestID,
+		CertificateDN: certificateDN,
+		Reason:        revocationOptions.Reason,
+		Comments:      revocationOptions.Comments,
 		Disable:       disable,
 	}
 
-	err := c.client.RevokeCertificate(revokeReq)
+	err = c.client.RevokeCertificate(revokeReq)
 	if err != nil {
 		log.Errorf("Failed to revoke cert: %v", err)
-		return createSnowflakeResponse(err.Error()), nil
+		return c.fail(err), nil
 	}
-	return createSnowflakeResponse(requestID), nil
+	return createSnowflakeResponse(certificateDN), nil
 }
 
-func (c *venafiConnector) RenewMachineID(ctx context.Context, requestID string) (string, error) {
+// RenewMachineID renews a certificate and returns the request ID, or the renewed certificate when options are provided
+func (c *venafiConnector) RenewMachineID(ctx context.Context, requestID string, options map[string]interface{}) (string, error) {
+	defer c.audited(RENEW_MID_TYPE, requestID)()
+	if err := c.authorize(RENEW_MID_TYPE, requestID); err != nil {
+		return c.fail(err), nil
+	}
+	if err := enforceOperationGuardrails(RENEW_MID_TYPE, requestID); err != nil {
+		log.Errorf("Renewal rejected by guardrail policy: %v", err)
+		return c.fail(err), nil
+	}
+	if options != nil {
+		return c.renewMachineIDWithOptions(ctx, requestID, options)
+	}
 	renewReq := &certificate.RenewalRequest{
 		CertificateDN: requestID,
 	}
@@ -184,12 +348,18 @@ func (c *venafiConnector) RenewMachineID(ctx context.Context, requestID string)
 	requestID, err := c.client.RenewCertificate(renewReq)
 	if err != nil {
 		log.Errorf("Failed to renew certificate: %v", err)
-		return createSnowflakeResponse(err.Error()), nil
+		return c.fail(err), nil
 	}
+	c.recordRequest(RENEW_MID_TYPE, requestID, "")
 	return createSnowflakeResponse(requestID), nil
 }
 
 func (c *venafiConnector) GetMachineIDStatus(ctx context.Context, cn string) (string, error) {
+	defer c.audited(GET_STATUS_MID_TYPE, "")()
+	c.auditCertificate(cn, "")
+	if err := c.authorize(GET_STATUS_MID_TYPE, getPolicyDN(c.zone)); err != nil {
+		return c.fail(err), nil
+	}
 	enrollReq := &certificate.Request{
 		Subject: pkix.Name{
 			CommonName: cn},
@@ -198,7 +368,7 @@ func (c *venafiConnector) GetMachineIDStatus(ctx context.Context, cn string) (st
 	err := c.client.GenerateRequest(nil, enrollReq)
 	if err != nil {
 		log.Errorf("Failed to generate request: %v ", err)
-		return createSnowflakeResponse(err.Error()), nil
+		return c.fail(err), nil
 	}
 
 	log.Info("Generate request was successful")
@@ -209,7 +379,7 @@ func (c *venafiConnector) GetMachineIDStatus(ctx context.Context, cn string) (st
 			return createSnowflakeResponse("Certificate is disabled"), nil
 		} else {
 			log.Errorf("Failed to get status of certificate: %v ", err)
-			return createSnowflakeResponse(err.Error()), nil
+			return c.fail(err), nil
 		}
 	}
 	return createSnowflakeResponse("Certificate is enabled"), nil
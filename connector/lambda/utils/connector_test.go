@@ -1,12 +1,24 @@
 package utils
 
 import (
+	"errors"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/stretchr/testify/assert"
 )
 
+// TestMain runs the tests without an authorization policy and with the audit trail in memory,
+// tests of the policy use useAuthorizationPolicy and tests of the trail useMemoryAuditTrail
+func TestMain(m *testing.M) {
+	authorizationCache.policy, authorizationCache.loadedAt = nil, time.Now().Add(time.Hour)
+	auditStore = memoryBucket{}
+	auditAnchorStore = func() bucketWriter { return nil }
+	os.Exit(m.Run())
+}
+
 func TestCreateConnectorSuccess(t *testing.T) {
 	e := events.APIGatewayProxyRequest{
 		HTTPMethod: "POST",
@@ -20,3 +32,15 @@ func TestCreateConnectorSuccess(t *testing.T) {
 	_, err := NewVenafiConnector(configParams)
 	assert.Nil(t, err)
 }
+
+func TestConnectorFailureResponse(t *testing.T) {
+	response, err := ConnectorFailureResponse(REQUEST_MID_TYPE, ConfigParameters{}, newConnectorError(ERR_TPP_URL_NOT_ALLOWED, "not allowed"))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Contains(t, response.Body, ERR_TPP_URL_NOT_ALLOWED)
+
+	// other errors are retried by Snowflake
+	response, err = ConnectorFailureResponse(REQUEST_MID_TYPE, ConfigParameters{}, errors.New("Failed to get access token"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 500, response.StatusCode)
+}
@@ -0,0 +1,39 @@
+package utils
+
+import "fmt"
+
+// Error codes returned to Snowflake in front of the error message
+const ERR_TPP_URL_NOT_ALLOWED = "TPP_URL_NOT_ALLOWED"
+const ERR_INVALID_CERTIFICATE_OPTIONS = "INVALID_CERTIFICATE_OPTIONS"
+const ERR_INVALID_LIST_OPTIONS = "INVALID_LIST_OPTIONS"
+const ERR_INVALID_SEARCH_CRITERIA = "INVALID_SEARCH_CRITERIA"
+const ERR_INVALID_REVOCATION_OPTIONS = "INVALID_REVOCATION_OPTIONS"
+const ERR_INVALID_RENEWAL_OPTIONS = "INVALID_RENEWAL_OPTIONS"
+const ERR_INVALID_IMPORT_DATA = "INVALID_IMPORT_DATA"
+const ERR_CONFIRMATION_REQUIRED = "CONFIRMATION_REQUIRED"
+const ERR_INVALID_APPROVAL = "INVALID_APPROVAL"
+const ERR_INVALID_REQUEST_STATE = "INVALID_REQUEST_STATE"
+const ERR_GUARDRAIL_POLICY_INVALID = "GUARDRAIL_POLICY_INVALID"
+const ERR_GUARDRAIL_CN_NOT_ALLOWED = "GUARDRAIL_CN_NOT_ALLOWED"
+const ERR_GUARDRAIL_NAMING_CONVENTION = "GUARDRAIL_NAMING_CONVENTION"
+const ERR_GUARDRAIL_SAN_NOT_ALLOWED = "GUARDRAIL_SAN_NOT_ALLOWED"
+const ERR_GUARDRAIL_WILDCARD_NOT_ALLOWED = "GUARDRAIL_WILDCARD_NOT_ALLOWED"
+const ERR_GUARDRAIL_TOO_MANY_SANS = "GUARDRAIL_TOO_MANY_SANS"
+const ERR_GUARDRAIL_OPERATION_NOT_ALLOWED = "GUARDRAIL_OPERATION_NOT_ALLOWED"
+const ERR_NOT_AUTHORIZED = "NOT_AUTHORIZED"
+const ERR_AUTHORIZATION_POLICY_INVALID = "AUTHORIZATION_POLICY_INVALID"
+const ERR_IDEMPOTENCY_KEY_REUSED = "IDEMPOTENCY_KEY_REUSED"
+
+// ConnectorError is an error of the connector with a code which Snowflake callers can match on
+type ConnectorError struct {
+	Code    string
+	Message string
+}
+
+func (e *ConnectorError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newConnectorError(code string, format string, params ...interface{}) *ConnectorError {
+	return &ConnectorError{Code: code, Message: fmt.Sprintf(format, params...)}
+}
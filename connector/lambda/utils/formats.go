@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+)
+
+const DEFAULT_KEYSTORE_ALIAS = "certificate" // alias of certificates without common name
+
+// FormattedCertificate is the certificate packaged in the format of the format option
+type FormattedCertificate struct {
+	Format     string   `json:"Format"`
+	Data       string   `json:"Data"`                 // base64 keystore, base64 DER certificate or PEM bundle
+	Chain      []string `json:"Chain,omitempty"`      // base64 DER chain certificates of the der format
+	PrivateKey string   `json:"PrivateKey,omitempty"` // base64 PKCS#8 private key of the der format
+	Alias      string   `json:"Alias,omitempty"`      // alias of the certificate in the pkcs12 and jks keystores
+}
+
+// parsePEMPrivateKey reads the unencrypted private key added to the PEM collection by vcert
+func parsePEMPrivateKey(data string) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded private key found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %s", block.Type)
+	}
+}
+
+// keystoreChain returns the chain from the issuer of the certificate to the root, the order keystores expect
+func keystoreChain(chain []*x509.Certificate, chainOption certificate.ChainOption) []*x509.Certificate {
+	if chainOption != certificate.ChainOptionRootFirst {
+		return chain
+	}
+	reversed := make([]*x509.Certificate, len(chain))
+	for i, cert := range chain {
+		reversed[len(chain)-1-i] = cert
+	}
+	return reversed
+}
+
+// formatCertificate packages the certificate, chain and private key of a PEM collection in the requested format.
+// It returns nil for the pem format, which is the PEM collection itself.
+func formatCertificate(pcc *certificate.PEMCollection, options CertificateOptions, now time.Time) (*FormattedCertificate, error) {
+	if options.Format == CERTIFICATE_FORMAT_PEM {
+		return nil, nil
+	}
+	result := &FormattedCertificate{Format: options.Format}
+	if options.Format == CERTIFICATE_FORMAT_PEM_BUNDLE {
+		parts := append([]string{pcc.Certificate}, pcc.Chain...)
+		if pcc.PrivateKey != "" {
+			parts = append(parts, pcc.PrivateKey)
+		}
+		for i, part := range parts {
+			parts[i] = strings.TrimSpace(part) + "\n"
+		}
+		result.Data = strings.Join(parts, "")
+		return result, nil
+	}
+
+	leaf, err := parsePEMCertificate(pcc.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	chain := []*x509.Certificate{}
+	for _, chainPEM := range pcc.Chain {
+		cert, err := parsePEMCertificate(chainPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse chain certificate: %v", err)
+		}
+		chain = append(chain, cert)
+	}
+	var privateKey crypto.PrivateKey
+	if pcc.PrivateKey != "" {
+		privateKey, err = parsePEMPrivateKey(pcc.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %v", err)
+		}
+	}
+
+	if options.Format == CERTIFICATE_FORMAT_DER {
+		result.Data = base64.StdEncoding.EncodeToString(leaf.Raw)
+		for _, cert := range chain {
+			result.Chain = append(result.Chain, base64.StdEncoding.EncodeToString(cert.Raw))
+		}
+		if privateKey != nil {
+			pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode private key: %v", err)
+			}
+			result.PrivateKey = base64.StdEncoding.EncodeToString(pkcs8)
+		}
+		return result, nil
+	}
+
+	result.Alias = options.Alias
+	if result.Alias == "" && (options.Format != CERTIFICATE_FORMAT_JKS || isKeystoreString(leaf.Subject.CommonName)) {
+		result.Alias = leaf.Subject.CommonName
+	}
+	if result.Alias == "" {
+		result.Alias = DEFAULT_KEYSTORE_ALIAS
+	}
+	var keystore []byte
+	if options.Format == CERTIFICATE_FORMAT_PKCS12 {
+		keystore, err = encodePKCS12(privateKey, leaf, keystoreChain(chain, options.ChainOption), options.Passphrase, result.Alias)
+	} else {
+		keystore, err = encodeJKS(privateKey, leaf, keystoreChain(chain, options.ChainOption), options.Passphrase, result.Alias, now)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s keystore: %v", options.Format, err)
+	}
+	result.Data = base64.StdEncoding.EncodeToString(keystore)
+	return result, nil
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"github.com/stretchr/testify/assert"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func createTestPEMCollectionWithKey(t *testing.T) (*certificate.PEMCollection, *rsa.PrivateKey) {
+	pcc := createTestPEMCollection(t, time.Now())
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	assert.Nil(t, pcc.AddPrivateKey(key, nil))
+	return pcc, key
+}
+
+func createTestCertificatePEM(t *testing.T, cn string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: cn}, NotBefore: time.Now(), NotAfter: time.Now().AddDate(0, 0, 1)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func decodeFormattedData(t *testing.T, output *FormattedCertificate) []byte {
+	data, err := base64.StdEncoding.DecodeString(output.Data)
+	assert.Nil(t, err)
+	return data
+}
+
+func TestParseCertificateFormatOptions(t *testing.T) {
+	options, err := parseCertificateOptions(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, CERTIFICATE_FORMAT_PEM, options.Format)
+
+	options, err = parseCertificateOptions(map[string]interface{}{"format": "PKCS12", "passphrase": "changeit", "alias": "web"})
+	assert.Nil(t, err)
+	assert.Equal(t, CertificateOptions{Format: CERTIFICATE_FORMAT_PKCS12, Passphrase: "changeit", Alias: "web"}, options)
+
+	for _, invalid := range []map[string]interface{}{
+		{"format": "pfx"},
+		{"format": "jks"},
+		{"format": "jks", "passphrase": "short"},
+		{"format": "der", "passphrase": "changeit"},
+		{"alias": "web"},
+	} {
+		_, err = parseCertificateOptions(invalid)
+		assert.Equal(t, ERR_INVALID_CERTIFICATE_OPTIONS, err.(*ConnectorError).Code, "%v", invalid)
+	}
+}
+
+func TestFormatCertificatePEM(t *testing.T) {
+	pcc, _ := createTestPEMCollectionWithKey(t)
+	output, err := formatCertificate(pcc, CertificateOptions{Format: CERTIFICATE_FORMAT_PEM}, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, output)
+}
+
+func TestFormatCertificatePEMBundle(t *testing.T) {
+	pcc, _ := createTestPEMCollectionWithKey(t)
+	output, err := formatCertificate(pcc, CertificateOptions{Format: CERTIFICATE_FORMAT_PEM_BUNDLE}, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(output.Data, "-----BEGIN CERTIFICATE-----"))
+	assert.True(t, strings.HasPrefix(output.Data, strings.TrimSpace(pcc.Certificate)))
+	assert.True(t, strings.HasSuffix(output.Data, strings.TrimSpace(pcc.PrivateKey)+"\n"))
+}
+
+func TestFormatCertificateDER(t *testing.T) {
+	pcc, key := createTestPEMCollectionWithKey(t)
+	output, err := formatCertificate(pcc, CertificateOptions{Format: CERTIFICATE_FORMAT_DER}, time.Now())
+	assert.Nil(t, err)
+	leaf, err := x509.ParseCertificate(decodeFormattedData(t, output))
+	assert.Nil(t, err)
+	assert.Equal(t, "app.example.com", leaf.Subject.CommonName)
+	assert.Len(t, output.Chain, 1)
+
+	pkcs8, err := base64.StdEncoding.DecodeString(output.PrivateKey)
+	assert.Nil(t, err)
+	parsedKey, err := x509.ParsePKCS8PrivateKey(pkcs8)
+	assert.Nil(t, err)
+	assert.True(t, key.Equal(parsedKey))
+}
+
+func TestFormatCertificatePKCS12(t *testing.T) {
+	pcc, key := createTestPEMCollectionWithKey(t)
+	output, err := formatCertificate(pcc, CertificateOptions{Format: CERTIFICATE_FORMAT_PKCS12, Passphrase: "changeit", Alias: "web"}, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, "web", output.Alias)
+
+	privateKey, leaf, caCerts, err := pkcs12.DecodeChain(decodeFormattedData(t, output), "changeit")
+	assert.Nil(t, err)
+	assert.True(t, key.Equal(privateKey))
+	assert.Equal(t, "app.example.com", leaf.Subject.CommonName)
+	assert.Len(t, caCerts, 1)
+	assert.Equal(t, "Test Issuing CA", caCerts[0].Subject.CommonName)
+
+	_, _, _, err = pkcs12.DecodeChain(decodeFormattedData(t, output), "wrong")
+	assert.Equal(t, pkcs12.ErrIncorrectPassword, err)
+}
+
+func TestFormatCertificatePKCS12WithoutPrivateKey(t *testing.T) {
+	pcc := createTestPEMCollection(t, time.Now())
+	output, err := formatCertificate(pcc, CertificateOptions{Format: CERTIFICATE_FORMAT_PKCS12, Passphrase: "changeit", Alias: "web"}, time.Now())
+	assert.Nil(t, err)
+	certs, err := pkcs12.DecodeTrustStore(decodeFormattedData(t, output), "changeit")
+	assert.Nil(t, err)
+	assert.Len(t, certs, 2)
+	assert.Equal(t, "app.example.com", certs[0].Subject.CommonName)
+}
+
+func TestFormatCertificateJKS(t *testing.T) {
+	pcc, key := createTestPEMCollectionWithKey(t)
+	output, err := formatCertificate(pcc, CertificateOptions{Format: CERTIFICATE_FORMAT_JKS, Passphrase: "changeit"}, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, "app.example.com", output.Alias)
+
+	// read with the JKS format of the JDK's sun.security.provider.JavaKeyStore, not with the constants of the encoder
+	keystore := decodeFormattedData(t, output)
+	password := []byte{0, 'c', 0, 'h', 0, 'a', 0, 'n', 0, 'g', 0, 'e', 0, 'i', 0, 't'}
+	body, digest := keystore[:len(keystore)-sha1.Size], keystore[len(keystore)-sha1.Size:]
+	integrity := sha1.Sum(append(append(append([]byte{}, password...), "Mighty Aphrodite"...), body...))
+	assert.Equal(t, integrity[:], digest)
+	assert.Equal(t, uint32(0xFEEDFEED), binary.BigEndian.Uint32(body[0:4]))
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(body[4:8]))
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(body[8:12]))
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(body[12:16])) // private key entry
+	aliasLength := int(binary.BigEndian.Uint16(body[16:18]))
+	assert.Equal(t, "app.example.com", string(body[18:18+aliasLength]))
+
+	offset := 18 + aliasLength + 8 // alias and timestamp
+	keyLength := int(binary.BigEndian.Uint32(body[offset : offset+4]))
+	keyInfo := struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}{}
+	_, err = asn1.Unmarshal(body[offset+4:offset+4+keyLength], &keyInfo)
+	assert.Nil(t, err)
+	assert.Equal(t, asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}, keyInfo.Algorithm.Algorithm)
+
+	protected := keyInfo.EncryptedData
+	salt, encrypted := protected[:sha1.Size], protected[sha1.Size:len(protected)-sha1.Size]
+	pkcs8 := make([]byte, 0, len(encrypted))
+	for digest := salt; len(pkcs8) < len(encrypted); {
+		sum := sha1.Sum(append(append([]byte{}, password...), digest...))
+		digest = sum[:]
+		for _, b := range digest {
+			if len(pkcs8) < len(encrypted) {
+				pkcs8 = append(pkcs8, encrypted[len(pkcs8)]^b)
+			}
+		}
+	}
+	parsedKey, err := x509.ParsePKCS8PrivateKey(pkcs8)
+	assert.Nil(t, err)
+	assert.True(t, key.Equal(parsedKey))
+	chainLength := binary.BigEndian.Uint32(body[offset+4+keyLength : offset+8+keyLength])
+	assert.Equal(t, uint32(2), chainLength)
+}
+
+func TestFormatCertificateJKSWithoutPrivateKey(t *testing.T) {
+	pcc := createTestPEMCollection(t, time.Now())
+	output, err := formatCertificate(pcc, CertificateOptions{Format: CERTIFICATE_FORMAT_JKS, Passphrase: "changeit", Alias: "web"}, time.Now())
+	assert.Nil(t, err)
+	ks := keystore.New(keystore.WithOrderedAliases(), keystore.WithCaseExactAliases())
+	assert.Nil(t, ks.Load(bytes.NewReader(decodeFormattedData(t, output)), []byte("changeit")))
+	assert.Equal(t, []string{"web", "web-ca1"}, ks.Aliases())
+	assert.True(t, ks.IsTrustedCertificateEntry("web-ca1"))
+}
+
+func TestFormatCertificateJKSAlias(t *testing.T) {
+	pcc, _ := createTestPEMCollectionWithKey(t)
+	pcc.Certificate = createTestCertificatePEM(t, "bücher.example.com")
+	output, err := formatCertificate(pcc, CertificateOptions{Format: CERTIFICATE_FORMAT_JKS, Passphrase: "changeit"}, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, DEFAULT_KEYSTORE_ALIAS, output.Alias)
+
+	_, err = parseCertificateOptions(map[string]interface{}{"format": "jks", "passphrase": "changeit", "alias": "bücher"})
+	assert.Equal(t, ERR_INVALID_CERTIFICATE_OPTIONS, err.(*ConnectorError).Code)
+	_, err = parseCertificateOptions(map[string]interface{}{"format": "jks", "passphrase": "pässwört"})
+	assert.Equal(t, ERR_INVALID_CERTIFICATE_OPTIONS, err.(*ConnectorError).Code)
+	_, err = parseCertificateOptions(map[string]interface{}{"format": "pkcs12", "passphrase": "pässwört", "alias": "bücher"})
+	assert.Nil(t, err)
+}
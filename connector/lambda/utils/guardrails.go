@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/palette-software/go-log-targets"
+)
+
+// GUARDRAIL_POLICY_FILE_NAME is the key of the guardrail policy in the bucket, uploaded by the installer.
+// Without this file the connector only enforces the TPP policy.
+const GUARDRAIL_POLICY_FILE_NAME = "guardrails.json"
+const guardrailPolicyCacheTTL = 5 * time.Minute
+
+// GuardrailRule contains the rules checked by the connector before calling TPP
+type GuardrailRule struct {
+	CommonNameRegexes []string `json:"CommonNameRegexes,omitempty"` // the CN must match one of them
+	SanRegexes        []string `json:"SanRegexes,omitempty"`        // every DNS and UPN SAN must match one of them
+	NamingConvention  string   `json:"NamingConvention,omitempty"`  // regular expression the CN must match
+	BanWildcards      bool     `json:"BanWildcards,omitempty"`
+	MaxSans           int      `json:"MaxSans,omitempty"` // 0 means no limit
+	DisallowRenew     bool     `json:"DisallowRenew,omitempty"`
+	DisallowRevoke    bool     `json:"DisallowRevoke,omitempty"`
+	DisallowRetire    bool     `json:"DisallowRetire,omitempty"`
+	DisallowDelete    bool     `json:"DisallowDelete,omitempty"`
+	DisallowApproval  bool     `json:"DisallowApproval,omitempty"` // approving and rejecting workflow tickets
+	// AllowUntrackedApproval allows approving requests whose submitter is not in the request ledger
+	AllowUntrackedApproval bool `json:"AllowUntrackedApproval,omitempty"`
+}
+
+// GuardrailPolicy is the content of the guardrail policy file. Rules of a zone apply to requests in
+// the zone and to renewal, revocation, retirement and deletion of certificates stored under it, Default applies to the rest.
+type GuardrailPolicy struct {
+	Version string                   `json:"Version"`
+	Default *GuardrailRule           `json:"Default,omitempty"`
+	Zones   map[string]GuardrailRule `json:"Zones,omitempty"`
+}
+
+var guardrailCache struct {
+	sync.Mutex
+	policy   *GuardrailPolicy
+	loadedAt time.Time
+}
+
+func compileRegexes(regexes []string) error {
+	for _, r := range regexes {
+		if _, err := regexp.Compile(r); err != nil {
+			return fmt.Errorf("invalid regular expression '%s': %v", r, err)
+		}
+	}
+	return nil
+}
+
+func (r *GuardrailRule) validate() error {
+	if err := compileRegexes(r.CommonNameRegexes); err != nil {
+		return err
+	}
+	if err := compileRegexes(r.SanRegexes); err != nil {
+		return err
+	}
+	if r.NamingConvention != "" {
+		if err := compileRegexes([]string{r.NamingConvention}); err != nil {
+			return err
+		}
+	}
+	if r.MaxSans < 0 {
+		return fmt.Errorf("MaxSans can not be negative")
+	}
+	return nil
+}
+
+func parseGuardrailPolicy(data []byte) (*GuardrailPolicy, error) {
+	policy := &GuardrailPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, newConnectorError(ERR_GUARDRAIL_POLICY_INVALID, "failed to parse guardrail policy: %v", err)
+	}
+	if policy.Default != nil {
+		if err := policy.Default.validate(); err != nil {
+			return nil, newConnectorError(ERR_GUARDRAIL_POLICY_INVALID, "default rule: %v", err)
+		}
+	}
+	for zone, rule := range policy.Zones {
+		if err := rule.validate(); err != nil {
+			return nil, newConnectorError(ERR_GUARDRAIL_POLICY_INVALID, "rule of zone %s: %v", zone, err)
+		}
+	}
+	return policy, nil
+}
+
+// loadGuardrailPolicy returns the guardrail policy of the bucket, or nil if there is none.
+// The policy is cached for the lifetime of the Lambda container, and read again after the TTL.
+func loadGuardrailPolicy() (*GuardrailPolicy, error) {
+	guardrailCache.Lock()
+	defer guardrailCache.Unlock()
+	if !guardrailCache.loadedAt.IsZero() && time.Since(guardrailCache.loadedAt) < guardrailPolicyCacheTTL {
+		return guardrailCache.policy, nil
+	}
+	data, err := readBucketFile(GUARDRAIL_POLICY_FILE_NAME)
+	if err == errBucketFileNotFound {
+		log.Debug("No guardrail policy in the bucket")
+		guardrailCache.policy, guardrailCache.loadedAt = nil, time.Now()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newConnectorError(ERR_GUARDRAIL_POLICY_INVALID, "failed to read guardrail policy: %v", err)
+	}
+	policy, err := parseGuardrailPolicy(data)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Loaded guardrail policy version %s", policy.Version)
+	guardrailCache.policy, guardrailCache.loadedAt = policy, time.Now()
+	return policy, nil
+}
+
+func normalizeZone(zone string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(zone), "\\"))
+}
+
+// ruleForZone returns the rule of the most specific zone the request's zone is or is nested in, so a rule of a
+// policy folder also applies to its subfolders
+func (p *GuardrailPolicy) ruleForZone(zone string) *GuardrailRule {
+	return p.ruleForPath(normalizeZone(getPolicyDN(zone)))
+}
+
+// ruleForCertificate returns the rule of the most specific zone the certificate DN is stored under
+func (p *GuardrailPolicy) ruleForCertificate(certificateDN string) *GuardrailRule {
+	return p.ruleForPath(normalizeZone(strings.ReplaceAll(certificateDN, "\\\\", "\\")))
+}
+
+// ruleForPath returns the rule of the longest zone which is the normalized path or one of its parent folders
+func (p *GuardrailPolicy) ruleForPath(path string) *GuardrailRule {
+	zones := make([]string, 0, len(p.Zones))
+	for name := range p.Zones {
+		zones = append(zones, name)
+	}
+	sort.Slice(zones, func(i, j int) bool { return len(zones[i]) > len(zones[j]) })
+	for _, name := range zones {
+		if prefix := normalizeZone(name); prefix != "" && (path == prefix || strings.HasPrefix(path, prefix+"\\")) {
+			r := p.Zones[name]
+			return &r
+		}
+	}
+	return p.Default
+}
+
+// checkRequestGuardrails returns every violation of the rule by a new certificate request
+func checkRequestGuardrails(rule *GuardrailRule, cn string, upn []string, dns []string) []PolicyViolation {
+	violations := []PolicyViolation{}
+	if rule == nil {
+		return violations
+	}
+	addViolation := func(code, field, value, message string, params ...interface{}) {
+		violations = append(violations, PolicyViolation{Code: code, Field: field, Value: value, Message: fmt.Sprintf(message, params...)})
+	}
+
+	if !matchesAnyRegex(cn, rule.CommonNameRegexes) {
+		addViolation(ERR_GUARDRAIL_CN_NOT_ALLOWED, "CommonName", cn, "common name does not match the guardrail regular expressions: %v", rule.CommonNameRegexes)
+	}
+	if rule.NamingConvention != "" && !matchesAnyRegex(cn, []string{rule.NamingConvention}) {
+		addViolation(ERR_GUARDRAIL_NAMING_CONVENTION, "CommonName", cn, "common name does not follow the naming convention: %s", rule.NamingConvention)
+	}
+	if rule.BanWildcards && strings.Contains(cn, "*") {
+		addViolation(ERR_GUARDRAIL_WILDCARD_NOT_ALLOWED, "CommonName", cn, "wildcard certificates are banned by the guardrail policy")
+	}
+
+	dnsNames := nonEmptyValues(dns)
+	upns := nonEmptyValues(upn)
+	for _, name := range dnsNames {
+		if !matchesAnyRegex(name, rule.SanRegexes) {
+			addViolation(ERR_GUARDRAIL_SAN_NOT_ALLOWED, "DNSName", name, "SAN does not match the guardrail regular expressions: %v", rule.SanRegexes)
+		}
+		if rule.BanWildcards && strings.Contains(name, "*") {
+			addViolation(ERR_GUARDRAIL_WILDCARD_NOT_ALLOWED, "DNSName", name, "wildcard SANs are banned by the guardrail policy")
+		}
+	}
+	for _, name := range upns {
+		if !matchesAnyRegex(name, rule.SanRegexes) {
+			addViolation(ERR_GUARDRAIL_SAN_NOT_ALLOWED, "UPN", name, "SAN does not match the guardrail regular expressions: %v", rule.SanRegexes)
+		}
+	}
+	if sanCount := len(dnsNames) + len(upns); rule.MaxSans > 0 && sanCount > rule.MaxSans {
+		addViolation(ERR_GUARDRAIL_TOO_MANY_SANS, "SANs", fmt.Sprintf("%d", sanCount), "the request has %d SANs, the guardrail policy allows at most %d", sanCount, rule.MaxSans)
+	}
+	return violations
+}
+
+// checkOperationGuardrails checks whether the rule allows renewing, revoking, retiring, deleting or approving a certificate
+func checkOperationGuardrails(rule *GuardrailRule, operation string, certificateDN string) error {
+	if rule == nil {
+		return nil
+	}
+	disallowed := map[string]bool{
+		RENEW_MID_TYPE:   rule.DisallowRenew,
+		REVOKE_MID_TYPE:  rule.DisallowRevoke,
+		RETIRE_MID_TYPE:  rule.DisallowRetire,
+		DELETE_MID_TYPE:  rule.DisallowDelete,
+		APPROVE_MID_TYPE: rule.DisallowApproval,
+		REJECT_MID_TYPE:  rule.DisallowApproval,
+	}
+	if disallowed[operation] {
+		return newConnectorError(ERR_GUARDRAIL_OPERATION_NOT_ALLOWED, "%s is not allowed by the guardrail policy for %s", operation, certificateDN)
+	}
+	return nil
+}
+
+// guardrailViolations returns the violations of the guardrail policy by a new certificate request in the zone
+func guardrailViolations(zone string, cn string, upn []string, dns []string) ([]PolicyViolation, error) {
+	policy, err := loadGuardrailPolicy()
+	if err != nil || policy == nil {
+		return []PolicyViolation{}, err
+	}
+	return checkRequestGuardrails(policy.ruleForZone(zone), cn, upn, dns), nil
+}
+
+// enforceRequestGuardrails returns the first violation of the guardrail policy as an error
+func enforceRequestGuardrails(zone string, cn string, upn []string, dns []string) error {
+	violations, err := guardrailViolations(zone, cn, upn, dns)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return newConnectorError(violations[0].Code, "%s", violations[0].Message)
+	}
+	return nil
+}
+
+// enforceOperationGuardrails checks a renewal, revocation, retirement or deletion against the guardrail policy
+func enforceOperationGuardrails(operation string, certificateDN string) error {
+	policy, err := loadGuardrailPolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+	return checkOperationGuardrails(policy.ruleForCertificate(certificateDN), operation, certificateDN)
+}
+
+// allowsUntrackedApproval reports whether the rule of the certificate's zone allows approving a request
+// whose submitter is not in the request ledger
+func allowsUntrackedApproval(certificateDN string) (bool, error) {
+	policy, err := loadGuardrailPolicy()
+	if err != nil || policy == nil {
+		return false, err
+	}
+	rule := policy.ruleForCertificate(certificateDN)
+	return rule != nil && rule.AllowUntrackedApproval, nil
+}
+
+// enforceRenewalGuardrails checks the subject and SANs of a renewal with a new key against the rule of the certificate's zone
+func enforceRenewalGuardrails(certificateDN string, cn string, upn []string, dns []string) error {
+	policy, err := loadGuardrailPolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+	violations := checkRequestGuardrails(policy.ruleForCertificate(certificateDN), cn, upn, dns)
+	if len(violations) > 0 {
+		return newConnectorError(violations[0].Code, "%s", violations[0].Message)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testGuardrailPolicy = `{
+	"Version": "3",
+	"Default": {"BanWildcards": true},
+	"Zones": {
+		"\\VED\\Policy\\Web": {
+			"CommonNameRegexes": ["\\.example\\.com$"],
+			"SanRegexes": ["\\.example\\.com$"],
+			"NamingConvention": "^(app|api)-",
+			"BanWildcards": true,
+			"MaxSans": 2
+		},
+		"\\VED\\Policy\\Web\\Prod": {"DisallowRevoke": true}
+	}
+}`
+
+func TestParseGuardrailPolicy(t *testing.T) {
+	policy, err := parseGuardrailPolicy([]byte(testGuardrailPolicy))
+	assert.Nil(t, err)
+	assert.Equal(t, "3", policy.Version)
+	assert.Equal(t, 2, policy.ruleForZone("\\ved\\policy\\web\\").MaxSans)
+	assert.Equal(t, policy.Default, policy.ruleForZone("\\VED\\Policy\\Other"))
+	// subfolders use the rule of the most specific zone above them
+	assert.Equal(t, 2, policy.ruleForZone("\\VED\\Policy\\Web\\Internal").MaxSans)
+	assert.True(t, policy.ruleForZone("\\VED\\Policy\\Web\\Prod\\Payments").DisallowRevoke)
+	assert.Equal(t, policy.Default, policy.ruleForZone("\\VED\\Policy\\Website"))
+	assert.Equal(t, 2, policy.ruleForZone("Web\\Internal").MaxSans)
+
+	_, err = parseGuardrailPolicy([]byte(`{"Zones": {"z": {"SanRegexes": ["("]}}}`))
+	assert.Equal(t, ERR_GUARDRAIL_POLICY_INVALID, err.(*ConnectorError).Code)
+}
+
+func TestCheckRequestGuardrails(t *testing.T) {
+	policy, err := parseGuardrailPolicy([]byte(testGuardrailPolicy))
+	assert.Nil(t, err)
+	rule := policy.ruleForZone("\\VED\\Policy\\Web")
+
+	assert.Empty(t, checkRequestGuardrails(rule, "app-1.example.com", []string{""}, []string{"app-1.example.com"}))
+
+	violations := checkRequestGuardrails(rule, "*.other.org", []string{"svc@mail.example.com"}, []string{"a.example.com", "b.other.org"})
+	codes := []string{}
+	for _, violation := range violations {
+		codes = append(codes, violation.Code)
+	}
+	assert.Equal(t, []string{
+		ERR_GUARDRAIL_CN_NOT_ALLOWED,
+		ERR_GUARDRAIL_NAMING_CONVENTION,
+		ERR_GUARDRAIL_WILDCARD_NOT_ALLOWED,
+		ERR_GUARDRAIL_SAN_NOT_ALLOWED,
+		ERR_GUARDRAIL_TOO_MANY_SANS,
+	}, codes)
+}
+
+func TestCheckOperationGuardrails(t *testing.T) {
+	policy, err := parseGuardrailPolicy([]byte(testGuardrailPolicy))
+	assert.Nil(t, err)
+
+	// request ids arrive with escaped backslashes from Snowflake
+	rule := policy.ruleForCertificate("\\\\VED\\\\Policy\\\\Web\\\\Prod\\\\app-1.example.com")
+	err = checkOperationGuardrails(rule, REVOKE_MID_TYPE, "app-1.example.com")
+	assert.Equal(t, ERR_GUARDRAIL_OPERATION_NOT_ALLOWED, err.(*ConnectorError).Code)
+	assert.Nil(t, checkOperationGuardrails(rule, RENEW_MID_TYPE, "app-1.example.com"))
+	assert.Nil(t, checkOperationGuardrails(rule, RETIRE_MID_TYPE, "app-1.example.com"))
+	err = checkOperationGuardrails(&GuardrailRule{DisallowDelete: true}, DELETE_MID_TYPE, "app-1.example.com")
+	assert.Equal(t, ERR_GUARDRAIL_OPERATION_NOT_ALLOWED, err.(*ConnectorError).Code)
+	err = checkOperationGuardrails(&GuardrailRule{DisallowApproval: true}, REJECT_MID_TYPE, "app-1.example.com")
+	assert.Equal(t, ERR_GUARDRAIL_OPERATION_NOT_ALLOWED, err.(*ConnectorError).Code)
+
+	rule = policy.ruleForCertificate("\\VED\\Policy\\Web\\app-2.example.com")
+	assert.Nil(t, checkOperationGuardrails(rule, REVOKE_MID_TYPE, "app-2.example.com"))
+}
@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/palette-software/go-log-targets"
+)
+
+const IDEMPOTENCY_PREFIX = "idempotency/"
+const DEFAULT_IDEMPOTENCY_WINDOW_MINUTES = 60
+const MAX_IDEMPOTENCY_KEY_LENGTH = 256
+
+// A call still running after the maximum Lambda timeout has failed without completing its record
+const idempotencyInProgressTimeout = 15 * time.Minute
+const idempotencyPollInterval = 2 * time.Second
+
+// State of an idempotency record
+const idempotencyStateInProgress = "in_progress"
+const idempotencyStateCompleted = "completed"
+
+var idempotencyStore bucketStore = deploymentBucket{}
+
+// idempotencyRecord is the result of a call, returned again when Snowflake retries it. The response contains the
+// private key, it is encrypted with a key derived from the identity and the parameters of the call, which are not
+// stored, so the record can only be read by a retry of the same call.
+type idempotencyRecord struct {
+	State      string    `json:"State"`
+	Started    time.Time `json:"Started"`
+	Expires    time.Time `json:"Expires"`
+	Parameters string    `json:"Parameters"` // hash of the parameters of the first call
+	Response   string    `json:"Response,omitempty"`
+}
+
+// idempotencyWindow is how long results are kept, from IDEMPOTENCY_WINDOW_MINUTES. 0 disables idempotency.
+func idempotencyWindow() time.Duration {
+	minutes := DEFAULT_IDEMPOTENCY_WINDOW_MINUTES
+	if value := os.Getenv("IDEMPOTENCY_WINDOW_MINUTES"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			log.Errorf("Invalid IDEMPOTENCY_WINDOW_MINUTES %s, using %d", value, DEFAULT_IDEMPOTENCY_WINDOW_MINUTES)
+		} else {
+			minutes = parsed
+		}
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// idempotencyIdentity identifies a call by the key of the caller, or by the Snowflake batch and row when there
+// is none. Keys are scoped to the operation, the TPP server, the zone and the Snowflake caller, so the same key
+// used by another caller or in another zone is another call.
+func (c *venafiConnector) idempotencyIdentity(operation string, key string) string {
+	if key == "" {
+		if c.requestContext.RetryKey() == "" {
+			return ""
+		}
+		key = "batch:" + c.requestContext.RetryKey()
+	} else {
+		key = "key:" + key
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", operation, c.tppURL, getPolicyDN(c.zone),
+		c.requestContext.User, c.requestContext.Role, c.requestContext.Account, key)
+}
+
+func (c *venafiConnector) idempotencyRecordKey(operation string, key string) string {
+	identity := c.idempotencyIdentity(operation, key)
+	if identity == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(identity))
+	return IDEMPOTENCY_PREFIX + hex.EncodeToString(sum[:]) + ".json"
+}
+
+// idempotencySecret derives a value of the identity and the parameters of the call for the label, none of them
+// are stored in the record
+func idempotencySecret(label string, identity string, parameters []byte) []byte {
+	sum := sha256.Sum256([]byte(label + "|" + identity + "|" + string(parameters)))
+	return sum[:]
+}
+
+func sealIdempotencyResponse(key []byte, response string) (string, error) {
+	gcm, err := newIdempotencyCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(response), nil)), nil
+}
+
+func openIdempotencyResponse(key []byte, sealed string) (string, error) {
+	gcm, err := newIdempotencyCipher(key)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid idempotency response")
+	}
+	response, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt idempotency response: %v", err)
+	}
+	return string(response), nil
+}
+
+func newIdempotencyCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func readIdempotencyRecord(key string) (*idempotencyRecord, error) {
+	data, err := idempotencyStore.read(key)
+	if err == errBucketFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := &idempotencyRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("invalid idempotency record %s: %v", key, err)
+	}
+	return record, nil
+}
+
+func writeIdempotencyRecord(key string, record *idempotencyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return idempotencyStore.write(key, data)
+}
+
+// waitForIdempotencyRecord waits for the call which started the record to complete, until the Lambda is about to time out
+func waitForIdempotencyRecord(ctx context.Context, key string) (*idempotencyRecord, error) {
+	for {
+		record, err := readIdempotencyRecord(key)
+		if err != nil || record == nil || record.State != idempotencyStateInProgress {
+			return record, err
+		}
+		if pickupTimeout(ctx, idempotencyPollInterval, time.Now()) < idempotencyPollInterval {
+			return record, nil
+		}
+		time.Sleep(idempotencyPollInterval)
+	}
+}
+
+// idempotent runs the call once per key within the idempotency window. A retry returns the response of
+// the first call, or waits for it while it is running. The response is only kept when the call submitted
+// a request to TPP, calls rejected before that can be retried. A key reused with other parameters fails.
+func (c *venafiConnector) idempotent(ctx context.Context, operation string, key string, parameters interface{}, call func() (string, bool, error)) (string, error) {
+	window := idempotencyWindow()
+	identity := c.idempotencyIdentity(operation, key)
+	if window == 0 || identity == "" {
+		response, _, err := call()
+		return response, err
+	}
+	recordKey := c.idempotencyRecordKey(operation, key)
+	parametersJSON, err := json.Marshal(parameters)
+	if err != nil {
+		return c.fail(err), err
+	}
+	parametersHash := hex.EncodeToString(idempotencySecret("parameters", identity, parametersJSON))
+	responseKey := idempotencySecret("response", identity, parametersJSON)
+
+	now := time.Now().UTC()
+	record, err := readIdempotencyRecord(recordKey)
+	if err != nil {
+		log.Errorf("Failed to read idempotency record, the call is not deduplicated: %v", err)
+	}
+	if record != nil && !now.Before(record.Expires) {
+		// the response of an expired record is not kept until the lifecycle rule of the bucket removes it
+		if err := idempotencyStore.delete(recordKey); err != nil {
+			log.Errorf("Failed to delete expired idempotency record: %v", err)
+		}
+		record = nil
+	}
+	if record != nil && record.Parameters != parametersHash {
+		return c.fail(newConnectorError(ERR_IDEMPOTENCY_KEY_REUSED, "the idempotency key was used by an earlier call with other parameters")), nil
+	}
+	if record != nil {
+		if record.State == idempotencyStateInProgress && now.Sub(record.Started) < idempotencyInProgressTimeout {
+			log.Infof("The first call of %s is still running, waiting for its result", operation)
+			record, err = waitForIdempotencyRecord(ctx, recordKey)
+			if err != nil {
+				log.Errorf("Failed to read idempotency record: %v", err)
+			}
+			if record != nil && record.State == idempotencyStateInProgress {
+				// the error makes Snowflake retry the batch later instead of showing a result
+				err := fmt.Errorf("the first call of %s is still running, retry later", operation)
+				return c.fail(err), err
+			}
+		}
+		if record != nil && record.State == idempotencyStateCompleted {
+			response, err := openIdempotencyResponse(responseKey, record.Response)
+			if err == nil {
+				log.Infof("Returning the result of the first call of %s", operation)
+				return response, nil
+			}
+			log.Errorf("Failed to read the result of the first call of %s: %v", operation, err)
+		}
+	}
+
+	record = &idempotencyRecord{State: idempotencyStateInProgress, Started: now, Expires: now.Add(window), Parameters: parametersHash}
+	if err := writeIdempotencyRecord(recordKey, record); err != nil {
+		log.Errorf("Failed to write idempotency record, the call is not deduplicated: %v", err)
+	}
+	response, submitted, err := call()
+	if err != nil || !submitted {
+		if err := idempotencyStore.delete(recordKey); err != nil {
+			log.Errorf("Failed to delete idempotency record: %v", err)
+		}
+		return response, err
+	}
+	sealed, err := sealIdempotencyResponse(responseKey, response)
+	if err != nil {
+		log.Errorf("Failed to encrypt idempotency response: %v", err)
+		if err := idempotencyStore.delete(recordKey); err != nil {
+			log.Errorf("Failed to delete idempotency record: %v", err)
+		}
+		return response, nil
+	}
+	record.State, record.Response = idempotencyStateCompleted, sealed
+	if err := writeIdempotencyRecord(recordKey, record); err != nil {
+		log.Errorf("Failed to write idempotency record: %v", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useMemoryIdempotencyStore(t *testing.T) memoryBucket {
+	storage := memoryBucket{}
+	idempotencyStore = storage
+	t.Cleanup(func() { idempotencyStore = deploymentBucket{} })
+	return storage
+}
+
+func countingCall(calls *int, submitted bool) func() (string, bool, error) {
+	return func() (string, bool, error) {
+		*calls++
+		return createSnowflakeResponse("certificate"), submitted, nil
+	}
+}
+
+func TestIdempotentReturnsFirstResult(t *testing.T) {
+	useMemoryIdempotencyStore(t)
+	c := &venafiConnector{tppURL: "https://tpp.example.com", requestContext: RequestContext{BatchID: "batch-1", Row: "0"}}
+	calls := 0
+
+	first, err := c.idempotent(context.Background(), REQUEST_MID_TYPE, "", "app.example.com", countingCall(&calls, true))
+	assert.Nil(t, err)
+	retry, err := c.idempotent(context.Background(), REQUEST_MID_TYPE, "", "app.example.com", countingCall(&calls, true))
+	assert.Nil(t, err)
+	assert.Equal(t, first, retry)
+	assert.Equal(t, 1, calls)
+
+	// the key of the caller is used instead of the batch
+	_, err = c.idempotent(context.Background(), REQUEST_MID_TYPE, "order-42", "app.example.com", countingCall(&calls, true))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+	other := &venafiConnector{tppURL: "https://tpp.example.com", requestContext: RequestContext{BatchID: "batch-2", Row: "0"}}
+	_, err = other.idempotent(context.Background(), REQUEST_MID_TYPE, "order-42", "app.example.com", countingCall(&calls, true))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotentScope(t *testing.T) {
+	storage := useMemoryIdempotencyStore(t)
+	alice := RequestContext{BatchID: "batch-1", Row: "0", User: "ALICE", Role: "PLATFORM_ENGINEER"}
+	c := &venafiConnector{tppURL: "https://tpp.example.com", zone: "Web", requestContext: alice}
+	calls := 0
+
+	first, err := c.idempotent(context.Background(), REQUEST_MID_TYPE, "deploy-1", "app.example.com", countingCall(&calls, true))
+	assert.Nil(t, err)
+	for _, data := range storage {
+		assert.NotContains(t, string(data), "certificate", "the response is encrypted")
+	}
+
+	// another caller or another zone does not get the result of the key
+	bob := &venafiConnector{tppURL: "https://tpp.example.com", zone: "Web", requestContext: RequestContext{User: "BOB", Role: "PLATFORM_ENGINEER"}}
+	_, err = bob.idempotent(context.Background(), REQUEST_MID_TYPE, "deploy-1", "app.example.com", countingCall(&calls, true))
+	assert.Nil(t, err)
+	otherZone := &venafiConnector{tppURL: "https://tpp.example.com", zone: "DB", requestContext: alice}
+	_, err = otherZone.idempotent(context.Background(), REQUEST_MID_TYPE, "deploy-1", "app.example.com", countingCall(&calls, true))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+
+	// the key used again with other parameters fails
+	response, err := c.idempotent(context.Background(), REQUEST_MID_TYPE, "deploy-1", "db.example.com", countingCall(&calls, true))
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_IDEMPOTENCY_KEY_REUSED)
+	assert.Equal(t, 3, calls)
+	retry, err := c.idempotent(context.Background(), REQUEST_MID_TYPE, "deploy-1", "app.example.com", countingCall(&calls, true))
+	assert.Nil(t, err)
+	assert.Equal(t, first, retry)
+	assert.Equal(t, 3, calls)
+}
+
+func TestIdempotentExpiredRecord(t *testing.T) {
+	storage := useMemoryIdempotencyStore(t)
+	c := &venafiConnector{tppURL: "https://tpp.example.com", requestContext: RequestContext{BatchID: "batch-1", Row: "0"}}
+	now := time.Now().UTC()
+	key := c.idempotencyRecordKey(REQUEST_MID_TYPE, "")
+	writeIdempotencyRecord(key, &idempotencyRecord{State: idempotencyStateCompleted, Started: now.Add(-2 * time.Hour), Expires: now.Add(-time.Hour), Response: "old"})
+
+	calls := 0
+	c.idempotent(context.Background(), REQUEST_MID_TYPE, "", "app.example.com", countingCall(&calls, false))
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, storage)
+}
+
+func TestIdempotentRetriesUnsubmittedCalls(t *testing.T) {
+	storage := useMemoryIdempotencyStore(t)
+	c := &venafiConnector{tppURL: "https://tpp.example.com", requestContext: RequestContext{BatchID: "batch-1", Row: "0"}}
+	calls := 0
+
+	c.idempotent(context.Background(), REQUEST_MID_TYPE, "", "app.example.com", countingCall(&calls, false))
+	c.idempotent(context.Background(), REQUEST_MID_TYPE, "", "app.example.com", countingCall(&calls, false))
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, storage)
+
+	// without batch and key every call runs
+	c.requestContext = RequestContext{}
+	c.idempotent(context.Background(), REQUEST_MID_TYPE, "", "app.example.com", countingCall(&calls, true))
+	c.idempotent(context.Background(), REQUEST_MID_TYPE, "", "app.example.com", countingCall(&calls, true))
+	assert.Equal(t, 4, calls)
+}
+
+func TestIdempotentCallInProgress(t *testing.T) {
+	useMemoryIdempotencyStore(t)
+	c := &venafiConnector{tppURL: "https://tpp.example.com", requestContext: RequestContext{BatchID: "batch-1", Row: "0"}}
+	now := time.Now().UTC()
+	key := c.idempotencyRecordKey(REQUEST_MID_TYPE, "")
+	parameters, _ := json.Marshal("app.example.com")
+	parametersHash := hex.EncodeToString(idempotencySecret("parameters", c.idempotencyIdentity(REQUEST_MID_TYPE, ""), parameters))
+	writeIdempotencyRecord(key, &idempotencyRecord{State: idempotencyStateInProgress, Started: now, Expires: now.Add(time.Hour), Parameters: parametersHash})
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(time.Second))
+	defer cancel()
+	calls := 0
+	_, err := c.idempotent(ctx, REQUEST_MID_TYPE, "", "app.example.com", countingCall(&calls, true))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, calls)
+
+	// a call which did not complete before the Lambda timeout is run again
+	writeIdempotencyRecord(key, &idempotencyRecord{State: idempotencyStateInProgress, Started: now.Add(-time.Hour), Expires: now.Add(time.Hour), Parameters: parametersHash})
+	_, err = c.idempotent(ctx, REQUEST_MID_TYPE, "", "app.example.com", countingCall(&calls, true))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestIdempotencyWindow(t *testing.T) {
+	defer os.Unsetenv("IDEMPOTENCY_WINDOW_MINUTES")
+	assert.Equal(t, time.Hour, idempotencyWindow())
+	os.Setenv("IDEMPOTENCY_WINDOW_MINUTES", "0")
+	assert.Equal(t, time.Duration(0), idempotencyWindow())
+	os.Setenv("IDEMPOTENCY_WINDOW_MINUTES", "-5")
+	assert.Equal(t, time.Hour, idempotencyWindow())
+}
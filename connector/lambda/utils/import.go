@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"strings"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	log "github.com/palette-software/go-log-targets"
+)
+
+// Status of the certificate in the response of IMPORT_MACHINE_ID
+const IMPORT_STATUS_IMPORTED = "imported"
+const IMPORT_STATUS_DUPLICATE = "duplicate"
+
+type ImportMachineIDResponse struct {
+	DN         string `json:"DN"`
+	Guid       string `json:"Guid"`
+	Thumbprint string `json:"Thumbprint"`
+	Status     string `json:"Status"` // duplicate when the certificate is already in the zone, it is not imported again
+}
+
+// ImportMachineID imports a certificate discovered outside Venafi, with its private key if there is one, into the zone.
+// The thumbprint is looked up in the zone first, so importing the same certificate again returns the existing object.
+// Certificates outside the zone are not returned, the caller is only authorized to import into the zone.
+func (c *venafiConnector) ImportMachineID(ctx context.Context, objectName string, certificateData string, privateKeyData string, passphrase string) (string, error) {
+	defer c.audited(IMPORT_MID_TYPE, "")()
+	if err := c.authorize(IMPORT_MID_TYPE, getPolicyDN(c.zone)); err != nil {
+		return c.fail(err), nil
+	}
+	if c.zone == "" {
+		return c.fail(newConnectorError(ERR_INVALID_IMPORT_DATA, "zone is required")), nil
+	}
+	cert, err := parsePEMCertificate(strings.TrimSpace(certificateData))
+	if err != nil {
+		err = newConnectorError(ERR_INVALID_IMPORT_DATA, "failed to parse certificate: %v", err)
+		log.Errorf("Invalid certificate to import: %v", err)
+		return c.fail(err), nil
+	}
+	if privateKeyData == "" && passphrase != "" {
+		err = newConnectorError(ERR_INVALID_IMPORT_DATA, "passphrase is only used with a private key")
+		return c.fail(err), nil
+	}
+	if objectName == "" {
+		objectName = cert.Subject.CommonName
+	}
+	if objectName == "" {
+		err = newConnectorError(ERR_INVALID_IMPORT_DATA, "object_name is required for certificates without common name")
+		return c.fail(err), nil
+	}
+	c.auditCertificate(cert.Subject.CommonName, "")
+	sum := sha1.Sum(cert.Raw)
+	response := ImportMachineIDResponse{Thumbprint: fingerprint(sum[:])}
+
+	existing, err := c.searchByThumbprint(c.zone, response.Thumbprint)
+	if err != nil {
+		log.Errorf("Failed to search certificate by thumbprint: %v", err)
+		return c.fail(err), nil
+	}
+	if len(existing.Certificates) > 0 {
+		log.Infof("Certificate %s is already in the zone as %s", response.Thumbprint, existing.Certificates[0].DN)
+		response.DN, response.Guid, response.Status = existing.Certificates[0].DN, existing.Certificates[0].Guid, IMPORT_STATUS_DUPLICATE
+	} else {
+		importResponse, err := c.client.ImportCertificate(&certificate.ImportRequest{
+			PolicyDN:        getPolicyDN(c.zone),
+			ObjectName:      objectName,
+			CertificateData: certificateData,
+			PrivateKeyData:  privateKeyData,
+			Password:        passphrase,
+		})
+		if err != nil {
+			log.Errorf("Failed to import certificate: %v", err)
+			return c.fail(err), nil
+		}
+		log.Infof("Imported certificate %s as %s", response.Thumbprint, importResponse.CertificateDN)
+		response.DN, response.Guid, response.Status = importResponse.CertificateDN, importResponse.Guid, IMPORT_STATUS_IMPORTED
+	}
+	c.auditCertificate("", response.DN)
+	data, err := json.Marshal(response)
+	if err != nil {
+		return c.fail(err), err
+	}
+	return createSnowflakeResponseWithEscape(data), nil
+}
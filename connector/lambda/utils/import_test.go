@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportMachineIDDuplicate(t *testing.T) {
+	pcc := createTestPEMCollection(t, time.Now())
+	cert, err := parsePEMCertificate(pcc.Certificate)
+	assert.Nil(t, err)
+	sum := sha1.Sum(cert.Raw)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, fingerprint(sum[:]), r.URL.Query().Get("Thumbprint"))
+		assert.Equal(t, "\\VED\\Policy\\Imported", r.URL.Query().Get("ParentDnRecursive"))
+		w.Write([]byte(`{"Certificates": [{"DN": "\\VED\\Policy\\Imported\\app", "Guid": "{1234}"}], "TotalCount": 1}`))
+	}))
+	defer server.Close()
+
+	c := &venafiConnector{api: newTPPAPIClient(server.URL, "test-token", server.Client()), zone: "Imported"}
+	response, err := c.ImportMachineID(context.Background(), "", pcc.Certificate, "", "")
+	assert.Nil(t, err)
+	assert.Contains(t, response, `"Guid":"{1234}"`)
+	assert.Contains(t, response, `"Status":"duplicate"`)
+}
+
+func TestImportMachineIDInvalidData(t *testing.T) {
+	response, err := (&venafiConnector{}).ImportMachineID(context.Background(), "app", "not a certificate", "", "")
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_INVALID_IMPORT_DATA)
+
+	c := &venafiConnector{zone: "Imported"}
+	response, err = c.ImportMachineID(context.Background(), "app", "not a certificate", "", "")
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_INVALID_IMPORT_DATA)
+
+	pcc := createTestPEMCollection(t, time.Now())
+	response, err = c.ImportMachineID(context.Background(), "app", pcc.Certificate, "", "secret")
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_INVALID_IMPORT_DATA)
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// isKeystoreString reports whether a JKS alias or passphrase only has printable ASCII characters. JKS stores
+// aliases in Java's modified UTF-8 and derives its keys from UTF-16 passwords, which match the encoding of the
+// keystore library for these characters only.
+func isKeystoreString(value string) bool {
+	for _, r := range value {
+		if r < 0x20 || r > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// chainAlias returns the alias of the n-th chain certificate of keystores without private key
+func chainAlias(alias string, n int) string {
+	return fmt.Sprintf("%s-ca%d", alias, n)
+}
+
+// encodePKCS12 creates a PKCS#12 keystore of the certificate, its chain and the private key if there is one.
+// It uses the legacy algorithms of go-pkcs12 (3DES and a SHA-1 MAC), which every PKCS#12 consumer reads.
+// go-pkcs12 identifies the private key by its local key id, so the alias is only the friendly name of the
+// certificates of keystores without private key.
+func encodePKCS12(privateKey crypto.PrivateKey, leaf *x509.Certificate, chain []*x509.Certificate, passphrase string, alias string) ([]byte, error) {
+	if privateKey != nil {
+		return pkcs12.Legacy.Encode(privateKey, leaf, chain, passphrase)
+	}
+	entries := []pkcs12.TrustStoreEntry{{Cert: leaf, FriendlyName: alias}}
+	for i, cert := range chain {
+		entries = append(entries, pkcs12.TrustStoreEntry{Cert: cert, FriendlyName: chainAlias(alias, i+1)})
+	}
+	return pkcs12.Legacy.EncodeTrustStoreEntries(entries, passphrase)
+}
+
+// encodeJKS creates a Java keystore with a private key entry of the certificate and its chain. Without a
+// private key every certificate is a trusted certificate entry, the chain under the alias-ca<n> aliases.
+func encodeJKS(privateKey crypto.PrivateKey, leaf *x509.Certificate, chain []*x509.Certificate, passphrase string, alias string, now time.Time) ([]byte, error) {
+	ks := keystore.New(keystore.WithCaseExactAliases())
+	certificates := []keystore.Certificate{{Type: "X.509", Content: leaf.Raw}}
+	for _, cert := range chain {
+		certificates = append(certificates, keystore.Certificate{Type: "X.509", Content: cert.Raw})
+	}
+	if privateKey != nil {
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode private key: %v", err)
+		}
+		entry := keystore.PrivateKeyEntry{CreationTime: now, PrivateKey: pkcs8, CertificateChain: certificates}
+		if err := ks.SetPrivateKeyEntry(alias, entry, []byte(passphrase)); err != nil {
+			return nil, err
+		}
+	} else {
+		for i, cert := range certificates {
+			certAlias := alias
+			if i > 0 {
+				certAlias = chainAlias(alias, i)
+			}
+			if err := ks.SetTrustedCertificateEntry(certAlias, keystore.TrustedCertificateEntry{CreationTime: now, Certificate: cert}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	data := &bytes.Buffer{}
+	if err := ks.Store(data, []byte(passphrase)); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
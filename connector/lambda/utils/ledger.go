@@ -0,0 +1,272 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	log "github.com/palette-software/go-log-targets"
+)
+
+// The ledger keeps one object per submitted request under ledger/requests/, and an empty marker per
+// request under ledger/index/<state>/, so requests in a state are listed without reading the others
+const LEDGER_REQUESTS_PREFIX = "ledger/requests/"
+const LEDGER_INDEX_PREFIX = "ledger/index/"
+
+// State of a request in the ledger
+const LEDGER_STATE_PENDING = "pending"
+const LEDGER_STATE_AWAITING_APPROVAL = APPROVAL_STATUS_AWAITING
+const LEDGER_STATE_ISSUED = "issued"
+const LEDGER_STATE_FAILED = "failed"
+
+var ledgerStates = []string{LEDGER_STATE_PENDING, LEDGER_STATE_AWAITING_APPROVAL, LEDGER_STATE_FAILED, LEDGER_STATE_ISSUED}
+
+func isLedgerState(state string) bool {
+	for _, s := range ledgerStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+var requestLedger bucketStore = deploymentBucket{}
+
+type LedgerTransition struct {
+	State   string    `json:"State"`
+	Time    time.Time `json:"Time"`
+	Message string    `json:"Message,omitempty"`
+}
+
+// LedgerEntry is the record of a request submitted through the connector
+type LedgerEntry struct {
+	RequestID   string             `json:"RequestID"`
+	Operation   string             `json:"Operation"`
+	CommonName  string             `json:"CommonName,omitempty"`
+	Zone        string             `json:"Zone,omitempty"`
+	TppURL      string             `json:"TppURL"`
+	Caller      string             `json:"Caller,omitempty"` // Snowflake user of the call, when Snowflake sends it
+	CallerRole  string             `json:"CallerRole,omitempty"`
+	QueryID     string             `json:"QueryID,omitempty"` // Snowflake query which submitted the request
+	State       string             `json:"State"`
+	Message     string             `json:"Message,omitempty"` // reason of the last transition
+	Created     time.Time          `json:"Created"`
+	Updated     time.Time          `json:"Updated"`
+	Transitions []LedgerTransition `json:"Transitions"`
+}
+
+type RequestStatusResponse struct {
+	LedgerEntry
+	Tracked   bool             `json:"Tracked"` // false when the request was not submitted through the connector
+	Approvals []ApprovalTicket `json:"Approvals,omitempty"`
+}
+
+type ListRequestsResponse struct {
+	Requests []LedgerEntry `json:"Requests"`
+}
+
+// ledgerID identifies a request in the ledger, TPP DNs are case insensitive
+func ledgerID(requestID string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(unescapeDN(requestID))))
+	return hex.EncodeToString(sum[:])
+}
+
+func ledgerEntryKey(id string) string {
+	return LEDGER_REQUESTS_PREFIX + id + ".json"
+}
+
+func ledgerIndexKey(state string, id string) string {
+	return LEDGER_INDEX_PREFIX + state + "/" + id
+}
+
+// readLedgerEntry returns nil when the request is not in the ledger
+func readLedgerEntry(requestID string) (*LedgerEntry, error) {
+	data, err := requestLedger.read(ledgerEntryKey(ledgerID(requestID)))
+	if err == errBucketFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := &LedgerEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, fmt.Errorf("invalid ledger entry of %s: %v", requestID, err)
+	}
+	return entry, nil
+}
+
+// writeLedgerEntry writes the entry and moves its index marker when the state changed
+func writeLedgerEntry(entry *LedgerEntry, previousState string) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	id := ledgerID(entry.RequestID)
+	if err := requestLedger.write(ledgerEntryKey(id), data); err != nil {
+		return err
+	}
+	if previousState == entry.State {
+		return nil
+	}
+	if err := requestLedger.write(ledgerIndexKey(entry.State, id), []byte{}); err != nil {
+		return err
+	}
+	if previousState != "" {
+		return requestLedger.delete(ledgerIndexKey(previousState, id))
+	}
+	return nil
+}
+
+// transition records the new state of the entry, it returns false when nothing changed
+func (entry *LedgerEntry) transition(state string, message string, now time.Time) bool {
+	if entry.State == state && entry.Message == message {
+		return false
+	}
+	entry.State, entry.Message, entry.Updated = state, message, now
+	entry.Transitions = append(entry.Transitions, LedgerTransition{State: state, Time: now, Message: message})
+	return true
+}
+
+// retrievalState maps the result of retrieveCertificate to a ledger state
+func retrievalState(approvals []ApprovalTicket, err error) (string, string) {
+	switch {
+	case err != nil && isRetrievePending(err):
+		return LEDGER_STATE_PENDING, err.Error()
+	case err != nil:
+		return LEDGER_STATE_FAILED, err.Error()
+	case approvals != nil:
+		return LEDGER_STATE_AWAITING_APPROVAL, ""
+	}
+	return LEDGER_STATE_ISSUED, ""
+}
+
+// recordRequest adds a submitted request to the ledger. Ledger failures are only logged, they do not fail the request.
+func (c *venafiConnector) recordRequest(operation string, requestID string, commonName string) {
+	now := time.Now().UTC()
+	entry := &LedgerEntry{
+		RequestID:  unescapeDN(requestID),
+		Operation:  operation,
+		CommonName: commonName,
+		Zone:       c.zone,
+		TppURL:     c.tppURL,
+		Caller:     c.requestContext.User,
+		CallerRole: c.requestContext.Role,
+		QueryID:    c.requestContext.QueryID,
+		Created:    now,
+	}
+	entry.transition(LEDGER_STATE_PENDING, "", now)
+	if err := writeLedgerEntry(entry, ""); err != nil {
+		log.Errorf("Failed to record request %s in the ledger: %v", requestID, err)
+	}
+}
+
+// recordRetrieval updates the state of a request in the ledger after its certificate was retrieved
+func (c *venafiConnector) recordRetrieval(requestID string, approvals []ApprovalTicket, retrieveErr error) {
+	entry, err := readLedgerEntry(requestID)
+	if err != nil {
+		log.Errorf("Failed to read request %s from the ledger: %v", requestID, err)
+		return
+	}
+	if entry == nil {
+		return // not submitted through the connector
+	}
+	previousState := entry.State
+	state, message := retrievalState(approvals, retrieveErr)
+	if !entry.transition(state, message, time.Now().UTC()) {
+		return
+	}
+	if err := writeLedgerEntry(entry, previousState); err != nil {
+		log.Errorf("Failed to update request %s in the ledger: %v", requestID, err)
+	}
+}
+
+// GetRequestStatus returns the ledger entry of the request with its state refreshed from TPP
+func (c *venafiConnector) GetRequestStatus(ctx context.Context, requestID string) (string, error) {
+	defer c.audited(GET_REQUEST_STATUS_TYPE, requestID)()
+	if err := c.authorize(GET_REQUEST_STATUS_TYPE, requestID); err != nil {
+		return c.fail(err), nil
+	}
+	entry, err := readLedgerEntry(requestID)
+	if err != nil {
+		log.Errorf("Failed to read request %s from the ledger: %v", requestID, err)
+		return c.fail(err), nil
+	}
+	response := RequestStatusResponse{Tracked: entry != nil}
+	if entry == nil {
+		entry = &LedgerEntry{RequestID: unescapeDN(requestID), TppURL: c.tppURL}
+	}
+	previousState := entry.State
+	_, approvals, retrieveErr := c.retrieveCertificate(&certificate.Request{PickupID: requestID}, 0)
+	state, message := retrievalState(approvals, retrieveErr)
+	if entry.transition(state, message, time.Now().UTC()) && response.Tracked {
+		if err := writeLedgerEntry(entry, previousState); err != nil {
+			log.Errorf("Failed to update request %s in the ledger: %v", requestID, err)
+		}
+	}
+	response.LedgerEntry, response.Approvals = *entry, approvals
+	data, err := json.Marshal(response)
+	if err != nil {
+		return c.fail(err), err
+	}
+	log.Infof("Request %s is %s", requestID, entry.State)
+	return createSnowflakeResponseWithEscape(data), nil
+}
+
+// ListRequests returns the requests of the TPP server in the state, or the pending, awaiting approval and failed ones
+func (c *venafiConnector) ListRequests(ctx context.Context, state string) (string, error) {
+	defer c.audited(LIST_REQUESTS_TYPE, "")()
+	// the ledger is not filtered by zone, so only rules without zones allow listing it
+	if err := c.authorize(LIST_REQUESTS_TYPE, ""); err != nil {
+		return c.fail(err), nil
+	}
+	states := []string{LEDGER_STATE_PENDING, LEDGER_STATE_AWAITING_APPROVAL, LEDGER_STATE_FAILED}
+	if state = strings.ToLower(strings.TrimSpace(state)); state != "" {
+		if !isLedgerState(state) {
+			err := newConnectorError(ERR_INVALID_REQUEST_STATE, "unknown request state %s, use one of: %s", state, strings.Join(ledgerStates, ", "))
+			return c.fail(err), nil
+		}
+		states = []string{state}
+	}
+	response := ListRequestsResponse{Requests: []LedgerEntry{}}
+	for _, state := range states {
+		keys, err := requestLedger.list(LEDGER_INDEX_PREFIX + state + "/")
+		if err != nil {
+			log.Errorf("Failed to list the ledger: %v", err)
+			return c.fail(err), nil
+		}
+		for _, key := range keys {
+			data, err := requestLedger.read(ledgerEntryKey(path.Base(key)))
+			if err == errBucketFileNotFound {
+				continue
+			}
+			if err != nil {
+				log.Errorf("Failed to read the ledger: %v", err)
+				return c.fail(err), nil
+			}
+			entry := LedgerEntry{}
+			if err := json.Unmarshal(data, &entry); err != nil {
+				log.Errorf("Invalid ledger entry %s: %v", key, err)
+				continue
+			}
+			// markers of an interrupted state change are skipped
+			if entry.State == state && entry.TppURL == c.tppURL {
+				response.Requests = append(response.Requests, entry)
+			}
+		}
+	}
+	sort.Slice(response.Requests, func(i, j int) bool {
+		return response.Requests[i].Created.Before(response.Requests[j].Created)
+	})
+	data, err := json.Marshal(response)
+	if err != nil {
+		return c.fail(err), err
+	}
+	return createSnowflakeResponseWithEscape(data), nil
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryBucket replaces the deployment bucket in the tests
+type memoryBucket map[string][]byte
+
+func (m memoryBucket) read(key string) ([]byte, error) {
+	data, ok := m[key]
+	if !ok {
+		return nil, errBucketFileNotFound
+	}
+	return data, nil
+}
+
+func (m memoryBucket) write(key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+func (m memoryBucket) delete(key string) error {
+	delete(m, key)
+	return nil
+}
+
+func (m memoryBucket) list(prefix string) ([]string, error) {
+	keys := []string{}
+	for key := range m {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// useMemoryLedger replaces the bucket of the ledger for the test
+func useMemoryLedger(t *testing.T) memoryBucket {
+	storage := memoryBucket{}
+	requestLedger = storage
+	t.Cleanup(func() { requestLedger = deploymentBucket{} })
+	return storage
+}
+
+func TestLedgerStateTransitions(t *testing.T) {
+	storage := useMemoryLedger(t)
+	c := &venafiConnector{zone: "\\VED\\Policy\\Web", tppURL: "https://tpp.example.com", requestContext: RequestContext{User: "ANALYST"}}
+
+	c.recordRequest(REQUEST_MID_TYPE, "\\\\VED\\\\Policy\\\\Web\\\\app", "app")
+	id := ledgerID("\\VED\\Policy\\Web\\app")
+	assert.Contains(t, storage, ledgerIndexKey(LEDGER_STATE_PENDING, id))
+
+	c.recordRetrieval("\\\\VED\\\\Policy\\\\Web\\\\app", []ApprovalTicket{{Ticket: "{web}"}}, nil)
+	assert.NotContains(t, storage, ledgerIndexKey(LEDGER_STATE_PENDING, id))
+	assert.Contains(t, storage, ledgerIndexKey(LEDGER_STATE_AWAITING_APPROVAL, id))
+
+	c.recordRetrieval("\\\\VED\\\\Policy\\\\Web\\\\app", nil, nil)
+	entry, err := readLedgerEntry("\\VED\\Policy\\Web\\APP")
+	assert.Nil(t, err)
+	assert.Equal(t, LEDGER_STATE_ISSUED, entry.State)
+	assert.Equal(t, "ANALYST", entry.Caller)
+	assert.Equal(t, "\\VED\\Policy\\Web\\app", entry.RequestID)
+	assert.Len(t, entry.Transitions, 3)
+
+	// requests which were not submitted through the connector are not recorded
+	c.recordRetrieval("\\VED\\Policy\\Web\\other", nil, nil)
+	assert.Len(t, storage, 2)
+}
+
+func TestGetRequestStatus(t *testing.T) {
+	useMemoryLedger(t)
+	c := &venafiConnector{
+		client: &retrieveConnector{err: fmt.Errorf("unable to retrieve: enrollment failed")},
+		tppURL: "https://tpp.example.com",
+	}
+	c.recordRequest(REQUEST_MID_TYPE, "\\\\VED\\\\Policy\\\\Web\\\\app", "app")
+
+	response, err := c.GetRequestStatus(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app")
+	assert.Nil(t, err)
+	assert.Contains(t, response, `"State":"failed"`)
+	assert.Contains(t, response, `"Tracked":true`)
+
+	c.client = &retrieveConnector{err: endpoint.ErrCertificatePending{CertificateID: "\\VED\\Policy\\Web\\other"}}
+	response, err = c.GetRequestStatus(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\other")
+	assert.Nil(t, err)
+	assert.Contains(t, response, `"State":"pending"`)
+	assert.Contains(t, response, `"Tracked":false`)
+}
+
+func TestListRequests(t *testing.T) {
+	useMemoryLedger(t)
+	c := &venafiConnector{tppURL: "https://tpp.example.com"}
+	c.recordRequest(REQUEST_MID_TYPE, "\\VED\\Policy\\Web\\pending", "pending")
+	c.recordRequest(REQUEST_MID_TYPE, "\\VED\\Policy\\Web\\failed", "failed")
+	c.recordRetrieval("\\VED\\Policy\\Web\\failed", nil, fmt.Errorf("enrollment failed"))
+	c.recordRequest(REQUEST_MID_TYPE, "\\VED\\Policy\\Web\\issued", "issued")
+	c.recordRetrieval("\\VED\\Policy\\Web\\issued", nil, nil)
+	other := &venafiConnector{tppURL: "https://other.example.com"}
+	other.recordRequest(REQUEST_MID_TYPE, "\\VED\\Policy\\Web\\other", "other")
+
+	response, err := c.ListRequests(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Contains(t, response, `"CommonName":"pending"`)
+	assert.Contains(t, response, `"CommonName":"failed"`)
+	assert.NotContains(t, response, `"CommonName":"issued"`)
+	assert.NotContains(t, response, `"CommonName":"other"`)
+
+	response, err = c.ListRequests(context.Background(), "Failed")
+	assert.Nil(t, err)
+	assert.NotContains(t, response, `"CommonName":"pending"`)
+	assert.Contains(t, response, `"CommonName":"failed"`)
+
+	response, err = c.ListRequests(context.Background(), "lost")
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_INVALID_REQUEST_STATE)
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	log "github.com/palette-software/go-log-targets"
+)
+
+// DnToGuid result code of an existing object
+const tppDnToGuidSuccess = 1
+
+type tppDnToGuidResponse struct {
+	GUID   string `json:"GUID"`
+	Result int    `json:"Result"`
+}
+
+type tppSuccessResponse struct {
+	Success bool   `json:"Success"`
+	Error   string `json:"Error"`
+}
+
+type tppResetResponse struct {
+	ProcessingResetCompleted bool   `json:"ProcessingResetCompleted"`
+	RestartCompleted         bool   `json:"RestartCompleted"`
+	Error                    string `json:"Error"`
+}
+
+// ResetMachineIDResponse is the response of RESET_MACHINE_ID
+type ResetMachineIDResponse struct {
+	DN                       string `json:"DN"`
+	ProcessingResetCompleted bool   `json:"ProcessingResetCompleted"`
+	RestartCompleted         bool   `json:"RestartCompleted"` // the enrollment was restarted, the certificate can be retrieved with GET_MACHINE_ID
+}
+
+// unescapeDN reverts the escaping of backslashes done when the request ID is read from the Snowflake parameters
+func unescapeDN(certificateDN string) string {
+	return strings.ReplaceAll(certificateDN, "\\\\", "\\")
+}
+
+// requireConfirmation rejects destructive operations called without confirm set to true
+func requireConfirmation(operation string, confirm bool) error {
+	if !confirm {
+		return newConnectorError(ERR_CONFIRMATION_REQUIRED, "%s can not be undone, call it with confirm set to true", operation)
+	}
+	return nil
+}
+
+// certificateGUID returns the GUID of the certificate object, the WebSDK identifies certificates by GUID
+func (c *venafiConnector) certificateGUID(certificateDN string) (string, error) {
+	tppResponse := &tppDnToGuidResponse{}
+	if err := c.api.post("Config/DnToGuid", map[string]string{"ObjectDN": certificateDN}, tppResponse); err != nil {
+		return "", err
+	}
+	if tppResponse.Result != tppDnToGuidSuccess || tppResponse.GUID == "" {
+		return "", fmt.Errorf("certificate %s does not exist", certificateDN)
+	}
+	return tppResponse.GUID, nil
+}
+
+// RetireMachineID disables the certificate object, TPP stops monitoring and renewing it but keeps its history
+func (c *venafiConnector) RetireMachineID(ctx context.Context, requestID string, confirm bool) (string, error) {
+	defer c.audited(RETIRE_MID_TYPE, requestID)()
+	if err := requireConfirmation("retiring a certificate", confirm); err != nil {
+		return c.fail(err), nil
+	}
+	certificateDN := unescapeDN(requestID)
+	if err := c.authorize(RETIRE_MID_TYPE, certificateDN); err != nil {
+		return c.fail(err), nil
+	}
+	if err := enforceOperationGuardrails(RETIRE_MID_TYPE, certificateDN); err != nil {
+		log.Errorf("Retirement rejected by guardrail policy: %v", err)
+		return c.fail(err), nil
+	}
+	guid, err := c.certificateGUID(certificateDN)
+	if err != nil {
+		log.Errorf("Failed to find certificate to retire: %v", err)
+		return c.fail(err), nil
+	}
+	body := map[string]interface{}{
+		"AttributeData": []map[string]interface{}{{"Name": "Disabled", "Value": []string{"1"}}},
+	}
+	tppResponse := &tppSuccessResponse{}
+	if err := c.api.put("Certificates/"+url.PathEscape(guid), body, tppResponse); err != nil {
+		log.Errorf("Failed to retire certificate: %v", err)
+		return c.fail(err), nil
+	}
+	if !tppResponse.Success {
+		log.Errorf("Failed to retire certificate: %s", tppResponse.Error)
+		return c.fail(fmt.Errorf("failed to retire certificate %s: %s", certificateDN, tppResponse.Error)), nil
+	}
+	log.Infof("Retired certificate %s", certificateDN)
+	return createSnowflakeResponse(requestID), nil
+}
+
+// DeleteMachineID deletes the certificate object with its history from TPP
+func (c *venafiConnector) DeleteMachineID(ctx context.Context, requestID string, confirm bool) (string, error) {
+	defer c.audited(DELETE_MID_TYPE, requestID)()
+	if err := requireConfirmation("deleting a certificate", confirm); err != nil {
+		return c.fail(err), nil
+	}
+	certificateDN := unescapeDN(requestID)
+	if err := c.authorize(DELETE_MID_TYPE, certificateDN); err != nil {
+		return c.fail(err), nil
+	}
+	if err := enforceOperationGuardrails(DELETE_MID_TYPE, certificateDN); err != nil {
+		log.Errorf("Deletion rejected by guardrail policy: %v", err)
+		return c.fail(err), nil
+	}
+	guid, err := c.certificateGUID(certificateDN)
+	if err != nil {
+		log.Errorf("Failed to find certificate to delete: %v", err)
+		return c.fail(err), nil
+	}
+	tppResponse := &tppSuccessResponse{}
+	if err := c.api.delete("Certificates/"+url.PathEscape(guid), tppResponse); err != nil {
+		log.Errorf("Failed to delete certificate: %v", err)
+		return c.fail(err), nil
+	}
+	if !tppResponse.Success {
+		log.Errorf("Failed to delete certificate: %s", tppResponse.Error)
+		return c.fail(fmt.Errorf("failed to delete certificate %s: %s", certificateDN, tppResponse.Error)), nil
+	}
+	log.Infof("Deleted certificate %s", certificateDN)
+	return createSnowflakeResponse(requestID), nil
+}
+
+// ResetMachineID clears the error state of a failed enrollment, so the certificate can be requested or renewed again
+func (c *venafiConnector) ResetMachineID(ctx context.Context, requestID string, restart bool) (string, error) {
+	defer c.audited(RESET_MID_TYPE, requestID)()
+	certificateDN := unescapeDN(requestID)
+	if err := c.authorize(RESET_MID_TYPE, certificateDN); err != nil {
+		return c.fail(err), nil
+	}
+	tppResponse := &tppResetResponse{}
+	if err := c.api.post("Certificates/Reset", map[string]interface{}{"CertificateDN": certificateDN, "Restart": restart}, tppResponse); err != nil {
+		log.Errorf("Failed to reset certificate: %v", err)
+		return c.fail(err), nil
+	}
+	if tppResponse.Error != "" {
+		log.Errorf("Failed to reset certificate: %s", tppResponse.Error)
+		return c.fail(fmt.Errorf("failed to reset certificate %s: %s", certificateDN, tppResponse.Error)), nil
+	}
+	data, err := json.Marshal(ResetMachineIDResponse{
+		DN:                       certificateDN,
+		ProcessingResetCompleted: tppResponse.ProcessingResetCompleted,
+		RestartCompleted:         tppResponse.RestartCompleted,
+	})
+	if err != nil {
+		return c.fail(err), err
+	}
+	log.Infof("Reset certificate %s, restarted: %t", certificateDN, tppResponse.RestartCompleted)
+	return createSnowflakeResponseWithEscape(data), nil
+}
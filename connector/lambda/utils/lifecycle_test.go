@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newLifecycleTestServer(t *testing.T, calls *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls = append(*calls, r.Method+" "+r.URL.Path)
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case "/vedsdk/Config/DnToGuid":
+			assert.Equal(t, "\\VED\\Policy\\Web\\app", body["ObjectDN"])
+			w.Write([]byte(`{"GUID": "{1234}", "Result": 1}`))
+		case "/vedsdk/Certificates/{1234}":
+			if r.Method == "PUT" {
+				assert.Contains(t, body["AttributeData"], map[string]interface{}{"Name": "Disabled", "Value": []interface{}{"1"}})
+			}
+			w.Write([]byte(`{"Success": true}`))
+		case "/vedsdk/Certificates/Reset":
+			assert.Equal(t, "\\VED\\Policy\\Web\\app", body["CertificateDN"])
+			assert.Equal(t, true, body["Restart"])
+			w.Write([]byte(`{"ProcessingResetCompleted": true, "RestartCompleted": true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestRetireAndDeleteMachineID(t *testing.T) {
+	calls := []string{}
+	server := newLifecycleTestServer(t, &calls)
+	defer server.Close()
+	c := &venafiConnector{api: newTPPAPIClient(server.URL, "test-token", server.Client())}
+	// no guardrail policy in the bucket
+	guardrailCache.policy, guardrailCache.loadedAt = nil, time.Now()
+	defer func() { guardrailCache.loadedAt = time.Time{} }()
+
+	// retirements and deletions are denied without an authorization policy
+	response, err := c.DeleteMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", true)
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_NOT_AUTHORIZED)
+	assert.Empty(t, calls)
+	useAuthorizationPolicy(t, testAuthorizationPolicy)
+	c.requestContext = RequestContext{User: "EVE", Role: "SECURITY_ADMIN", Account: "XY12345"}
+
+	// request ids arrive with escaped backslashes from Snowflake
+	response, err = c.RetireMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", true)
+	assert.Nil(t, err)
+	assert.Contains(t, response, "app")
+	response, err = c.DeleteMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", true)
+	assert.Nil(t, err)
+	assert.Contains(t, response, "app")
+	assert.Equal(t, []string{
+		"POST /vedsdk/Config/DnToGuid", "PUT /vedsdk/Certificates/{1234}",
+		"POST /vedsdk/Config/DnToGuid", "DELETE /vedsdk/Certificates/{1234}",
+	}, calls)
+}
+
+func TestDestructiveOperationsRequireConfirmation(t *testing.T) {
+	calls := []string{}
+	server := newLifecycleTestServer(t, &calls)
+	defer server.Close()
+	c := &venafiConnector{api: newTPPAPIClient(server.URL, "test-token", server.Client())}
+
+	response, err := c.RetireMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", false)
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_CONFIRMATION_REQUIRED)
+	response, err = c.DeleteMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", false)
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_CONFIRMATION_REQUIRED)
+	assert.Empty(t, calls)
+}
+
+func TestResetMachineID(t *testing.T) {
+	calls := []string{}
+	server := newLifecycleTestServer(t, &calls)
+	defer server.Close()
+	c := &venafiConnector{api: newTPPAPIClient(server.URL, "test-token", server.Client())}
+
+	response, err := c.ResetMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", true)
+	assert.Nil(t, err)
+	assert.Contains(t, response, `"RestartCompleted":true`)
+}
@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	log "github.com/palette-software/go-log-targets"
+)
+
+const DEFAULT_LIST_LIMIT = 500
+const MAX_LIST_LIMIT = 5000
+const INVENTORY_EXPIRING_DAYS = 30 // certificates expiring sooner have the expiring status in the inventory
+
+// Status of a certificate in the inventory
+const INVENTORY_STATUS_VALID = "valid"
+const INVENTORY_STATUS_EXPIRING = "expiring"
+const INVENTORY_STATUS_EXPIRED = "expired"
+
+// ListOptions are the keys of the options object of LIST_MACHINE_IDS
+type ListOptions struct {
+	Filter             endpoint.Filter
+	Offset             int
+	ExpiringWithinDays int  // 0 means no expiry window
+	Inventory          bool // return one flat row per certificate
+}
+
+// InventoryRow is a certificate of the inventory with the stable columns of MACHINE_ID_INVENTORY
+type InventoryRow struct {
+	DN         string    `json:"DN"`
+	CN         string    `json:"CN"`
+	Serial     string    `json:"Serial"`
+	Thumbprint string    `json:"Thumbprint"`
+	ValidFrom  time.Time `json:"ValidFrom"`
+	ValidTo    time.Time `json:"ValidTo"`
+	SANs       []string  `json:"SANs"`
+	Zone       string    `json:"Zone"`
+	Status     string    `json:"Status"`
+}
+
+type ListMachineIDsResponse struct {
+	TotalCount   int         `json:"TotalCount"`
+	Limit        int         `json:"Limit"`
+	Offset       int         `json:"Offset"`
+	NextOffset   *int        `json:"NextOffset"`   // null on the last page
+	Certificates interface{} `json:"Certificates"` // []certificate.CertificateInfo or []InventoryRow
+}
+
+func optionToInt(options map[string]interface{}, key string, defaultValue int) (int, error) {
+	value, ok := options[key]
+	if !ok || value == nil {
+		return defaultValue, nil
+	}
+	number, err := strconv.Atoi(criteriaValueToStr(value))
+	if err != nil || number < 0 {
+		return 0, newConnectorError(ERR_INVALID_LIST_OPTIONS, "%s must be a non-negative number", key)
+	}
+	return number, nil
+}
+
+func optionToBool(options map[string]interface{}, key string) (bool, error) {
+	value, ok := options[key]
+	if !ok || value == nil {
+		return false, nil
+	}
+	result, err := strconv.ParseBool(criteriaValueToStr(value))
+	if err != nil {
+		return false, newConnectorError(ERR_INVALID_LIST_OPTIONS, "%s must be true or false", key)
+	}
+	return result, nil
+}
+
+// parseListOptions reads limit, offset, expiring_within_days and include_expired from the options object
+func parseListOptions(options map[string]interface{}) (ListOptions, error) {
+	for key := range options {
+		switch key {
+		case "limit", "offset", "expiring_within_days", "include_expired", "inventory":
+		default:
+			return ListOptions{}, newConnectorError(ERR_INVALID_LIST_OPTIONS, "unknown list option: %s", key)
+		}
+	}
+	limit, err := optionToInt(options, "limit", DEFAULT_LIST_LIMIT)
+	if err != nil {
+		return ListOptions{}, err
+	}
+	if limit == 0 || limit > MAX_LIST_LIMIT {
+		return ListOptions{}, newConnectorError(ERR_INVALID_LIST_OPTIONS, "limit must be between 1 and %d", MAX_LIST_LIMIT)
+	}
+	offset, err := optionToInt(options, "offset", 0)
+	if err != nil {
+		return ListOptions{}, err
+	}
+	expiringWithinDays, err := optionToInt(options, "expiring_within_days", 0)
+	if err != nil {
+		return ListOptions{}, err
+	}
+	withExpired, err := optionToBool(options, "include_expired")
+	if err != nil {
+		return ListOptions{}, err
+	}
+	inventory, err := optionToBool(options, "inventory")
+	if err != nil {
+		return ListOptions{}, err
+	}
+	return ListOptions{
+		Filter:             endpoint.Filter{Limit: &limit, WithExpired: withExpired},
+		Offset:             offset,
+		ExpiringWithinDays: expiringWithinDays,
+		Inventory:          inventory,
+	}, nil
+}
+
+// buildListQuery creates the TPP certificate list query of a page, with the same parameters vcert's
+// ListCertificates sends for its batches
+func buildListQuery(zone string, options ListOptions, now time.Time) url.Values {
+	query := url.Values{}
+	query.Set("ParentDnRecursive", getPolicyDN(zone))
+	query.Set("Limit", strconv.Itoa(*options.Filter.Limit))
+	query.Set("Offset", strconv.Itoa(options.Offset))
+	if !options.Filter.WithExpired {
+		query.Set("ValidToGreater", now.UTC().Format(time.RFC3339))
+	}
+	if options.ExpiringWithinDays > 0 {
+		query.Set("ValidToLess", now.UTC().AddDate(0, 0, options.ExpiringWithinDays).Format(time.RFC3339))
+	}
+	return query
+}
+
+func inventoryStatus(validTo time.Time, now time.Time) string {
+	if validTo.Before(now) {
+		return INVENTORY_STATUS_EXPIRED
+	}
+	if validTo.Before(now.AddDate(0, 0, INVENTORY_EXPIRING_DAYS)) {
+		return INVENTORY_STATUS_EXPIRING
+	}
+	return INVENTORY_STATUS_VALID
+}
+
+func toInventoryRow(dn string, parentDN string, info certificate.CertificateInfo, now time.Time) InventoryRow {
+	sans := []string{}
+	for _, values := range [][]string{info.SANS.DNS, info.SANS.IP, info.SANS.Email, info.SANS.URI, info.SANS.UPN} {
+		sans = append(sans, values...)
+	}
+	return InventoryRow{
+		DN:         dn,
+		CN:         info.CN,
+		Serial:     info.Serial,
+		Thumbprint: info.Thumbprint,
+		ValidFrom:  info.ValidFrom,
+		ValidTo:    info.ValidTo,
+		SANs:       sans,
+		Zone:       parentDN,
+		Status:     inventoryStatus(info.ValidTo, now),
+	}
+}
+
+func toListMachineIDsResponse(tppResponse *tppListResponse, options ListOptions, now time.Time) ListMachineIDsResponse {
+	response := ListMachineIDsResponse{
+		TotalCount: tppResponse.TotalCount,
+		Limit:      *options.Filter.Limit,
+		Offset:     options.Offset,
+	}
+	if options.Inventory {
+		rows := make([]InventoryRow, len(tppResponse.Certificates))
+		for i, cert := range tppResponse.Certificates {
+			rows[i] = toInventoryRow(cert.DN, cert.ParentDn, cert.X509, now)
+		}
+		response.Certificates = rows
+	} else {
+		infos := make([]certificate.CertificateInfo, len(tppResponse.Certificates))
+		for i, cert := range tppResponse.Certificates {
+			cert.X509.ID = cert.DN
+			infos[i] = cert.X509
+		}
+		response.Certificates = infos
+	}
+	if next := options.Offset + len(tppResponse.Certificates); len(tppResponse.Certificates) > 0 && next < tppResponse.TotalCount {
+		response.NextOffset = &next
+	}
+	return response
+}
+
+type tppListCertificate struct {
+	DN       string
+	ParentDn string
+	X509     certificate.CertificateInfo
+}
+
+// tppListResponse is the response of GET Certificates/ of the WebSDK in the shape vcert reads it
+type tppListResponse struct {
+	Certificates []tppListCertificate
+	TotalCount   int
+}
+
+// listMachineIDsPage returns a page of the certificates in the zone with the total count. It reads the page from
+// the WebSDK, vcert's ListCertificates has no offset, no expiry window and drops the TotalCount and ParentDn of the
+// response, so it can only read a zone from its first certificate.
+func (c *venafiConnector) listMachineIDsPage(options map[string]interface{}) (string, error) {
+	listOptions, err := parseListOptions(options)
+	if err != nil {
+		log.Errorf("Invalid list options: %v", err)
+		return c.fail(err), nil
+	}
+	if c.zone == "" {
+		return c.fail(newConnectorError(ERR_INVALID_LIST_OPTIONS, "zone is required")), nil
+	}
+	now := time.Now()
+	tppResponse := &tppListResponse{}
+	err = c.api.get("Certificates/", buildListQuery(c.zone, listOptions, now), tppResponse)
+	if err != nil {
+		log.Errorf("Failed to list certificates: %v", err)
+		return c.fail(err), nil
+	}
+	data, err := json.Marshal(toListMachineIDsResponse(tppResponse, listOptions, now))
+	if err != nil {
+		log.Errorf("Failed to serialize certificate: %v", err)
+		return c.fail(err), err
+	}
+	log.Infof("Listed %d of %d certificates from offset %d", len(tppResponse.Certificates), tppResponse.TotalCount, listOptions.Offset)
+	return createSnowflakeResponseWithEscape(data), nil
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseListOptions(t *testing.T) {
+	options, err := parseListOptions(map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, DEFAULT_LIST_LIMIT, *options.Filter.Limit)
+	assert.False(t, options.Filter.WithExpired)
+
+	options, err = parseListOptions(map[string]interface{}{"limit": float64(50), "offset": float64(100), "expiring_within_days": "30", "include_expired": true})
+	assert.Nil(t, err)
+	assert.Equal(t, 50, *options.Filter.Limit)
+	assert.Equal(t, 100, options.Offset)
+	assert.Equal(t, 30, options.ExpiringWithinDays)
+	assert.True(t, options.Filter.WithExpired)
+
+	_, err = parseListOptions(map[string]interface{}{"limit": float64(MAX_LIST_LIMIT + 1)})
+	assert.Equal(t, ERR_INVALID_LIST_OPTIONS, err.(*ConnectorError).Code)
+	_, err = parseListOptions(map[string]interface{}{"page": float64(2)})
+	assert.Equal(t, ERR_INVALID_LIST_OPTIONS, err.(*ConnectorError).Code)
+}
+
+func TestBuildListQuery(t *testing.T) {
+	options, _ := parseListOptions(map[string]interface{}{"limit": float64(10), "expiring_within_days": float64(30)})
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	query := buildListQuery("Web", options, now)
+	assert.Equal(t, "\\VED\\Policy\\Web", query.Get("ParentDnRecursive"))
+	assert.Equal(t, "10", query.Get("Limit"))
+	assert.Equal(t, "0", query.Get("Offset"))
+	assert.Equal(t, "2022-01-01T00:00:00Z", query.Get("ValidToGreater"))
+	assert.Equal(t, "2022-01-31T00:00:00Z", query.Get("ValidToLess"))
+}
+
+func TestListMachineIDsPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "2", r.URL.Query().Get("Offset"))
+		w.Write([]byte(`{"Certificates": [{"DN": "\\VED\\Policy\\Web\\c", "X509": {"CN": "c"}}, {"DN": "\\VED\\Policy\\Web\\d", "X509": {"CN": "d"}}], "TotalCount": 5}`))
+	}))
+	defer server.Close()
+
+	c := &venafiConnector{api: newTPPAPIClient(server.URL, "test-token", server.Client()), zone: "\\VED\\Policy\\Web"}
+	response, err := c.ListMachineIDs(context.Background(), map[string]interface{}{"limit": float64(2), "offset": float64(2)})
+	assert.Nil(t, err)
+	assert.Contains(t, response, `"TotalCount":5,"Limit":2,"Offset":2,"NextOffset":4`)
+	assert.Contains(t, response, `"ID":"\\\\VED\\\\Policy\\\\Web\\\\c"`)
+
+	response, err = (&venafiConnector{}).ListMachineIDs(context.Background(), map[string]interface{}{"limit": float64(2)})
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_INVALID_LIST_OPTIONS)
+}
+
+func TestListMachineIDsInventory(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tppResponse := &tppListResponse{TotalCount: 3, Certificates: []tppListCertificate{
+		{DN: "\\VED\\Policy\\Web\\a", ParentDn: "\\VED\\Policy\\Web", X509: certificate.CertificateInfo{CN: "a", ValidTo: now.AddDate(0, 0, 10)}},
+	}}
+	tppResponse.Certificates[0].X509.SANS.DNS = []string{"a.example.com"}
+	tppResponse.Certificates[0].X509.SANS.IP = []string{"10.0.0.1"}
+
+	options, _ := parseListOptions(map[string]interface{}{"inventory": true})
+	rows := toListMachineIDsResponse(tppResponse, options, now).Certificates.([]InventoryRow)
+	assert.Equal(t, "\\VED\\Policy\\Web", rows[0].Zone)
+	assert.Equal(t, []string{"a.example.com", "10.0.0.1"}, rows[0].SANs)
+	assert.Equal(t, INVENTORY_STATUS_EXPIRING, rows[0].Status)
+
+	assert.Equal(t, INVENTORY_STATUS_EXPIRED, inventoryStatus(now.AddDate(0, 0, -1), now))
+	assert.Equal(t, INVENTORY_STATUS_VALID, inventoryStatus(now.AddDate(1, 0, 0), now))
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	stdlog "log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	log "github.com/palette-software/go-log-targets"
+)
+
+// LOG_LEVEL is debug, info, warning or error, set by the installer
+const DEFAULT_LOG_LEVEL = "info"
+const REDACTED = "[REDACTED]"
+
+var logLevels = map[string]log.LogLevel{
+	"debug":   log.LevelDebug,
+	"info":    log.LevelInfo,
+	"warning": log.LevelWarning,
+	"error":   log.LevelError,
+}
+
+// Names of the fields whose values are never logged
+const secretFieldNames = `access_?token|refresh_?token|token|passphrase|password|key_?password|client_?secret|private_?key`
+
+var redactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// PEM private keys, also when the end of the key was cut off
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----(?s:.*?)(?:-----END [A-Z ]*PRIVATE KEY-----|$)`), REDACTED},
+	// JSON fields, like "AccessToken":"..." or "Passphrase": "..."
+	{regexp.MustCompile(`(?i)("(?:` + secretFieldNames + `)"\s*:\s*)"(?:[^"\\]|\\.)*"`), `$1"` + REDACTED + `"`},
+	// form and query parameters and printed Go maps and structs, like refresh_token=... or AccessToken:...
+	{regexp.MustCompile(`(?i)\b((?:` + secretFieldNames + `)[=:])[^\s"&,;}\]]+`), `${1}` + REDACTED},
+	{regexp.MustCompile(`(?i)(bearer\s+)[a-z0-9\-._~+/]+=*`), `${1}` + REDACTED},
+	// the Data of a FormattedCertificate, a pkcs12 or jks keystore can hold the private key
+	{regexp.MustCompile(`("Data"\s*:\s*)"(?:[^"\\]|\\.)*"`), `$1"` + REDACTED + `"`},
+	{regexp.MustCompile(`\b(Data:)[A-Za-z0-9+/=]+`), `${1}` + REDACTED},
+}
+
+// redact removes private keys, tokens and passphrases from a log line
+func redact(line string) string {
+	for _, r := range redactions {
+		line = r.pattern.ReplaceAllString(line, r.replacement)
+	}
+	return line
+}
+
+// LogLine is the JSON object of a log line. The fields identify the invocation, so the lines of one row of a
+// Snowflake batch can be found with CloudWatch Logs Insights.
+type LogLine struct {
+	Time            time.Time `json:"time"`
+	Level           string    `json:"level"`
+	Message         string    `json:"message"`
+	LambdaRequestID string    `json:"lambdaRequestId,omitempty"`
+	Operation       string    `json:"operation,omitempty"` // Snowflake function name
+	QueryID         string    `json:"queryId,omitempty"`
+	BatchID         string    `json:"batchId,omitempty"`
+	Row             string    `json:"row,omitempty"`
+	TppURL          string    `json:"tppUrl,omitempty"`
+	DurationMs      int64     `json:"durationMs"` // time since the start of the invocation
+}
+
+// logContext holds the fields of the running invocation, a Lambda container runs one invocation at a time
+var logContext struct {
+	sync.Mutex
+	fields  LogLine
+	started time.Time
+}
+
+// startLogContext starts the log fields of a new invocation of the Lambda of the operation
+func startLogContext(ctx context.Context, operation string) {
+	logContext.Lock()
+	defer logContext.Unlock()
+	logContext.fields = LogLine{Operation: operationFunctionNames[operation]}
+	if lambdaContext, ok := lambdacontext.FromContext(ctx); ok {
+		logContext.fields.LambdaRequestID = lambdaContext.AwsRequestID
+	}
+	logContext.started = time.Now()
+}
+
+// setLogRequestContext adds the Snowflake query, batch and row of the call to the log fields
+func setLogRequestContext(requestContext RequestContext, tppURL string) {
+	logContext.Lock()
+	defer logContext.Unlock()
+	logContext.fields.QueryID = requestContext.QueryID
+	logContext.fields.BatchID = requestContext.BatchID
+	logContext.fields.Row = requestContext.Row
+	logContext.fields.TppURL = tppURL
+}
+
+// Prefix of the lines of the log library, like "INFO:    2021/06/15 10:04:05.123456 "
+var logPrefix = regexp.MustCompile(`^(DEBUG|INFO|WARNING|ERROR|FATAL):\s+\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}\.\d{6} `)
+
+// jsonLogWriter writes the lines of the log library as JSON objects with the fields of the invocation, with
+// secrets redacted. Every line is written with one call. Lines without level, like the ones vcert writes with
+// the standard log package, get the level of the writer.
+type jsonLogWriter struct {
+	out   io.Writer
+	level string
+}
+
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSuffix(string(p), "\n")
+	level := w.level
+	if match := logPrefix.FindStringSubmatch(message); match != nil {
+		level, message = match[1], message[len(match[0]):]
+	}
+	logContext.Lock()
+	line := logContext.fields
+	if !logContext.started.IsZero() {
+		line.DurationMs = time.Since(logContext.started).Milliseconds()
+	}
+	logContext.Unlock()
+	line.Time, line.Level, line.Message = time.Now().UTC(), level, redact(message)
+	data, err := json.Marshal(line)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// logLevel returns the level of LOG_LEVEL, and false when it is not a valid level
+func logLevel() (log.LogLevel, bool) {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv("LOG_LEVEL")))
+	if value == "" {
+		value = DEFAULT_LOG_LEVEL
+	}
+	level, ok := logLevels[value]
+	if !ok {
+		return logLevels[DEFAULT_LOG_LEVEL], false
+	}
+	return level, true
+}
+
+var initLogging sync.Once
+
+// InitLogging logs JSON lines to stdout at the level of LOG_LEVEL, with secrets redacted. Lambdas call it at the
+// start of every invocation with the operation they run, the target is only added once per container.
+func InitLogging(ctx context.Context, operation string) {
+	startLogContext(ctx, operation)
+	initLogging.Do(func() {
+		level, valid := logLevel()
+		log.AddTarget(&jsonLogWriter{out: os.Stdout}, level)
+		// vcert logs with the standard log package, its lines are redacted the same way
+		stdlog.SetFlags(0)
+		stdlog.SetOutput(&jsonLogWriter{out: os.Stdout, level: "INFO"})
+		if !valid {
+			log.Warningf("Invalid LOG_LEVEL %s, using %s", os.Getenv("LOG_LEVEL"), DEFAULT_LOG_LEVEL)
+		}
+	})
+}
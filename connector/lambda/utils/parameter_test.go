@@ -31,3 +31,161 @@ func TestParseSnowflakeParamsSuccessNoTLSType(t *testing.T) {
 	assert.Equal(t, "\\\\example\\\\requestID", requestParams.RequestID)
 	assert.Equal(t, "https://test-venafi-tpp-server-url.com", configParams.TppURL)
 }
+
+func TestParseSnowflakeParamsZonePolicy(t *testing.T) {
+	e := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/getzonepolicy",
+		Body:       `{"data": [[0,"TLS","prod-tpp",null]]}`,
+	}
+	configParams, _ := ParseSnowflakeParameters(e, ZONE_POLICY_TYPE)
+	assert.Equal(t, "prod-tpp", configParams.TppURL)
+	assert.Equal(t, "", configParams.Zone)
+}
+
+func TestParseSnowflakeParamsSearch(t *testing.T) {
+	e := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/searchmachineids",
+		Body:       `{"data": [[0,"TLS","prod-tpp","web-prod",{"cn": "example.com", "disabled": true}]]}`,
+	}
+	configParams, requestParams := ParseSnowflakeParameters(e, SEARCH_MID_TYPE)
+	assert.Equal(t, "web-prod", configParams.Zone)
+	assert.Equal(t, "example.com", requestParams.Criteria["cn"])
+	assert.Equal(t, true, requestParams.Criteria["disabled"])
+}
+
+func TestParseSnowflakeParamsListOptions(t *testing.T) {
+	e := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/listmachineids",
+		Body:       `{"data": [[0,"TLS","prod-tpp","web-prod"]]}`,
+	}
+	_, requestParams := ParseSnowflakeParameters(e, LIST_MID_TYPE)
+	assert.Nil(t, requestParams.Options)
+
+	e.Body = `{"data": [[0,"TLS","prod-tpp","web-prod",{"limit": 100, "offset": 200}]]}`
+	_, requestParams = ParseSnowflakeParameters(e, LIST_MID_TYPE)
+	assert.Equal(t, float64(200), requestParams.Options["offset"])
+}
+
+func TestParseSnowflakeParamsCertificateOptions(t *testing.T) {
+	e := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/requestmachineid",
+		Body:       `{"data": [[0,"TLS","prod-tpp",["app.example.com"],"web-prod",[],"app.example.com",{"chain_option": "root-first"}]]}`,
+	}
+	_, requestParams := ParseSnowflakeParameters(e, REQUEST_MID_TYPE)
+	assert.Equal(t, "app.example.com", requestParams.CommonName)
+	assert.Equal(t, "root-first", requestParams.Options["chain_option"])
+}
+
+func TestParseSnowflakeParamsRevocationOptions(t *testing.T) {
+	e := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/revokemachineid",
+		Body:       `{"data": [[0,"TLS","prod-tpp",null,true,{"reason": "superseded", "thumbprint": "A1B2C3D4E5F6A7B8C9D0E1F2A3B4C5D6E7F8A9B0"}]]}`,
+	}
+	_, requestParams := ParseSnowflakeParameters(e, REVOKE_MID_TYPE)
+	assert.Equal(t, "", requestParams.RequestID)
+	assert.True(t, requestParams.Disable)
+	assert.Equal(t, "superseded", requestParams.Options["reason"])
+}
+
+func TestParseSnowflakeParamsRenewalOptions(t *testing.T) {
+	e := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/renewmachineid",
+		Body:       `{"data": [[0,"TLS","prod-tpp","\\VED\\Policy\\Web\\app"]]}`,
+	}
+	_, requestParams := ParseSnowflakeParameters(e, RENEW_MID_TYPE)
+	assert.Nil(t, requestParams.Options)
+
+	e.Body = `{"data": [[0,"TLS","prod-tpp","\\VED\\Policy\\Web\\app",{"new_key": true}]]}`
+	_, requestParams = ParseSnowflakeParameters(e, RENEW_MID_TYPE)
+	assert.Equal(t, true, requestParams.Options["new_key"])
+}
+
+func TestParseSnowflakeParamsImport(t *testing.T) {
+	e := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/importmachineid",
+		Body:       `{"data": [[0,"TLS","prod-tpp","Discovered",null,"-----BEGIN CERTIFICATE-----\nMII=\n-----END CERTIFICATE-----\n"]]}`,
+	}
+	configParams, requestParams := ParseSnowflakeParameters(e, IMPORT_MID_TYPE)
+	assert.Equal(t, "Discovered", configParams.Zone)
+	assert.Equal(t, "", requestParams.ObjectName)
+	assert.Contains(t, requestParams.Certificate, "BEGIN CERTIFICATE")
+	assert.Equal(t, "", requestParams.PrivateKey)
+
+	e.Body = `{"data": [[0,"TLS","prod-tpp","Discovered","app","<certificate>","<private key>","secret"]]}`
+	_, requestParams = ParseSnowflakeParameters(e, IMPORT_MID_TYPE)
+	assert.Equal(t, "app", requestParams.ObjectName)
+	assert.Equal(t, "<private key>", requestParams.PrivateKey)
+	assert.Equal(t, "secret", requestParams.Passphrase)
+}
+
+func TestParseSnowflakeParamsLifecycle(t *testing.T) {
+	e := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/deletemachineid",
+		Body:       `{"data": [[0,"TLS","prod-tpp","\\example\\requestID",true]]}`,
+	}
+	_, requestParams := ParseSnowflakeParameters(e, DELETE_MID_TYPE)
+	assert.Equal(t, "\\\\example\\\\requestID", requestParams.RequestID)
+	assert.Equal(t, true, requestParams.Confirm)
+
+	e.Body = `{"data": [[0,"TLS","prod-tpp","\\example\\requestID",null]]}`
+	_, requestParams = ParseSnowflakeParameters(e, RETIRE_MID_TYPE)
+	assert.Equal(t, false, requestParams.Confirm)
+
+	e.Body = `{"data": [[0,"TLS","prod-tpp","\\example\\requestID",true]]}`
+	_, requestParams = ParseSnowflakeParameters(e, RESET_MID_TYPE)
+	assert.Equal(t, true, requestParams.Restart)
+	assert.Equal(t, false, requestParams.Confirm)
+}
+
+func TestParseSnowflakeParamsApproval(t *testing.T) {
+	e := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/rejectmachineid",
+		Body:       `{"data": [[0,"TLS","prod-tpp","\\example\\requestID","not planned"]]}`,
+	}
+	_, requestParams := ParseSnowflakeParameters(e, REJECT_MID_TYPE)
+	assert.Equal(t, "\\\\example\\\\requestID", requestParams.RequestID)
+	assert.Equal(t, "not planned", requestParams.Explanation)
+
+	e.Body = `{"data": [[0,"TLS","prod-tpp","Web"]]}`
+	configParams, _ := ParseSnowflakeParameters(e, LIST_APPROVALS_MID_TYPE)
+	assert.Equal(t, "Web", configParams.Zone)
+}
+
+func TestParseSnowflakeParamsRequestContext(t *testing.T) {
+	e := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/listrequests",
+		Headers:    map[string]string{"Sf-Context-Current-User": "ANALYST"},
+		Body:       `{"data": [[0,"TLS","prod-tpp",null]]}`,
+	}
+	configParams, requestParams := ParseSnowflakeParameters(e, LIST_REQUESTS_TYPE)
+	assert.Equal(t, "ANALYST", configParams.Context.User)
+	assert.Equal(t, "ANALYST", configParams.Context.Caller())
+	assert.Equal(t, "", requestParams.State)
+	assert.Equal(t, "", configParams.Context.RetryKey())
+
+	e.Headers["sf-external-function-query-batch-id"] = "01a2b3c4-batch"
+	e.Headers["sf-external-function-current-query-id"] = "01a2b3c4-query"
+	e.Headers["sf-context-current-role"] = "SECURITY_ADMIN"
+	e.Headers["sf-context-current-account"] = "XY12345"
+	configParams, _ = ParseSnowflakeParameters(e, LIST_REQUESTS_TYPE)
+	assert.Equal(t, RequestContext{
+		QueryID: "01a2b3c4-query",
+		BatchID: "01a2b3c4-batch",
+		Row:     "0",
+		User:    "ANALYST",
+		Role:    "SECURITY_ADMIN",
+		Account: "XY12345",
+	}, configParams.Context)
+	assert.Equal(t, "01a2b3c4-batch/0", configParams.Context.RetryKey())
+	assert.Equal(t, "ANALYST (role SECURITY_ADMIN, account XY12345)", configParams.Context.Caller())
+}
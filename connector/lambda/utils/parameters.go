@@ -11,8 +11,9 @@ import (
 )
 
 type ConfigParameters struct {
-	TppURL string
-	Zone   string
+	TppURL  string
+	Zone    string
+	Context RequestContext // Snowflake query and caller from the request headers
 }
 
 type RequestParameters struct {
@@ -22,6 +23,16 @@ type RequestParameters struct {
 	MachineIDType string
 	CommonName    string
 	DNSName       []string
+	Criteria      map[string]interface{}
+	ObjectName    string
+	Certificate   string                 // PEM certificate to import
+	PrivateKey    string                 // optional PEM private key to import
+	Passphrase    string                 // passphrase of the private key to import
+	Confirm       bool                   // destructive operations are only executed when confirmed
+	Restart       bool                   // restart the enrollment after resetting the certificate
+	Explanation   string                 // recorded with the approval or rejection of a workflow ticket
+	State         string                 // state of the requests to list from the ledger
+	Options       map[string]interface{} // nil when the function is called without the options parameter
 }
 
 func snowflakeInterfaceToStrArray(snowflakeArr interface{}) []string {
@@ -32,6 +43,43 @@ func snowflakeInterfaceToStrArray(snowflakeArr interface{}) []string {
 	return resultArr
 }
 
+// snowflakeInterfaceToStr converts an optional Snowflake parameter to string, NULL becomes an empty string
+func snowflakeInterfaceToStr(snowflakeValue interface{}) string {
+	if snowflakeValue == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", snowflakeValue)
+}
+
+// snowflakeInterfaceToBool converts an optional Snowflake BOOLEAN parameter, NULL and invalid values become false
+func snowflakeInterfaceToBool(snowflakeValue interface{}) bool {
+	if snowflakeValue == nil {
+		return false
+	}
+	result, err := strconv.ParseBool(fmt.Sprintf("%v", snowflakeValue))
+	if err != nil {
+		log.Errorf("Failed to parse boolean parameter: %s", err)
+		return false
+	}
+	return result
+}
+
+// snowflakeInterfaceToMap converts an optional Snowflake OBJECT parameter to a map, NULL becomes an empty map
+func snowflakeInterfaceToMap(snowflakeValue interface{}) map[string]interface{} {
+	switch value := snowflakeValue.(type) {
+	case map[string]interface{}:
+		return value
+	case string:
+		result := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(value), &result); err != nil {
+			log.Errorf("Failed to parse object parameter: %s", err)
+		}
+		return result
+	default:
+		return map[string]interface{}{}
+	}
+}
+
 func ParseSnowflakeParameters(request events.APIGatewayProxyRequest, queryType string) (ConfigParameters, RequestParameters) {
 	var snowflakeData SnowFlakeType
 	var configParameters ConfigParameters
@@ -47,24 +95,57 @@ func ParseSnowflakeParameters(request events.APIGatewayProxyRequest, queryType s
 		requestParameters.MachineIDType = "TLS" // this is not used yets, probably we will use it to request other machine id types
 	}
 	configParameters.TppURL = fmt.Sprintf("%v", snowflakeParams[2])
+	configParameters.Context = parseRequestContext(request.Headers, snowflakeParams[0])
 	switch queryType {
-	case LIST_MID_TYPE:
-		configParameters.Zone = fmt.Sprintf("%v", snowflakeParams[3])
+	case LIST_MID_TYPE, ZONE_POLICY_TYPE:
+		configParameters.Zone = snowflakeInterfaceToStr(snowflakeParams[3])
+		if len(snowflakeParams) > 4 {
+			requestParameters.Options = snowflakeInterfaceToMap(snowflakeParams[4])
+		}
 	case GET_MID_TYPE:
 		requestParameters.RequestID = strings.Replace(fmt.Sprintf("%v", snowflakeData.Data[0][3]), "\\", "\\\\", -1)
+		if len(snowflakeParams) > 4 {
+			requestParameters.Options = snowflakeInterfaceToMap(snowflakeParams[4])
+		}
 	case GET_STATUS_MID_TYPE:
-		configParameters.Zone = fmt.Sprintf("%v", snowflakeParams[3])
+		configParameters.Zone = snowflakeInterfaceToStr(snowflakeParams[3])
 		requestParameters.CommonName = fmt.Sprintf("%v", snowflakeParams[4])
 
-	case REQUEST_MID_TYPE:
+	case REQUEST_MID_TYPE, VALIDATE_MID_TYPE:
 		requestParameters.DNSName = snowflakeInterfaceToStrArray(snowflakeParams[3])
-		configParameters.Zone = fmt.Sprintf("%v", snowflakeParams[4])
+		configParameters.Zone = snowflakeInterfaceToStr(snowflakeParams[4])
 		requestParameters.UPN = snowflakeInterfaceToStrArray(snowflakeParams[5])
 		requestParameters.CommonName = fmt.Sprintf("%v", snowflakeParams[6])
+		if len(snowflakeParams) > 7 {
+			requestParameters.Options = snowflakeInterfaceToMap(snowflakeParams[7])
+		}
+	case LIST_APPROVALS_MID_TYPE:
+		configParameters.Zone = snowflakeInterfaceToStr(snowflakeParams[3])
+	case GET_REQUEST_STATUS_TYPE:
+		requestParameters.RequestID = strings.Replace(snowflakeInterfaceToStr(snowflakeParams[3]), "\\", "\\\\", -1)
+	case LIST_REQUESTS_TYPE:
+		requestParameters.State = snowflakeInterfaceToStr(snowflakeParams[3])
+	case APPROVE_MID_TYPE, REJECT_MID_TYPE:
+		requestParameters.RequestID = strings.Replace(snowflakeInterfaceToStr(snowflakeParams[3]), "\\", "\\\\", -1)
+		requestParameters.Explanation = snowflakeInterfaceToStr(snowflakeParams[4])
+	case SEARCH_MID_TYPE:
+		configParameters.Zone = snowflakeInterfaceToStr(snowflakeParams[3])
+		requestParameters.Criteria = snowflakeInterfaceToMap(snowflakeParams[4])
+	case IMPORT_MID_TYPE:
+		configParameters.Zone = snowflakeInterfaceToStr(snowflakeParams[3])
+		requestParameters.ObjectName = snowflakeInterfaceToStr(snowflakeParams[4])
+		requestParameters.Certificate = snowflakeInterfaceToStr(snowflakeParams[5])
+		if len(snowflakeParams) > 7 {
+			requestParameters.PrivateKey = snowflakeInterfaceToStr(snowflakeParams[6])
+			requestParameters.Passphrase = snowflakeInterfaceToStr(snowflakeParams[7])
+		}
 	case RENEW_MID_TYPE:
 		requestParameters.RequestID = strings.Replace(fmt.Sprintf("%v", snowflakeParams[3]), "\\", "\\\\", -1)
+		if len(snowflakeParams) > 4 {
+			requestParameters.Options = snowflakeInterfaceToMap(snowflakeParams[4])
+		}
 	case REVOKE_MID_TYPE:
-		requestParameters.RequestID = strings.Replace(fmt.Sprintf("%v", snowflakeParams[3]), "\\", "\\\\", -1)
+		requestParameters.RequestID = strings.Replace(snowflakeInterfaceToStr(snowflakeParams[3]), "\\", "\\\\", -1) // NULL when revoking by thumbprint
 		shouldDisable, err := strconv.ParseBool(fmt.Sprintf("%v", snowflakeParams[4]))
 		if err != nil {
 			log.Errorf("Failed to parse disable request property from Snowflake parameters: %s", err)
@@ -72,6 +153,15 @@ func ParseSnowflakeParameters(request events.APIGatewayProxyRequest, queryType s
 		} else {
 			requestParameters.Disable = shouldDisable
 		}
+		if len(snowflakeParams) > 5 {
+			requestParameters.Options = snowflakeInterfaceToMap(snowflakeParams[5])
+		}
+	case RETIRE_MID_TYPE, DELETE_MID_TYPE:
+		requestParameters.RequestID = strings.Replace(snowflakeInterfaceToStr(snowflakeParams[3]), "\\", "\\\\", -1)
+		requestParameters.Confirm = snowflakeInterfaceToBool(snowflakeParams[4])
+	case RESET_MID_TYPE:
+		requestParameters.RequestID = strings.Replace(snowflakeInterfaceToStr(snowflakeParams[3]), "\\", "\\\\", -1)
+		requestParameters.Restart = snowflakeInterfaceToBool(snowflakeParams[4])
 	}
 	return configParameters, requestParameters
 }
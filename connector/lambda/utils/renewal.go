@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	log "github.com/palette-software/go-log-targets"
+)
+
+const DEFAULT_RENEWAL_TIMEOUT_SECONDS = 180
+const renewalDeadlineMargin = 5 * time.Second // left to return the response before the Lambda times out
+
+// Status of the renewed certificate in the response of RENEW_MACHINE_ID
+const RENEWAL_STATUS_ISSUED = "issued"
+const RENEWAL_STATUS_PENDING = "pending"
+const RENEWAL_STATUS_AWAITING_APPROVAL = APPROVAL_STATUS_AWAITING
+
+// RenewalOptions are the keys of the options object of RENEW_MACHINE_ID
+type RenewalOptions struct {
+	NewKey     bool     // generate a new local key and CSR instead of reusing the CSR stored in TPP
+	CommonName string   // overrides the common name of the certificate, needs a new key
+	DNSNames   []string // overrides the DNS SANs of the certificate, needs a new key
+	UPNs       []string // overrides the UPN SANs of the certificate, needs a new key
+	Wait       bool     // retrieve the renewed certificate
+	Timeout    time.Duration
+}
+
+// RenewMachineIDResponse is the envelope of REQUEST_MACHINE_ID with the status of the renewed certificate.
+// A pending certificate can be retrieved later with GET_MACHINE_ID, the new private key is only returned here.
+type RenewMachineIDResponse struct {
+	RequestMachineIDResponse
+	Status    string           `json:"Status"`
+	Message   string           `json:"Message,omitempty"`
+	Approvals []ApprovalTicket `json:"Approvals,omitempty"` // pending workflow tickets of the renewal
+}
+
+// optionToStrArray reads a Snowflake array, or a comma separated string, of the options object
+func optionToStrArray(options map[string]interface{}, key string) ([]string, bool) {
+	value, ok := options[key]
+	if !ok || value == nil {
+		return nil, false
+	}
+	values := []string{}
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			values = append(values, criteriaValueToStr(item))
+		}
+	default:
+		values = strings.Split(criteriaValueToStr(v), ",")
+	}
+	return append([]string{}, nonEmptyValues(values)...), true // an empty array removes the SANs
+}
+
+// parseRenewalOptions reads new_key, common_name, dns, upn, wait and timeout_seconds from the options object
+func parseRenewalOptions(options map[string]interface{}) (RenewalOptions, error) {
+	for key := range options {
+		switch key {
+		case "new_key", "common_name", "dns", "upn", "wait", "timeout_seconds":
+		default:
+			return RenewalOptions{}, newConnectorError(ERR_INVALID_RENEWAL_OPTIONS, "unknown renewal option: %s", key)
+		}
+	}
+	result := RenewalOptions{Wait: true}
+	var err error
+	if result.NewKey, err = optionToBool(options, "new_key"); err != nil {
+		return result, newConnectorError(ERR_INVALID_RENEWAL_OPTIONS, "new_key must be true or false")
+	}
+	if _, ok := options["wait"]; ok {
+		if result.Wait, err = optionToBool(options, "wait"); err != nil {
+			return result, newConnectorError(ERR_INVALID_RENEWAL_OPTIONS, "wait must be true or false")
+		}
+	}
+	timeoutSeconds, err := optionToInt(options, "timeout_seconds", DEFAULT_RENEWAL_TIMEOUT_SECONDS)
+	if err != nil {
+		return result, newConnectorError(ERR_INVALID_RENEWAL_OPTIONS, "timeout_seconds must be a non-negative number")
+	}
+	result.Timeout = time.Duration(timeoutSeconds) * time.Second
+
+	overridden := false
+	if cn, ok := options["common_name"]; ok && cn != nil {
+		result.CommonName, overridden = criteriaValueToStr(cn), true
+	}
+	if dns, ok := optionToStrArray(options, "dns"); ok {
+		result.DNSNames, overridden = dns, true
+	}
+	if upn, ok := optionToStrArray(options, "upn"); ok {
+		result.UPNs, overridden = upn, true
+	}
+	if overridden && !result.NewKey {
+		return result, newConnectorError(ERR_INVALID_RENEWAL_OPTIONS, "common_name, dns and upn can only be changed with new_key, the CSR stored in TPP can not be modified")
+	}
+	return result, nil
+}
+
+// pickupTimeout returns the requested timeout, shortened to the time left before the deadline of the Lambda
+func pickupTimeout(ctx context.Context, requested time.Duration, now time.Time) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := deadline.Sub(now) - renewalDeadlineMargin; left < requested {
+			requested = left
+		}
+	}
+	if requested < 0 {
+		return 0
+	}
+	return requested
+}
+
+// renewalRequest creates the CSR of a renewal with a new key. The subject and SANs of the current
+// certificate are kept unless they are overridden.
+func (c *venafiConnector) renewalRequest(certificateDN string, options RenewalOptions) (*certificate.Request, error) {
+	current, err := c.client.RetrieveCertificate(&certificate.Request{PickupID: certificateDN})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the certificate to renew: %v", err)
+	}
+	cert, err := parsePEMCertificate(current.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the certificate to renew: %v", err)
+	}
+	cn, dns, upn := cert.Subject.CommonName, cert.DNSNames, parseUPNs(cert)
+	if options.CommonName != "" {
+		cn = options.CommonName
+	}
+	if options.DNSNames != nil {
+		dns = options.DNSNames
+	}
+	if options.UPNs != nil {
+		upn = options.UPNs
+	}
+	if err := enforceRenewalGuardrails(certificateDN, cn, upn, dns); err != nil {
+		return nil, err
+	}
+	renewReq := newEnrollRequest(cn, upn, dns)
+	if err := c.client.GenerateRequest(nil, renewReq); err != nil {
+		return nil, fmt.Errorf("failed to generate request: %v", err)
+	}
+	return renewReq, nil
+}
+
+// renewMachineIDWithOptions renews a certificate and retrieves the renewed certificate in the envelope of REQUEST_MACHINE_ID
+func (c *venafiConnector) renewMachineIDWithOptions(ctx context.Context, certificateDN string, options map[string]interface{}) (string, error) {
+	renewalOptions, err := parseRenewalOptions(options)
+	if err != nil {
+		log.Errorf("Invalid renewal options: %v", err)
+		return c.fail(err), nil
+	}
+	renewReq := &certificate.RenewalRequest{CertificateDN: certificateDN}
+	if renewalOptions.NewKey {
+		renewReq.CertificateRequest, err = c.renewalRequest(certificateDN, renewalOptions)
+		if err != nil {
+			log.Errorf("Failed to create renewal request: %v", err)
+			return c.fail(err), nil
+		}
+	}
+	requestID, err := c.client.RenewCertificate(renewReq)
+	if err != nil {
+		log.Errorf("Failed to renew certificate: %v", err)
+		return c.fail(err), nil
+	}
+	commonName := ""
+	if renewReq.CertificateRequest != nil {
+		commonName = renewReq.CertificateRequest.Subject.CommonName
+	}
+	c.recordRequest(RENEW_MID_TYPE, requestID, commonName)
+
+	response := RenewMachineIDResponse{RequestMachineIDResponse: RequestMachineIDResponse{RequestID: requestID}, Status: RENEWAL_STATUS_PENDING}
+	if renewalOptions.Wait {
+		pickupReq := &certificate.Request{PickupID: requestID}
+		pcc, approvals, err := c.retrieveCertificate(pickupReq, pickupTimeout(ctx, renewalOptions.Timeout, time.Now()))
+		c.recordRetrieval(requestID, approvals, err)
+		if err != nil {
+			log.Errorf("Renewed certificate is not issued yet: %v", err)
+			response.Message = err.Error()
+		} else if approvals != nil {
+			response.Status, response.Approvals = RENEWAL_STATUS_AWAITING_APPROVAL, approvals
+		} else {
+			response.Certificate, response.Status = pcc.Certificate, RENEWAL_STATUS_ISSUED
+		}
+	}
+	if renewReq.CertificateRequest != nil {
+		// the key is returned even when the certificate is pending, it can not be retrieved later
+		pcc := &certificate.PEMCollection{}
+		if err := pcc.AddPrivateKey(renewReq.CertificateRequest.PrivateKey, []byte(renewReq.CertificateRequest.KeyPassword)); err != nil {
+			log.Errorf("Failed to encode private key: %v", err)
+			return c.fail(err), nil
+		}
+		response.PrivateKey, response.Passphrase = pcc.PrivateKey, renewReq.CertificateRequest.KeyPassword
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return c.fail(err), err
+	}
+	log.Infof("Renewed certificate %s, status: %s", requestID, response.Status)
+	return createSnowflakeResponseWithEscape(data), nil
+}
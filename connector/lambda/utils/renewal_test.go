@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRenewalOptions(t *testing.T) {
+	options, err := parseRenewalOptions(map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, RenewalOptions{Wait: true, Timeout: DEFAULT_RENEWAL_TIMEOUT_SECONDS * time.Second}, options)
+
+	options, err = parseRenewalOptions(map[string]interface{}{
+		"new_key":         true,
+		"common_name":     "app.example.com",
+		"dns":             []interface{}{"app.example.com", "www.app.example.com"},
+		"upn":             "",
+		"wait":            false,
+		"timeout_seconds": float64(20),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, RenewalOptions{
+		NewKey:     true,
+		CommonName: "app.example.com",
+		DNSNames:   []string{"app.example.com", "www.app.example.com"},
+		UPNs:       []string{},
+		Wait:       false,
+		Timeout:    20 * time.Second,
+	}, options)
+
+	for _, invalid := range []map[string]interface{}{
+		{"dns": []interface{}{"app.example.com"}},
+		{"new_key": "maybe"},
+		{"timeout_seconds": -1},
+		{"key": "new"},
+	} {
+		_, err = parseRenewalOptions(invalid)
+		assert.Equal(t, ERR_INVALID_RENEWAL_OPTIONS, err.(*ConnectorError).Code, "%v", invalid)
+	}
+}
+
+func TestPickupTimeout(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, 180*time.Second, pickupTimeout(context.Background(), 180*time.Second, now))
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(30*time.Second))
+	defer cancel()
+	assert.Equal(t, 25*time.Second, pickupTimeout(ctx, 180*time.Second, now))
+	assert.Equal(t, 10*time.Second, pickupTimeout(ctx, 10*time.Second, now))
+
+	assert.Equal(t, time.Duration(0), pickupTimeout(ctx, 180*time.Second, now.Add(time.Minute)))
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Headers sent by Snowflake with every external function call
+const HEADER_QUERY_ID = "sf-external-function-current-query-id"
+const HEADER_BATCH_ID = "sf-external-function-query-batch-id"
+
+// Context headers of the CONTEXT_HEADERS clause of the external functions created by the installer
+const HEADER_CURRENT_USER = "sf-context-current-user"
+const HEADER_CURRENT_ROLE = "sf-context-current-role"
+const HEADER_CURRENT_ACCOUNT = "sf-context-current-account"
+
+// RequestContext identifies the Snowflake query and caller of an external function call. The caller is
+// empty for functions created without context headers.
+type RequestContext struct {
+	QueryID string
+	BatchID string
+	Row     string // row number of the call in the batch
+	User    string
+	Role    string
+	Account string
+}
+
+// headerValue returns a request header, API Gateway does not normalize the case of header names
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func parseRequestContext(headers map[string]string, row interface{}) RequestContext {
+	return RequestContext{
+		QueryID: headerValue(headers, HEADER_QUERY_ID),
+		BatchID: headerValue(headers, HEADER_BATCH_ID),
+		Row:     snowflakeInterfaceToStr(row),
+		User:    headerValue(headers, HEADER_CURRENT_USER),
+		Role:    headerValue(headers, HEADER_CURRENT_ROLE),
+		Account: headerValue(headers, HEADER_CURRENT_ACCOUNT),
+	}
+}
+
+// RetryKey identifies the row in the Snowflake batch, it is the same when Snowflake retries the batch
+func (r RequestContext) RetryKey() string {
+	if r.BatchID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", r.BatchID, r.Row)
+}
+
+// Caller describes the Snowflake caller for logs, like ANALYST (role ANALYST_ROLE, account XY12345)
+func (r RequestContext) Caller() string {
+	if r.User == "" {
+		return "unknown caller"
+	}
+	caller := r.User
+	details := []string{}
+	if r.Role != "" {
+		details = append(details, "role "+r.Role)
+	}
+	if r.Account != "" {
+		details = append(details, "account "+r.Account)
+	}
+	if len(details) > 0 {
+		caller += " (" + strings.Join(details, ", ") + ")"
+	}
+	return caller
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Venafi/vcert/v4/pkg/venafi/tpp"
+)
+
+const MAX_REVOCATION_COMMENTS_LENGTH = 1000
+
+var thumbprintRegex = regexp.MustCompile(`^[0-9A-F]{40}$`)
+
+// RevocationOptions are the keys of the options object of REVOKE_MACHINE_ID
+type RevocationOptions struct {
+	Reason     string // CRL reason of vcert's tpp.RevocationReasonsMap
+	Comments   string
+	Thumbprint string // revoke the certificate by its SHA-1 thumbprint instead of the DN
+}
+
+func revocationReasons() []string {
+	reasons := []string{}
+	for reason := range tpp.RevocationReasonsMap {
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// normalizeRevocationReason accepts reasons like Key_Compromise or "key compromise" for key-compromise
+func normalizeRevocationReason(reason string) string {
+	return strings.NewReplacer("_", "-", " ", "-").Replace(strings.ToLower(strings.TrimSpace(reason)))
+}
+
+// normalizeThumbprint removes the separators of thumbprints copied from certificate viewers
+func normalizeThumbprint(thumbprint string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", " ", "").Replace(strings.TrimSpace(thumbprint)))
+}
+
+// parseRevocationOptions reads reason, comments and thumbprint from the options object. Reasons are
+// validated here, because TPP revokes the certificate before vcert could report an unknown reason.
+func parseRevocationOptions(options map[string]interface{}) (RevocationOptions, error) {
+	result := RevocationOptions{}
+	for key, value := range options {
+		if value == nil {
+			continue
+		}
+		switch key {
+		case "reason":
+			result.Reason = normalizeRevocationReason(criteriaValueToStr(value))
+			if _, ok := tpp.RevocationReasonsMap[result.Reason]; !ok {
+				return result, newConnectorError(ERR_INVALID_REVOCATION_OPTIONS, "unknown revocation reason %s, use one of: %s", result.Reason, strings.Join(revocationReasons(), ", "))
+			}
+		case "comments":
+			result.Comments = strings.TrimSpace(fmt.Sprintf("%v", value))
+			if len(result.Comments) > MAX_REVOCATION_COMMENTS_LENGTH {
+				return result, newConnectorError(ERR_INVALID_REVOCATION_OPTIONS, "comments can not be longer than %d characters", MAX_REVOCATION_COMMENTS_LENGTH)
+			}
+		case "thumbprint":
+			result.Thumbprint = normalizeThumbprint(criteriaValueToStr(value))
+			if !thumbprintRegex.MatchString(result.Thumbprint) {
+				return result, newConnectorError(ERR_INVALID_REVOCATION_OPTIONS, "thumbprint must be the 40 hexadecimal characters of the SHA-1 fingerprint")
+			}
+		default:
+			return result, newConnectorError(ERR_INVALID_REVOCATION_OPTIONS, "unknown revocation option: %s", key)
+		}
+	}
+	return result, nil
+}
+
+// searchByThumbprint returns the certificates with the thumbprint in the zone and its subfolders, or in every zone
+// when the zone is empty
+func (c *venafiConnector) searchByThumbprint(zone string, thumbprint string) (*tppSearchResponse, error) {
+	query, err := buildSearchQuery(zone, map[string]interface{}{"thumbprint": thumbprint})
+	if err != nil {
+		return nil, err
+	}
+	tppResponse := &tppSearchResponse{}
+	if err := c.api.get("Certificates/", query, tppResponse); err != nil {
+		return nil, err
+	}
+	return tppResponse, nil
+}
+
+// findCertificateDNByThumbprint returns the DN of the certificate with the thumbprint, so the authorization and
+// guardrail policies of its zone are checked before the revocation. The certificate is revoked by this DN, a
+// thumbprint of several certificate objects is rejected, they may be in zones the caller cannot revoke in.
+func (c *venafiConnector) findCertificateDNByThumbprint(thumbprint string) (string, error) {
+	tppResponse, err := c.searchByThumbprint("", thumbprint)
+	if err != nil {
+		return "", err
+	}
+	if len(tppResponse.Certificates) == 0 {
+		return "", fmt.Errorf("no certificate found with thumbprint %s", thumbprint)
+	}
+	if len(tppResponse.Certificates) > 1 {
+		return "", newConnectorError(ERR_INVALID_REVOCATION_OPTIONS, "%d certificates have thumbprint %s, revoke them by request_id", len(tppResponse.Certificates), thumbprint)
+	}
+	return tppResponse.Certificates[0].DN, nil
+}
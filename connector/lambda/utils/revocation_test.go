@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRevocationOptions(t *testing.T) {
+	options, err := parseRevocationOptions(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, RevocationOptions{}, options)
+
+	options, err = parseRevocationOptions(map[string]interface{}{
+		"reason":     "Key_Compromise",
+		"comments":   " INC-1234 private key leaked ",
+		"thumbprint": "a1:b2:c3:d4:e5:f6:a7:b8:c9:d0:e1:f2:a3:b4:c5:d6:e7:f8:a9:b0",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, RevocationOptions{
+		Reason:     "key-compromise",
+		Comments:   "INC-1234 private key leaked",
+		Thumbprint: "A1B2C3D4E5F6A7B8C9D0E1F2A3B4C5D6E7F8A9B0",
+	}, options)
+
+	for _, invalid := range []map[string]interface{}{
+		{"reason": "lost"},
+		{"thumbprint": "A1B2"},
+		{"disable": true},
+	} {
+		_, err = parseRevocationOptions(invalid)
+		assert.Equal(t, ERR_INVALID_REVOCATION_OPTIONS, err.(*ConnectorError).Code, "%v", invalid)
+	}
+}
+
+func TestFindCertificateDNByThumbprint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "A1B2C3D4E5F6A7B8C9D0E1F2A3B4C5D6E7F8A9B0", r.URL.Query().Get("Thumbprint"))
+		assert.Empty(t, r.URL.Query().Get("ParentDnRecursive"))
+		w.Write([]byte(`{"Certificates": [{"DN": "\\VED\\Policy\\Web\\app"}], "TotalCount": 1}`))
+	}))
+	defer server.Close()
+
+	c := &venafiConnector{api: newTPPAPIClient(server.URL, "test-token", server.Client())}
+	dn, err := c.findCertificateDNByThumbprint("A1B2C3D4E5F6A7B8C9D0E1F2A3B4C5D6E7F8A9B0")
+	assert.Nil(t, err)
+	assert.Equal(t, "\\VED\\Policy\\Web\\app", dn)
+}
+
+func TestRevokeMachineIDRejectsDNAndThumbprint(t *testing.T) {
+	c := &venafiConnector{}
+	response, err := c.RevokeMachineID(context.Background(), "\\\\VED\\\\Policy\\\\Web\\\\app", false,
+		map[string]interface{}{"thumbprint": "A1B2C3D4E5F6A7B8C9D0E1F2A3B4C5D6E7F8A9B0"})
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_INVALID_REVOCATION_OPTIONS)
+}
+
+// revokeConnector overrides RevokeCertificate of vcert's connector
+type revokeConnector struct {
+	endpoint.Connector
+	requests []*certificate.RevocationRequest
+}
+
+func (c *revokeConnector) RevokeCertificate(req *certificate.RevocationRequest) error {
+	c.requests = append(c.requests, req)
+	return nil
+}
+
+func TestRevokeMachineIDByThumbprint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("Thumbprint") == "A1B2C3D4E5F6A7B8C9D0E1F2A3B4C5D6E7F8A9B0" {
+			w.Write([]byte(`{"Certificates": [{"DN": "\\VED\\Policy\\Web\\app"}], "TotalCount": 1}`))
+		} else {
+			w.Write([]byte(`{"Certificates": [{"DN": "\\VED\\Policy\\Web\\app"}, {"DN": "\\VED\\Policy\\DB\\app"}], "TotalCount": 2}`))
+		}
+	}))
+	defer server.Close()
+	client := &revokeConnector{}
+	c := &venafiConnector{client: client, api: newTPPAPIClient(server.URL, "test-token", server.Client())}
+	// no guardrail policy in the bucket
+	guardrailCache.policy, guardrailCache.loadedAt = nil, time.Now()
+	defer func() { guardrailCache.loadedAt = time.Time{} }()
+
+	response, err := c.RevokeMachineID(context.Background(), "", false, map[string]interface{}{"thumbprint": "A1B2C3D4E5F6A7B8C9D0E1F2A3B4C5D6E7F8A9B0"})
+	assert.Nil(t, err)
+	assert.Contains(t, response, "app")
+	assert.Len(t, client.requests, 1)
+	assert.Equal(t, "\\VED\\Policy\\Web\\app", client.requests[0].CertificateDN)
+	assert.Empty(t, client.requests[0].Thumbprint)
+
+	// a thumbprint shared by several certificate objects is not revoked
+	response, err = c.RevokeMachineID(context.Background(), "", false, map[string]interface{}{"thumbprint": "0000000000000000000000000000000000000000"})
+	assert.Nil(t, err)
+	assert.Contains(t, response, ERR_INVALID_REVOCATION_OPTIONS)
+	assert.Len(t, client.requests, 1)
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/palette-software/go-log-targets"
+)
+
+const DEFAULT_SEARCH_LIMIT = 100
+
+// searchCriteriaParameters maps the keys of the Snowflake criteria object to TPP certificate search attributes
+var searchCriteriaParameters = map[string]string{
+	"cn":             "CN",
+	"san":            "SAN-DNS",
+	"san_upn":        "SAN-UPN",
+	"san_ip":         "SAN-IP",
+	"san_email":      "SAN-Email",
+	"serial":         "Serial",
+	"thumbprint":     "Thumbprint",
+	"issuer":         "Issuer",
+	"expires_after":  "ValidToGreater",
+	"expires_before": "ValidToLess",
+	"disabled":       "Disabled",
+	"limit":          "Limit",
+}
+
+type SearchMachineIDSANs struct {
+	DNS   []string `json:"DNS,omitempty"`
+	Email []string `json:"Email,omitempty"`
+	IP    []string `json:"IP,omitempty"`
+	URI   []string `json:"URI,omitempty"`
+	UPN   []string `json:"UPN,omitempty"`
+}
+
+type SearchMachineID struct {
+	DN         string              `json:"DN"`
+	Guid       string              `json:"Guid"`
+	CN         string              `json:"CN"`
+	SANs       SearchMachineIDSANs `json:"SANs"`
+	Serial     string              `json:"Serial"`
+	Thumbprint string              `json:"Thumbprint"`
+	Issuer     string              `json:"Issuer"`
+	ValidFrom  time.Time           `json:"ValidFrom"`
+	ValidTo    time.Time           `json:"ValidTo"`
+}
+
+type SearchMachineIDsResponse struct {
+	TotalCount   int               `json:"TotalCount"`
+	Certificates []SearchMachineID `json:"Certificates"`
+}
+
+// tppSearchResponse is the response of GET Certificates/ of the WebSDK
+type tppSearchResponse struct {
+	Certificates []struct {
+		DN   string
+		Guid string
+		X509 struct {
+			CN         string
+			Issuer     string
+			Serial     string
+			Thumbprint string
+			ValidFrom  time.Time
+			ValidTo    time.Time
+			SANS       SearchMachineIDSANs
+		}
+	}
+	TotalCount int
+}
+
+func criteriaValueToStr(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return strings.TrimSpace(fmt.Sprintf("%v", v))
+	}
+}
+
+// parseSearchDate accepts dates like 2022-01-31 and RFC3339 timestamps
+func parseSearchDate(value string) (string, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return "", fmt.Errorf("invalid date %s, use YYYY-MM-DD or RFC3339", value)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+// buildSearchQuery translates the criteria object of SEARCH_MACHINE_IDS into a TPP certificate search query
+func buildSearchQuery(zone string, criteria map[string]interface{}) (url.Values, error) {
+	query := url.Values{}
+	if policyDN := getPolicyDN(zone); policyDN != "" {
+		query.Set("ParentDnRecursive", policyDN)
+	}
+	query.Set("Limit", strconv.Itoa(DEFAULT_SEARCH_LIMIT))
+
+	keys := make([]string, 0, len(criteria))
+	for key := range criteria {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if criteria[key] == nil {
+			continue
+		}
+		parameter, ok := searchCriteriaParameters[strings.ToLower(key)]
+		if !ok {
+			return nil, newConnectorError(ERR_INVALID_SEARCH_CRITERIA, "unknown search criteria: %s", key)
+		}
+		value := criteriaValueToStr(criteria[key])
+		switch parameter {
+		case "Serial", "Thumbprint":
+			value = strings.ToUpper(strings.NewReplacer(":", "", " ", "").Replace(value))
+		case "ValidToGreater", "ValidToLess":
+			date, err := parseSearchDate(value)
+			if err != nil {
+				return nil, newConnectorError(ERR_INVALID_SEARCH_CRITERIA, "%s: %v", key, err)
+			}
+			value = date
+		case "Disabled":
+			disabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, newConnectorError(ERR_INVALID_SEARCH_CRITERIA, "disabled must be true or false")
+			}
+			value = "0"
+			if disabled {
+				value = "1"
+			}
+		case "Limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				return nil, newConnectorError(ERR_INVALID_SEARCH_CRITERIA, "limit must be a positive number")
+			}
+		}
+		query.Set(parameter, value)
+	}
+	return query, nil
+}
+
+func toSearchMachineIDsResponse(tppResponse *tppSearchResponse) SearchMachineIDsResponse {
+	response := SearchMachineIDsResponse{TotalCount: tppResponse.TotalCount, Certificates: []SearchMachineID{}}
+	for _, cert := range tppResponse.Certificates {
+		response.Certificates = append(response.Certificates, SearchMachineID{
+			DN:         cert.DN,
+			Guid:       cert.Guid,
+			CN:         cert.X509.CN,
+			SANs:       cert.X509.SANS,
+			Serial:     cert.X509.Serial,
+			Thumbprint: cert.X509.Thumbprint,
+			Issuer:     cert.X509.Issuer,
+			ValidFrom:  cert.X509.ValidFrom,
+			ValidTo:    cert.X509.ValidTo,
+		})
+	}
+	return response
+}
+
+// SearchMachineIDs searches certificates in the zone with the TPP certificate search
+func (c *venafiConnector) SearchMachineIDs(ctx context.Context, criteria map[string]interface{}) (string, error) {
+	defer c.audited(SEARCH_MID_TYPE, "")()
+	if err := c.authorize(SEARCH_MID_TYPE, getPolicyDN(c.zone)); err != nil {
+		return c.fail(err), nil
+	}
+	query, err := buildSearchQuery(c.zone, criteria)
+	if err != nil {
+		log.Errorf("Invalid search criteria: %v", err)
+		return c.fail(err), nil
+	}
+	tppResponse := &tppSearchResponse{}
+	err = c.api.get("Certificates/", query, tppResponse)
+	if err != nil {
+		log.Errorf("Failed to search certificates: %v", err)
+		return c.fail(err), nil
+	}
+	data, err := json.Marshal(toSearchMachineIDsResponse(tppResponse))
+	if err != nil {
+		log.Errorf("Failed to serialize search result: %v", err)
+		return c.fail(err), err
+	}
+	log.Infof("Found %d certificates", tppResponse.TotalCount)
+	return createSnowflakeResponseWithEscape(data), nil
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildSearchQuery(t *testing.T) {
+	query, err := buildSearchQuery("Web\\Prod", map[string]interface{}{
+		"cn":             "example.com",
+		"thumbprint":     "ab:cd:ef",
+		"expires_before": "2022-03-01",
+		"disabled":       false,
+		"limit":          float64(20),
+		"issuer":         nil,
+		"san_upn":        "svc@example.com",
+		"san_ip":         "10.0.0.7",
+		"san_email":      "admin@example.com",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "\\VED\\Policy\\Web\\Prod", query.Get("ParentDnRecursive"))
+	assert.Equal(t, "example.com", query.Get("CN"))
+	assert.Equal(t, "ABCDEF", query.Get("Thumbprint"))
+	assert.Equal(t, "2022-03-01T00:00:00Z", query.Get("ValidToLess"))
+	assert.Equal(t, "0", query.Get("Disabled"))
+	assert.Equal(t, "20", query.Get("Limit"))
+	assert.Equal(t, "", query.Get("Issuer"))
+	assert.Equal(t, "svc@example.com", query.Get("SAN-UPN"))
+	assert.Equal(t, "10.0.0.7", query.Get("SAN-IP"))
+	assert.Equal(t, "admin@example.com", query.Get("SAN-Email"))
+
+	_, err = buildSearchQuery("", map[string]interface{}{"color": "blue"})
+	assert.Equal(t, ERR_INVALID_SEARCH_CRITERIA, err.(*ConnectorError).Code)
+
+	_, err = buildSearchQuery("", map[string]interface{}{"expires_after": "next week"})
+	assert.Equal(t, ERR_INVALID_SEARCH_CRITERIA, err.(*ConnectorError).Code)
+}
+
+func TestSearchMachineIDs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/vedsdk/Certificates/", r.URL.Path)
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+		assert.Equal(t, "app.example.com", r.URL.Query().Get("SAN-DNS"))
+		w.Write([]byte(`{"Certificates": [{"DN": "\\VED\\Policy\\Web\\app", "Guid": "{1234}", "X509": {"CN": "app", "Serial": "01", "SANS": {"DNS": ["app.example.com"]}, "ValidTo": "2022-03-01T00:00:00Z"}}], "TotalCount": 1}`))
+	}))
+	defer server.Close()
+
+	c := &venafiConnector{api: newTPPAPIClient(server.URL, "test-token", server.Client()), zone: "\\VED\\Policy\\Web"}
+	response, err := c.SearchMachineIDs(context.Background(), map[string]interface{}{"san": "app.example.com"})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(response, "{'data': [[0, '{\"TotalCount\":1"))
+	assert.Contains(t, response, `"DN":"\\\\VED\\\\Policy\\\\Web\\\\app"`)
+	assert.Contains(t, response, `"SANs":{"DNS":["app.example.com"]}`)
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	log "github.com/palette-software/go-log-targets"
+)
+
+// bucketStore stores the objects the Lambdas keep between calls, the deployment bucket outside of the tests
+type bucketStore interface {
+	read(key string) ([]byte, error) // errBucketFileNotFound when the key does not exist
+	write(key string, data []byte) error
+	delete(key string) error
+	list(prefix string) ([]string, error)
+}
+
+// bucketWriter is the part of a bucketStore needed to write objects
+type bucketWriter interface {
+	write(key string, data []byte) error
+}
+
+type deploymentBucket struct{}
+
+func (deploymentBucket) read(key string) ([]byte, error)      { return readBucketFile(key) }
+func (deploymentBucket) write(key string, data []byte) error  { return writeBucketFile(key, data) }
+func (deploymentBucket) delete(key string) error              { return deleteBucketFile(key) }
+func (deploymentBucket) list(prefix string) ([]string, error) { return listBucketFiles(prefix) }
+
+// errBucketFileNotFound is returned by readBucketFile when the key does not exist in the bucket
+var errBucketFileNotFound = errors.New("file not found in bucket")
+
+func newBucketSession() *session.Session {
+	return session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(os.Getenv("ZONE")),
+	}))
+}
+
+// readBucketFile downloads a file from the deployment bucket configured for the Lambda
+func readBucketFile(key string) ([]byte, error) {
+	downloader := s3manager.NewDownloader(newBucketSession())
+	buff := &aws.WriteAtBuffer{}
+
+	n, err := downloader.Download(buff, &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("S3_BUCKET")),
+		Key:    aws.String(key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return []byte{}, errBucketFileNotFound
+	}
+	if err != nil {
+		log.Errorf("Failed to download %s from bucket: %v", key, err)
+		return []byte{}, fmt.Errorf("failed to read %s from bucket, %v", key, err)
+	}
+	log.Debugf("%s downloaded, %d bytes\n", key, n)
+	return buff.Bytes(), nil
+}
+
+// writeBucketFile uploads a file to the deployment bucket, replacing the previous version
+func writeBucketFile(key string, data []byte) error {
+	uploader := s3manager.NewUploader(newBucketSession())
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(os.Getenv("S3_BUCKET")),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+		// cached responses of the idempotent requests contain private keys
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s to bucket, %v", key, err)
+	}
+	return nil
+}
+
+// lockedBucket is a bucket with S3 Object Lock, the Lambdas can only add objects to it
+type lockedBucket struct {
+	name string
+}
+
+func (b lockedBucket) write(key string, data []byte) error {
+	return writeLockedBucketFile(b.name, key, data)
+}
+
+// writeLockedBucketFile uploads a file to a bucket with a default Object Lock retention, which requires
+// the MD5 of the content
+func writeLockedBucketFile(bucket string, key string, data []byte) error {
+	sum := md5.Sum(data)
+	_, err := s3.New(newBucketSession()).PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(data),
+		ContentMD5:           aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s to bucket %s, %v", key, bucket, err)
+	}
+	return nil
+}
+
+func deleteBucketFile(key string) error {
+	_, err := s3.New(newBucketSession()).DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(os.Getenv("S3_BUCKET")),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s from bucket, %v", key, err)
+	}
+	return nil
+}
+
+// listBucketFiles returns the keys of the deployment bucket under the prefix
+func listBucketFiles(prefix string) ([]string, error) {
+	keys := []string{}
+	err := s3.New(newBucketSession()).ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(os.Getenv("S3_BUCKET")),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s in bucket, %v", prefix, err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/palette-software/go-log-targets"
+)
+
+// tppAPIClient calls the TPP WebSDK directly for the operations vcert does not expose
+type tppAPIClient struct {
+	baseURL     string
+	accessToken string
+	httpClient  *http.Client
+}
+
+// tppAPIBaseURL returns the WebSDK url of a TPP server, with or without the /vedsdk suffix
+func tppAPIBaseURL(tppUrl string) string {
+	base := strings.TrimSpace(tppUrl)
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	base = strings.TrimRight(base, "/")
+	if strings.HasSuffix(strings.ToLower(base), "/vedsdk") {
+		base = base[:len(base)-len("/vedsdk")]
+	}
+	return base + "/vedsdk/"
+}
+
+func newTPPAPIClient(tppUrl, accessToken string, httpClient *http.Client) *tppAPIClient {
+	return &tppAPIClient{
+		baseURL:     tppAPIBaseURL(tppUrl),
+		accessToken: accessToken,
+		httpClient:  httpClient,
+	}
+}
+
+// getPolicyDN returns the full policy folder of a zone like vcert does
+func getPolicyDN(zone string) string {
+	if zone == "" {
+		return ""
+	}
+	if strings.HasPrefix(strings.ToLower(zone), "\\ved\\policy") {
+		return zone
+	}
+	return "\\VED\\Policy\\" + strings.TrimPrefix(zone, "\\")
+}
+
+// do sends a request to the WebSDK and decodes the JSON response into result
+func (a *tppAPIClient) do(method, resource string, query url.Values, body interface{}, result interface{}) error {
+	requestURL := a.baseURL + resource
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+	var payload *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	} else {
+		payload = bytes.NewReader([]byte{})
+	}
+	req, err := http.NewRequest(method, requestURL, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", a.accessToken))
+	req.Header.Add("content-type", "application/json")
+	req.Header.Add("cache-control", "no-cache")
+
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		log.Errorf("Failed to call TPP %s %s: %v", method, resource, err)
+		return err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code on %s %s: %s %s", method, resource, res.Status, strings.TrimSpace(string(data)))
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("failed to parse response of %s %s: %v", method, resource, err)
+	}
+	return nil
+}
+
+func (a *tppAPIClient) get(resource string, query url.Values, result interface{}) error {
+	return a.do("GET", resource, query, nil, result)
+}
+
+func (a *tppAPIClient) post(resource string, body interface{}, result interface{}) error {
+	return a.do("POST", resource, nil, body, result)
+}
+
+func (a *tppAPIClient) put(resource string, body interface{}, result interface{}) error {
+	return a.do("PUT", resource, nil, body, result)
+}
+
+func (a *tppAPIClient) delete(resource string, result interface{}) error {
+	return a.do("DELETE", resource, nil, nil, result)
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/palette-software/go-log-targets"
+)
+
+// Optional keys of a TPP entry in the credential file to configure the connection
+const TRUST_BUNDLE_KEY = "TrustBundle"          // PEM encoded CA bundle stored inline
+const TRUST_BUNDLE_FILE_KEY = "TrustBundleFile" // name of a PEM encoded CA bundle in the bucket
+const MIN_TLS_VERSION_KEY = "MinTLSVersion"     // 1.0, 1.1, 1.2 or 1.3
+const PROXY_URL_KEY = "ProxyUrl"                // http(s) proxy used to reach the TPP server
+const PROXY_USERNAME_KEY = "ProxyUsername"
+const PROXY_PASSWORD_KEY = "ProxyPassword"
+const NO_PROXY_KEY = "NoProxy" // comma separated list of hosts and domains which are reached directly
+
+func parseMinTLSVersion(version string) (uint16, error) {
+	switch strings.TrimSpace(version) {
+	case "":
+		return 0, nil // use the default of crypto/tls
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("Invalid minimum TLS version: %v", version)
+	}
+}
+
+// getTrustBundle returns the PEM trust bundle of a TPP entry, either inline or read from the bucket
+func getTrustBundle(cred credentialJSON) (string, error) {
+	if bundle := cred[TRUST_BUNDLE_KEY]; bundle != "" {
+		return bundle, nil
+	}
+	if bundleFile := cred[TRUST_BUNDLE_FILE_KEY]; bundleFile != "" {
+		data, err := readBucketFile(bundleFile)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read trust bundle: %v", err)
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
+
+func createTLSConfig(trustBundle, minTLSVersion string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if trustBundle != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(trustBundle)) {
+			return nil, fmt.Errorf("Failed to parse PEM trust bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	version, err := parseMinTLSVersion(minTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.MinVersion = version
+	return tlsConfig, nil
+}
+
+func isNoProxyHost(host string, noProxy string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// getProxyFunc returns the proxy settings of a TPP entry. Entries without a proxy use the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables of the Lambda, with the username and
+// password of the entry, the installer keeps them out of the environment.
+func getProxyFunc(cred credentialJSON) (func(*http.Request) (*url.URL, error), error) {
+	if cred[PROXY_URL_KEY] == "" {
+		if cred[PROXY_USERNAME_KEY] == "" {
+			return http.ProxyFromEnvironment, nil
+		}
+		return withProxyUser(http.ProxyFromEnvironment, url.UserPassword(cred[PROXY_USERNAME_KEY], cred[PROXY_PASSWORD_KEY])), nil
+	}
+	proxyURL, err := url.Parse(cred[PROXY_URL_KEY])
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("Invalid proxy url: %v", cred[PROXY_URL_KEY])
+	}
+	if cred[PROXY_USERNAME_KEY] != "" {
+		proxyURL.User = url.UserPassword(cred[PROXY_USERNAME_KEY], cred[PROXY_PASSWORD_KEY])
+	}
+	noProxy := cred[NO_PROXY_KEY]
+	return func(req *http.Request) (*url.URL, error) {
+		if isNoProxyHost(req.URL.Host, noProxy) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+func withProxyUser(proxy func(*http.Request) (*url.URL, error), user *url.Userinfo) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := proxy(req)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+		// the url of the environment is shared between the calls
+		withUser := *proxyURL
+		withUser.User = user
+		return &withUser, nil
+	}
+}
+
+// newTPPHTTPClient creates the http client used for every call to a TPP server with the
+// connection settings of its entry in the credential file
+func newTPPHTTPClient(cred credentialJSON, trustBundle string) (*http.Client, error) {
+	tlsConfig, err := createTLSConfig(trustBundle, cred[MIN_TLS_VERSION_KEY])
+	if err != nil {
+		log.Errorf("Invalid TLS settings for %s: %v", cred["Url"], err)
+		return nil, err
+	}
+	proxy, err := getProxyFunc(cred)
+	if err != nil {
+		log.Errorf("Invalid proxy settings for %s: %v", cred["Url"], err)
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}, nil
+}
@@ -22,3 +22,15 @@ const GET_MID_TYPE = "get"
 const GET_STATUS_MID_TYPE = "status"
 const RENEW_MID_TYPE = "renew"
 const REVOKE_MID_TYPE = "revoke"
+const ZONE_POLICY_TYPE = "zonepolicy"
+const VALIDATE_MID_TYPE = "validate"
+const SEARCH_MID_TYPE = "search"
+const IMPORT_MID_TYPE = "import"
+const RETIRE_MID_TYPE = "retire"
+const RESET_MID_TYPE = "reset"
+const DELETE_MID_TYPE = "delete"
+const LIST_APPROVALS_MID_TYPE = "listapprovals"
+const APPROVE_MID_TYPE = "approve"
+const REJECT_MID_TYPE = "reject"
+const GET_REQUEST_STATUS_TYPE = "getrequeststatus"
+const LIST_REQUESTS_TYPE = "listrequests"
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	log "github.com/palette-software/go-log-targets"
+)
+
+type PolicyViolation struct {
+	Code    string `json:"Code,omitempty"` // set for violations of the guardrail policy
+	Field   string `json:"Field"`
+	Value   string `json:"Value"`
+	Message string `json:"Message"`
+}
+
+type ValidateMachineIDRequestResponse struct {
+	Valid      bool              `json:"Valid"`
+	Zone       string            `json:"Zone"`
+	Violations []PolicyViolation `json:"Violations"`
+}
+
+// newEnrollRequest creates the certificate request sent to TPP for a new machine identity
+func newEnrollRequest(cn string, upn []string, dns []string) *certificate.Request {
+	return &certificate.Request{
+		Subject: pkix.Name{
+			CommonName: cn,
+		},
+		UPNs:        upn,
+		DNSNames:    dns,
+		CsrOrigin:   certificate.LocalGeneratedCSR,
+		KeyType:     certificate.KeyTypeRSA,
+		KeyLength:   2048,
+		KeyPassword: "",
+	}
+}
+
+// matchesAnyRegex reports whether value matches one of the regular expressions of the zone.
+// An empty list means the zone does not restrict the value.
+func matchesAnyRegex(value string, regexes []string) bool {
+	if len(regexes) == 0 {
+		return true
+	}
+	for _, r := range regexes {
+		matched, err := regexp.MatchString(r, value)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func nonEmptyValues(values []string) []string {
+	var result []string
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			result = append(result, strings.TrimSpace(value))
+		}
+	}
+	return result
+}
+
+func isKeyAllowed(req *certificate.Request, allowed []endpoint.AllowedKeyConfiguration) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, keyConfig := range allowed {
+		if keyConfig.KeyType != req.KeyType {
+			continue
+		}
+		switch req.KeyType {
+		case certificate.KeyTypeRSA:
+			for _, size := range keyConfig.KeySizes {
+				if size == req.KeyLength {
+					return true
+				}
+			}
+		case certificate.KeyTypeECDSA:
+			for _, curve := range keyConfig.KeyCurves {
+				if curve == req.KeyCurve {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// validateRequestAgainstZone applies the zone defaults to the request like vcert does before
+// enrollment, then checks every field against the zone policy and returns all violations
+func validateRequestAgainstZone(zoneConfig *endpoint.ZoneConfiguration, req *certificate.Request) []PolicyViolation {
+	zoneConfig.UpdateCertificateRequest(req)
+	violations := []PolicyViolation{}
+	addViolation := func(field, value, message string, params ...interface{}) {
+		violations = append(violations, PolicyViolation{Field: field, Value: value, Message: fmt.Sprintf(message, params...)})
+	}
+
+	if !matchesAnyRegex(req.Subject.CommonName, zoneConfig.SubjectCNRegexes) {
+		addViolation("CommonName", req.Subject.CommonName, "common name is not allowed in this zone: %v", zoneConfig.SubjectCNRegexes)
+	}
+	if !zoneConfig.AllowWildcards && strings.HasPrefix(req.Subject.CommonName, "*") {
+		addViolation("CommonName", req.Subject.CommonName, "wildcard certificates are not allowed in this zone")
+	}
+	for _, dns := range nonEmptyValues(req.DNSNames) {
+		if !matchesAnyRegex(dns, zoneConfig.DnsSanRegExs) {
+			addViolation("DNSName", dns, "DNS SAN does not match regular expressions: %v", zoneConfig.DnsSanRegExs)
+		}
+		if !zoneConfig.AllowWildcards && strings.HasPrefix(dns, "*") {
+			addViolation("DNSName", dns, "wildcard SANs are not allowed in this zone")
+		}
+	}
+	for _, upn := range nonEmptyValues(req.UPNs) {
+		if !matchesAnyRegex(upn, zoneConfig.UpnSanRegExs) {
+			addViolation("UPN", upn, "UPN SAN does not match regular expressions: %v", zoneConfig.UpnSanRegExs)
+		}
+	}
+	for _, email := range nonEmptyValues(req.EmailAddresses) {
+		if !matchesAnyRegex(email, zoneConfig.EmailSanRegExs) {
+			addViolation("Email", email, "email SAN does not match regular expressions: %v", zoneConfig.EmailSanRegExs)
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if !matchesAnyRegex(ip.String(), zoneConfig.IpSanRegExs) {
+			addViolation("IPAddress", ip.String(), "IP SAN does not match regular expressions: %v", zoneConfig.IpSanRegExs)
+		}
+	}
+	for _, uri := range req.URIs {
+		if !matchesAnyRegex(uri.String(), zoneConfig.UriSanRegExs) {
+			addViolation("URI", uri.String(), "URI SAN does not match regular expressions: %v", zoneConfig.UriSanRegExs)
+		}
+	}
+
+	subjectFields := []struct {
+		name    string
+		values  []string
+		regexes []string
+	}{
+		{"Organization", req.Subject.Organization, zoneConfig.SubjectORegexes},
+		{"OrganizationalUnit", req.Subject.OrganizationalUnit, zoneConfig.SubjectOURegexes},
+		{"Locality", req.Subject.Locality, zoneConfig.SubjectLRegexes},
+		{"Province", req.Subject.Province, zoneConfig.SubjectSTRegexes},
+		{"Country", req.Subject.Country, zoneConfig.SubjectCRegexes},
+	}
+	for _, field := range subjectFields {
+		values := field.values
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		for _, value := range values {
+			if !matchesAnyRegex(value, field.regexes) {
+				addViolation(field.name, value, "%s does not match regular expressions: %v", field.name, field.regexes)
+			}
+		}
+	}
+
+	if !isKeyAllowed(req, zoneConfig.AllowedKeyConfigurations) {
+		addViolation("Key", fmt.Sprintf("%s %d", req.KeyType.String(), req.KeyLength), "the requested key type and size do not match any of the allowed key types and sizes")
+	}
+	return violations
+}
+
+// ValidateMachineIDRequest checks a request against the policy of the zone without submitting anything to TPP
+func (c *venafiConnector) ValidateMachineIDRequest(ctx context.Context, cn string, upn []string, dns []string) (string, error) {
+	defer c.audited(VALIDATE_MID_TYPE, "")()
+	c.auditCertificate(cn, "")
+	if err := c.authorize(VALIDATE_MID_TYPE, getPolicyDN(c.zone)); err != nil {
+		return c.fail(err), nil
+	}
+	zoneConfig, err := c.client.ReadZoneConfiguration()
+	if err != nil {
+		log.Errorf("Failed to read zone configuration: %v", err)
+		return c.fail(err), nil
+	}
+	violations := validateRequestAgainstZone(zoneConfig, newEnrollRequest(cn, upn, dns))
+	guardrails, err := guardrailViolations(c.zone, cn, upn, dns)
+	if err != nil {
+		log.Errorf("Failed to check guardrail policy: %v", err)
+		return c.fail(err), nil
+	}
+	violations = append(violations, guardrails...)
+	responseObject := ValidateMachineIDRequestResponse{Valid: len(violations) == 0, Zone: c.zone, Violations: violations}
+	data, err := json.Marshal(responseObject)
+	if err != nil {
+		log.Errorf("Failed to serialize validation result: %v", err)
+		return c.fail(err), err
+	}
+	return createSnowflakeResponseWithEscape(data), nil
+}
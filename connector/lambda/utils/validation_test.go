@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRequestAgainstZoneCollectsAllViolations(t *testing.T) {
+	zoneConfig := &endpoint.ZoneConfiguration{
+		Policy: endpoint.Policy{
+			SubjectCNRegexes: []string{`^.*\.example\.com$`},
+			DnsSanRegExs:     []string{`^.*\.example\.com$`},
+			UpnSanRegExs:     []string{`@example\.com$`},
+			IpSanRegExs:      []string{`^10\.`},
+			AllowedKeyConfigurations: []endpoint.AllowedKeyConfiguration{
+				{KeyType: certificate.KeyTypeRSA, KeySizes: []int{4096}},
+			},
+		},
+	}
+	req := newEnrollRequest("*.other.org", []string{"svc@other.org"}, []string{"api.example.com", "api.other.org"})
+	req.IPAddresses = []net.IP{net.ParseIP("192.168.0.1")}
+	violations := validateRequestAgainstZone(zoneConfig, req)
+
+	fields := []string{}
+	for _, violation := range violations {
+		fields = append(fields, violation.Field)
+	}
+	assert.Equal(t, []string{"CommonName", "CommonName", "DNSName", "UPN", "IPAddress", "Key"}, fields)
+	assert.Equal(t, "api.other.org", violations[2].Value)
+	assert.Equal(t, "svc@other.org", violations[3].Value)
+	assert.Equal(t, "RSA 2048", violations[5].Value)
+}
+
+func TestValidateRequestAgainstZoneDefaults(t *testing.T) {
+	zoneConfig := &endpoint.ZoneConfiguration{
+		Organization: "Venafi",
+		Policy: endpoint.Policy{
+			SubjectORegexes: []string{`^Venafi$`},
+			SubjectCRegexes: []string{`^US$`},
+		},
+	}
+	// the organization is filled in from the zone like before enrollment, the missing country is reported
+	violations := validateRequestAgainstZone(zoneConfig, newEnrollRequest("app.example.com", []string{""}, []string{""}))
+	assert.Len(t, violations, 1)
+	assert.Equal(t, "Country", violations[0].Field)
+}
+
+func TestValidateRequestAgainstZoneValid(t *testing.T) {
+	zoneConfig := &endpoint.ZoneConfiguration{
+		Policy: endpoint.Policy{
+			SubjectCNRegexes: []string{`^.*\.example\.com$`},
+			AllowWildcards:   true,
+		},
+	}
+	req := newEnrollRequest("*.example.com", []string{""}, []string{""})
+	assert.Empty(t, validateRequestAgainstZone(zoneConfig, req))
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/policy"
+	log "github.com/palette-software/go-log-targets"
+)
+
+type ZoneKeyConfiguration struct {
+	KeyType   string   `json:"KeyType"`
+	KeySizes  []int    `json:"KeySizes,omitempty"`
+	KeyCurves []string `json:"KeyCurves,omitempty"`
+}
+
+type ZoneSubject struct {
+	Organization       string   `json:"Organization,omitempty"`
+	OrganizationalUnit []string `json:"OrganizationalUnit,omitempty"`
+	Locality           string   `json:"Locality,omitempty"`
+	Province           string   `json:"Province,omitempty"`
+	Country            string   `json:"Country,omitempty"`
+}
+
+type GetZonePolicyResponse struct {
+	Zone                 string                  `json:"Zone"`
+	AllowedDomains       []string                `json:"AllowedDomains"`
+	AllowWildcards       bool                    `json:"AllowWildcards"`
+	AllowKeyReuse        bool                    `json:"AllowKeyReuse"`
+	KeyConfigurations    []ZoneKeyConfiguration  `json:"KeyConfigurations"`
+	DefaultKey           *ZoneKeyConfiguration   `json:"DefaultKey,omitempty"`
+	SubjectCNRegexes     []string                `json:"SubjectCNRegexes"`
+	DnsSanRegexes        []string                `json:"DnsSanRegexes"`
+	IpSanRegexes         []string                `json:"IpSanRegexes"`
+	EmailSanRegexes      []string                `json:"EmailSanRegexes"`
+	UriSanRegexes        []string                `json:"UriSanRegexes"`
+	UpnSanRegexes        []string                `json:"UpnSanRegexes"`
+	AllowedSanTypes      *policy.SubjectAltNames `json:"AllowedSanTypes,omitempty"`
+	LockedSubject        *policy.Subject         `json:"LockedSubject,omitempty"` // subject fields which cannot be changed by the request
+	DefaultSubject       ZoneSubject             `json:"DefaultSubject"`
+	ServiceGeneratedKey  *bool                   `json:"ServiceGeneratedKey,omitempty"`
+	MaxValidDays         *int                    `json:"MaxValidDays,omitempty"`
+	CertificateAuthority string                  `json:"CertificateAuthority,omitempty"`
+}
+
+func toZoneKeyConfiguration(keyConfig endpoint.AllowedKeyConfiguration) ZoneKeyConfiguration {
+	zoneKey := ZoneKeyConfiguration{
+		KeyType:  keyConfig.KeyType.String(),
+		KeySizes: keyConfig.KeySizes,
+	}
+	for _, curve := range keyConfig.KeyCurves {
+		zoneKey.KeyCurves = append(zoneKey.KeyCurves, curve.String())
+	}
+	return zoneKey
+}
+
+// GetZonePolicy returns what the zone of the connector allows, combining the zone configuration
+// used for requests and the policy specification of the policy folder
+func (c *venafiConnector) GetZonePolicy(ctx context.Context) (string, error) {
+	defer c.audited(ZONE_POLICY_TYPE, "")()
+	if err := c.authorize(ZONE_POLICY_TYPE, getPolicyDN(c.zone)); err != nil {
+		return c.fail(err), nil
+	}
+	zoneConfig, err := c.client.ReadZoneConfiguration()
+	if err != nil {
+		log.Errorf("Failed to read zone configuration: %v", err)
+		return c.fail(err), nil
+	}
+	policySpec, err := c.client.GetPolicy(c.zone)
+	if err != nil {
+		log.Errorf("Failed to read policy of zone: %v", err)
+		return c.fail(err), nil
+	}
+
+	response := GetZonePolicyResponse{
+		Zone:             c.zone,
+		AllowWildcards:   zoneConfig.AllowWildcards,
+		AllowKeyReuse:    zoneConfig.AllowKeyReuse,
+		SubjectCNRegexes: zoneConfig.SubjectCNRegexes,
+		DnsSanRegexes:    zoneConfig.DnsSanRegExs,
+		IpSanRegexes:     zoneConfig.IpSanRegExs,
+		EmailSanRegexes:  zoneConfig.EmailSanRegExs,
+		UriSanRegexes:    zoneConfig.UriSanRegExs,
+		UpnSanRegexes:    zoneConfig.UpnSanRegExs,
+		DefaultSubject: ZoneSubject{
+			Organization:       zoneConfig.Organization,
+			OrganizationalUnit: zoneConfig.OrganizationalUnit,
+			Locality:           zoneConfig.Locality,
+			Province:           zoneConfig.Province,
+			Country:            zoneConfig.Country,
+		},
+	}
+	for _, keyConfig := range zoneConfig.AllowedKeyConfigurations {
+		response.KeyConfigurations = append(response.KeyConfigurations, toZoneKeyConfiguration(keyConfig))
+	}
+	if zoneConfig.KeyConfiguration != nil {
+		defaultKey := toZoneKeyConfiguration(*zoneConfig.KeyConfiguration)
+		response.DefaultKey = &defaultKey
+	}
+	if policySpec.Policy != nil {
+		response.AllowedDomains = policySpec.Policy.Domains
+		response.LockedSubject = policySpec.Policy.Subject
+		response.AllowedSanTypes = policySpec.Policy.SubjectAltNames
+		response.MaxValidDays = policySpec.Policy.MaxValidDays
+		if policySpec.Policy.CertificateAuthority != nil {
+			response.CertificateAuthority = *policySpec.Policy.CertificateAuthority
+		}
+		if policySpec.Policy.KeyPair != nil {
+			response.ServiceGeneratedKey = policySpec.Policy.KeyPair.ServiceGenerated
+		}
+	}
+
+	bytes, err := json.Marshal(response)
+	if err != nil {
+		log.Errorf("Failed to serialize zone policy: %v", err)
+		return c.fail(err), err
+	}
+	return createSnowflakeResponseWithEscape(bytes), nil
+}
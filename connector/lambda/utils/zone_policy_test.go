@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Venafi/vcert/v4/pkg/certificate"
+	"github.com/Venafi/vcert/v4/pkg/endpoint"
+	"github.com/Venafi/vcert/v4/pkg/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+// zonePolicyConnector overrides ReadZoneConfiguration and GetPolicy of vcert's connector
+type zonePolicyConnector struct {
+	endpoint.Connector
+	zoneConfig *endpoint.ZoneConfiguration
+	policySpec *policy.PolicySpecification
+	err        error
+	policyName string
+}
+
+func (c *zonePolicyConnector) ReadZoneConfiguration() (*endpoint.ZoneConfiguration, error) {
+	return c.zoneConfig, c.err
+}
+
+func (c *zonePolicyConnector) GetPolicy(name string) (*policy.PolicySpecification, error) {
+	c.policyName = name
+	return c.policySpec, nil
+}
+
+// parseZonePolicyResponse reads the policy from the Snowflake row of GetZonePolicy
+func parseZonePolicyResponse(t *testing.T, response string) GetZonePolicyResponse {
+	assert.True(t, strings.HasPrefix(response, "{'data': [[0, '"))
+	data := strings.TrimSuffix(strings.TrimPrefix(response, "{'data': [[0, '"), "']]}")
+	var zonePolicy GetZonePolicyResponse
+	assert.Nil(t, json.Unmarshal([]byte(strings.ReplaceAll(data, "\\\\", "\\")), &zonePolicy))
+	return zonePolicy
+}
+
+func TestGetZonePolicy(t *testing.T) {
+	maxValidDays, serviceGenerated, ca := 90, false, "\\VED\\Policy\\CA Templates\\Internal"
+	client := &zonePolicyConnector{
+		zoneConfig: &endpoint.ZoneConfiguration{
+			Organization:       "Venafi",
+			OrganizationalUnit: []string{"DevOps"},
+			Country:            "US",
+			Policy: endpoint.Policy{
+				SubjectCNRegexes: []string{".*\\.example\\.com"},
+				DnsSanRegExs:     []string{".*\\.example\\.com"},
+				AllowedKeyConfigurations: []endpoint.AllowedKeyConfiguration{
+					{KeyType: certificate.KeyTypeRSA, KeySizes: []int{2048, 4096}},
+					{KeyType: certificate.KeyTypeECDSA, KeyCurves: []certificate.EllipticCurve{certificate.EllipticCurveP256}},
+				},
+				AllowWildcards: true,
+			},
+			KeyConfiguration: &endpoint.AllowedKeyConfiguration{KeyType: certificate.KeyTypeRSA, KeySizes: []int{2048}},
+		},
+		policySpec: &policy.PolicySpecification{Policy: &policy.Policy{
+			Domains:              []string{"example.com"},
+			MaxValidDays:         &maxValidDays,
+			CertificateAuthority: &ca,
+			KeyPair:              &policy.KeyPair{ServiceGenerated: &serviceGenerated},
+		}},
+	}
+	c := &venafiConnector{client: client, zone: "\\VED\\Policy\\Web"}
+
+	response, err := c.GetZonePolicy(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "\\VED\\Policy\\Web", client.policyName)
+	zonePolicy := parseZonePolicyResponse(t, response)
+	assert.Equal(t, "\\VED\\Policy\\Web", zonePolicy.Zone)
+	assert.Equal(t, []string{"example.com"}, zonePolicy.AllowedDomains)
+	assert.True(t, zonePolicy.AllowWildcards)
+	assert.False(t, zonePolicy.AllowKeyReuse)
+	assert.Equal(t, []ZoneKeyConfiguration{
+		{KeyType: "RSA", KeySizes: []int{2048, 4096}},
+		{KeyType: "ECDSA", KeyCurves: []string{"P256"}},
+	}, zonePolicy.KeyConfigurations)
+	assert.Equal(t, &ZoneKeyConfiguration{KeyType: "RSA", KeySizes: []int{2048}}, zonePolicy.DefaultKey)
+	assert.Equal(t, []string{".*\\.example\\.com"}, zonePolicy.SubjectCNRegexes)
+	assert.Equal(t, []string{".*\\.example\\.com"}, zonePolicy.DnsSanRegexes)
+	assert.Equal(t, ZoneSubject{Organization: "Venafi", OrganizationalUnit: []string{"DevOps"}, Country: "US"}, zonePolicy.DefaultSubject)
+	assert.Equal(t, 90, *zonePolicy.MaxValidDays)
+	assert.False(t, *zonePolicy.ServiceGeneratedKey)
+	assert.Equal(t, ca, zonePolicy.CertificateAuthority)
+}
+
+func TestGetZonePolicyWithoutPolicySpecification(t *testing.T) {
+	client := &zonePolicyConnector{zoneConfig: &endpoint.ZoneConfiguration{}, policySpec: &policy.PolicySpecification{}}
+	c := &venafiConnector{client: client, zone: "Web"}
+
+	response, err := c.GetZonePolicy(context.Background())
+	assert.Nil(t, err)
+	zonePolicy := parseZonePolicyResponse(t, response)
+	assert.Equal(t, "Web", zonePolicy.Zone)
+	assert.Nil(t, zonePolicy.AllowedDomains)
+	assert.Nil(t, zonePolicy.DefaultKey)
+	assert.Nil(t, zonePolicy.MaxValidDays)
+	assert.Empty(t, zonePolicy.CertificateAuthority)
+}
+
+func TestGetZonePolicyReadZoneConfigurationFails(t *testing.T) {
+	storage := useMemoryAuditTrail(t)
+	client := &zonePolicyConnector{err: fmt.Errorf("zone not found")}
+	c := &venafiConnector{client: client, zone: "\\VED\\Policy\\Missing"}
+
+	response, err := c.GetZonePolicy(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "{'data': [[0, 'zone not found']]}", response)
+	assert.Empty(t, client.policyName)
+	records := auditLines(t, storage)
+	assert.Len(t, records, 1)
+	assert.Equal(t, AUDIT_OUTCOME_FAILED, records[0].Outcome)
+}